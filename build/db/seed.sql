@@ -35,27 +35,41 @@ VALUES
 -- Tasks
 -- ---------------------------------------------------------
 INSERT INTO tasks
-  (teamid, title, description, author, assignee, status, priority, deadline)
+  (teamid, title, description, author, status, priority, deadline)
 VALUES
   (1, 'Setup CI pipeline',
       'Configure CI for all services',
-      'alice', 'bob', 'IN_PROGRESS', 'HIGH', now() + interval '5 days'),
+      'alice', 'IN_PROGRESS', 'HIGH', now() + interval '5 days'),
 
   (1, 'Dockerize services',
       'Ensure all services build via Docker',
-      'alice', 'charlie', 'TODO', 'MEDIUM', now() + interval '7 days'),
+      'alice', 'TODO', 'MEDIUM', now() + interval '7 days'),
 
   (2, 'Design login page',
       'Create responsive login page',
-      'diana', 'alice', 'DONE', 'LOW', now() - interval '1 day'),
+      'diana', 'DONE', 'LOW', now() - interval '1 day'),
 
   (2, 'Improve UX',
       'Enhance dashboard UX',
-      'diana', 'alice', 'IN_PROGRESS', 'MEDIUM', now() + interval '3 days'),
+      'diana', 'IN_PROGRESS', 'MEDIUM', now() + interval '3 days'),
 
   (3, 'Task API refactor',
       'Clean up task handlers and validation',
-      'admin', 'bob', 'TODO', 'HIGH', now() + interval '10 days');
+      'admin', 'TODO', 'HIGH', now() + interval '10 days');
+
+INSERT INTO task_assignees (taskid, username)
+VALUES
+  (1, 'bob'),
+  (1, 'charlie'),
+  (2, 'charlie'),
+  (3, 'alice'),
+  (4, 'alice'),
+  (5, 'bob');
+
+INSERT INTO task_watchers (taskid, username)
+VALUES
+  (1, 'alice'),
+  (5, 'charlie');
 
 -- ---------------------------------------------------------
 -- Comments
@@ -2,6 +2,7 @@ package authmw
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sort"
@@ -186,6 +187,9 @@ func (s *Service) SetUserEnabled(
 	return s.Client.UpdateUser(ctx, token, s.Realm, *user)
 }
 
+// ErrUserNotFound is returned by GetUserByUsername when no user has the username.
+var ErrUserNotFound = errors.New("user not found")
+
 func (s *Service) GetUserByUsername(ctx context.Context, token, username string) (*gocloak.User, error) {
 	users, err := s.Client.GetUsers(ctx, token, s.Realm, gocloak.GetUsersParams{
 		Username: gocloak.StringP(username),
@@ -196,7 +200,7 @@ func (s *Service) GetUserByUsername(ctx context.Context, token, username string)
 		return nil, err
 	}
 	if len(users) == 0 {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	if len(users) > 1 {
 		return nil, fmt.Errorf("multiple users matched username")
@@ -2,7 +2,10 @@ package front
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	auth "kyri56xcaesar/pms-proj/internal/authmw"
@@ -371,12 +374,9 @@ func adminTeamsHandler(c *gin.Context) {
 	for _, t := range teams {
 		ts := tasksByTeam[t.TeamID]
 
-		counts := map[string]int{"TODO": 0, "IN_PROGRESS": 0, "DONE": 0}
+		counts := countStatuses(ts, t.Workflow)
 		preview := make([]string, 0, 5)
 		for _, task := range ts {
-			if _, ok := counts[task.Status]; ok {
-				counts[task.Status]++
-			}
 			if len(preview) < 5 {
 				preview = append(preview, task.Title)
 			}
@@ -411,3 +411,81 @@ func adminTeamsHandler(c *gin.Context) {
 		"VM":     vm,
 	})
 }
+
+// adminTrashHandler lists the deleted teams and tasks that can still be restored.
+func adminTrashHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	teams, err := ds.TrashedTeams(c.Request.Context(), bearer)
+	if err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
+		return
+	}
+	tasks, err := ds.TrashedTasks(c.Request.Context(), bearer)
+	if err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	var vm AdminTrashVM
+	vm.Title = "Admin Trash"
+	vm.Active = "admin-trash"
+	vm.Teams = teams.Items
+	vm.Tasks = tasks.Items
+	vm.RetentionDays = tasks.RetentionDays
+
+	vm.User.Username = c.GetString("kc.username")
+	vm.User.Email = c.GetString("kc.email")
+	vm.User.Roles = c.GetStringSlice("kc.roles")
+	vm.User.IsAdmin = true
+	vm.User.Firstname = c.GetString("kc.firstname")
+	vm.User.Lastname = c.GetString("kc.lastname")
+
+	c.HTML(http.StatusOK, "layout.html", gin.H{
+		"Title":  vm.Title,
+		"Active": vm.Active,
+		"User":   vm.User,
+		"Page":   "pages_admin/admin_trash.html",
+		"VM":     vm,
+	})
+}
+
+func restoreTeamHandler(c *gin.Context) {
+	restoreFromTrash(c, "TeamAPI: ", ds.TeamBase+"/admin/teams/%d/restore", c.Param("teamid"))
+}
+
+func restoreTaskHandler(c *gin.Context) {
+	restoreFromTrash(c, "TaskAPI: ", ds.TaskBase+"/admin/tasks/%d/restore", c.Param("id"))
+}
+
+// restoreFromTrash posts to the restore endpoint urlFormat of the team or task
+// idStr and goes back to the trash page.
+func restoreFromTrash(c *gin.Context, prefix, urlFormat, idStr string) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid id"})
+		return
+	}
+
+	if err := ds.PostJSON(c.Request.Context(), bearer, fmt.Sprintf(urlFormat, id), nil, nil); err != nil {
+		var de *DownstreamError
+		if errors.As(err, &de) && de.Status >= 400 && de.Status < 500 {
+			c.HTML(de.Status, "error.html", gin.H{"error": de.Message()})
+			return
+		}
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": prefix + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/api/v1/auth/admin/trash")
+}
@@ -104,9 +104,14 @@ func setTemplateEngine() {
 		},
 		"joinUsernames": joinUsernames,
 		"joinTitles":    joinTitles,
+		"joinCounts":    joinCounts,
 		"joinStrings": func(ss []string) string {
 			return strings.Join(ss, ",")
 		},
+		"joinLines": func(ss []string) string {
+			return strings.Join(ss, "\n")
+		},
+		"minutes": formatMinutes,
 	}
 	t := template.New("").Funcs(funcMap)
 
@@ -170,10 +175,28 @@ func setRoutes() {
 		verified.GET("/dashboard", dashboardHandler)
 		verified.GET("/myteams", myTeamsHandler)
 		verified.GET("/mytasks", myTasksHandler)
+		verified.GET("/teams/:id/board", teamBoardHandler)
+		verified.GET("/teams/:id/sprints", sprintsHandler)
+		verified.GET("/search", searchHandler)
+		verified.GET("/notifications", notificationsHandler)
+		verified.GET("/notifications/badge", notificationBadgeHandler)
+		verified.POST("/notifications/:id/read", readNotificationHandler)
+		verified.POST("/notifications/read-all", readAllNotificationsHandler)
+		verified.POST("/notifications/email", emailPreferencesHandler)
 
 		verified.GET("/tasks/:id/json", taskDetailJSONHandler)
 		verified.POST("/tasks/:id/status", taskStatusHandler)
+		verified.POST("/tasks/bulk", bulkTasksHandler)
 		verified.POST("/tasks/:id/comment", addCommentHandler)
+		verified.POST("/comments/:commentid/edit", editCommentHandler)
+		verified.POST("/comments/:commentid/delete", deleteCommentHandler)
+		verified.POST("/tasks/:id/checklist", addChecklistItemHandler)
+		verified.POST("/checklist/:itemid/toggle", toggleChecklistItemHandler)
+		verified.POST("/checklist/:itemid/delete", deleteChecklistItemHandler)
+		verified.POST("/tasks/:id/timer", startTimerHandler)
+		verified.POST("/timer/stop", stopTimerHandler)
+		verified.POST("/tasks/:id/time", logTimeHandler)
+		verified.POST("/tasks/:id/watch", watchTaskHandler)
 
 		leader := verified.Group("/leader")
 		leader.Use(kcAuth.RequireRoles("leader", "admin"))
@@ -181,8 +204,28 @@ func setRoutes() {
 			leader.POST("/teams/edit", editTeamHandler)
 			leader.POST("/teams/member/add", addMemberHandler)
 			leader.POST("/teams/member/remove", removeMemberHandler)
+			leader.POST("/teams/workflow", editWorkflowHandler)
+			leader.POST("/teams/labels", createLabelHandler)
+			leader.POST("/teams/labels/edit", editLabelHandler)
+			leader.POST("/teams/labels/delete", deleteLabelHandler)
+			leader.POST("/teams/templates", createTemplateHandler)
+			leader.POST("/teams/templates/edit", editTemplateHandler)
+			leader.POST("/teams/templates/delete", deleteTemplateHandler)
 
 			leader.POST("/tasks/create", kcAuth.RequireRoles("leader", "admin"), createTaskHandler)
+			leader.POST("/tasks/:id/subtasks", addSubtaskHandler)
+			leader.POST("/tasks/:id/blockers", addBlockerHandler)
+			leader.POST("/tasks/:id/labels", setTaskLabelsHandler)
+			leader.POST("/dependencies/delete", removeDependencyHandler)
+			leader.POST("/tasks/:id/recurrence", setRecurrenceHandler)
+			leader.POST("/tasks/:id/recurrence/state", recurrenceStateHandler)
+			leader.POST("/tasks/:id/estimate", setEstimateHandler)
+			leader.POST("/tasks/:id/milestone", setTaskMilestoneHandler)
+			leader.POST("/tasks/:id/assignees", setAssigneesHandler)
+			leader.POST("/sprints", createSprintHandler)
+			leader.POST("/sprints/:id/edit", editSprintHandler)
+			leader.POST("/sprints/:id/state", sprintStateHandler)
+			leader.POST("/sprints/:id/delete", deleteSprintHandler)
 		}
 
 		admin := verified.Group("/admin")
@@ -192,6 +235,9 @@ func setRoutes() {
 			admin.GET("/teams", adminTeamsHandler)
 			admin.POST("/teams/create", createTeamHandler)
 			admin.POST("/teams/:teamid/delete", deleteTeamHandler)
+			admin.GET("/trash", adminTrashHandler)
+			admin.POST("/trash/teams/:teamid/restore", restoreTeamHandler)
+			admin.POST("/trash/tasks/:id/restore", restoreTaskHandler)
 
 			admin.GET("/users/:id", handleAdminGetUserByID)
 			admin.POST("/users/:id/roles", handleAdminSetUserRoles)
@@ -339,3 +385,11 @@ func joinUsernames(m []TeamMember) string {
 func joinTitles(t []string) string {
 	return strings.Join(t, "|")
 }
+
+func joinCounts(counts []StatusCount) string {
+	out := make([]string, 0, len(counts))
+	for _, sc := range counts {
+		out = append(out, fmt.Sprintf("%s:%d", sc.Status, sc.Count))
+	}
+	return strings.Join(out, "|")
+}
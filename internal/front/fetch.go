@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
 )
 
 type Downstream struct {
@@ -15,6 +17,29 @@ type Downstream struct {
 	Client   *http.Client
 }
 
+// DownstreamError is returned when a backend service answers with a non 2xx status.
+type DownstreamError struct {
+	Method string
+	URL    string
+	Status int
+	Body   string
+}
+
+func (e *DownstreamError) Error() string {
+	return fmt.Sprintf("%s %s -> %d: %s", e.Method, e.URL, e.Status, e.Body)
+}
+
+// Message returns the "error" field of a JSON body, or the raw body.
+func (e *DownstreamError) Message() string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(e.Body), &body); err == nil && body.Error != "" {
+		return body.Error
+	}
+	return e.Body
+}
+
 func (d *Downstream) doJSON(ctx context.Context, method, url, bearer string, out any) error {
 	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
@@ -32,85 +57,52 @@ func (d *Downstream) doJSON(ctx context.Context, method, url, bearer string, out
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("downstream %s %s -> %d: %s", method, url, resp.StatusCode, string(b))
+		return &DownstreamError{Method: method, URL: url, Status: resp.StatusCode, Body: string(b)}
 	}
 
 	return json.NewDecoder(resp.Body).Decode(out)
 }
 
 func (d *Downstream) PatchJSON(ctx context.Context, bearer, url string, body any, out any) error {
-	b, err := json.Marshal(body)
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+bearer)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := d.Client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bb, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("POST %s -> %d: %s", url, resp.StatusCode, string(bb))
-	}
-	if out == nil {
-		return nil
-	}
-	return json.NewDecoder(resp.Body).Decode(out)
+	return d.sendJSON(ctx, "PATCH", bearer, url, "", body, out)
 }
 
 func (d *Downstream) PostJSON(ctx context.Context, bearer, url string, body any, out any) error {
-	b, err := json.Marshal(body)
-	if err != nil {
-		return err
-	}
+	return d.sendJSON(ctx, "POST", bearer, url, "", body, out)
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+bearer)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+func (d *Downstream) PutJSON(ctx context.Context, bearer, url string, body any, out any) error {
+	return d.sendJSON(ctx, "PUT", bearer, url, "", body, out)
+}
 
-	resp, err := d.Client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// PatchJSONIfMatch is PatchJSON made against the representation tagged etag;
+// a stale etag comes back as a DownstreamError with status 412.
+func (d *Downstream) PatchJSONIfMatch(ctx context.Context, bearer, url, etag string, body any, out any) error {
+	return d.sendJSON(ctx, "PATCH", bearer, url, etag, body, out)
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bb, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("POST %s -> %d: %s", url, resp.StatusCode, string(bb))
-	}
-	if out == nil {
-		return nil
-	}
-	return json.NewDecoder(resp.Body).Decode(out)
+// PutJSONIfMatch is PutJSON made against the representation tagged etag;
+// a stale etag comes back as a DownstreamError with status 412.
+func (d *Downstream) PutJSONIfMatch(ctx context.Context, bearer, url, etag string, body any, out any) error {
+	return d.sendJSON(ctx, "PUT", bearer, url, etag, body, out)
 }
 
-func (d *Downstream) PutJSON(ctx context.Context, bearer, url string, body any, out any) error {
+func (d *Downstream) sendJSON(ctx context.Context, method, bearer, url, etag string, body any, out any) error {
 	b, err := json.Marshal(body)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(b))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Authorization", "Bearer "+bearer)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
 
 	resp, err := d.Client.Do(req)
 	if err != nil {
@@ -120,7 +112,7 @@ func (d *Downstream) PutJSON(ctx context.Context, bearer, url string, body any,
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		bb, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("PUT %s -> %d: %s", url, resp.StatusCode, string(bb))
+		return &DownstreamError{Method: method, URL: url, Status: resp.StatusCode, Body: string(bb)}
 	}
 	if out == nil {
 		return nil
@@ -144,46 +136,142 @@ func (d *Downstream) Delete(ctx context.Context, bearer, url string) error {
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		bb, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("DELETE %s -> %d: %s", url, resp.StatusCode, string(bb))
+		return &DownstreamError{Method: "DELETE", URL: url, Status: resp.StatusCode, Body: string(bb)}
 	}
 	return nil
 }
 
+// MyTeams returns every team of the caller, following next_cursor.
 func (d *Downstream) MyTeams(ctx context.Context, bearer string) (TeamListResponse, error) {
-	var teams TeamListResponse
-	err := d.doJSON(
-		ctx,
-		"GET",
-		d.TeamBase+"/auth/my-teams",
-		bearer,
-		&teams)
+	teams := TeamListResponse{Limit: 200}
+	items, rest, err := followCursor(func(cursor string) ([]Team, string, error) {
+		var page TeamListResponse
+		err := d.doJSON(
+			ctx,
+			"GET",
+			d.TeamBase+"/auth/my-teams?limit=200"+cursorParam(cursor),
+			bearer,
+			&page)
+		return page.Items, page.NextCursor, err
+	})
+	teams.Items = items
+	teams.NextCursor = rest
 	return teams, err
 }
 
-// expects TaskAPI: GET /auth/tasks?teamId=...
+// TeamTasks returns every task of the team, following next_cursor of
+// TaskAPI: GET /auth/tasks?teamid=...
 func (d *Downstream) TeamTasks(ctx context.Context, bearer string, teamID int64) (TaskListResponse, error) {
+	tasks := TaskListResponse{Limit: 100}
+	items, rest, err := followCursor(func(cursor string) ([]Task, string, error) {
+		var page TaskListResponse
+		url := fmt.Sprintf("%s/auth/tasks?teamid=%v&limit=100%s", d.TaskBase, teamID, cursorParam(cursor))
+		err := d.doJSON(ctx, "GET", url, bearer, &page)
+		tasks.Order = page.Order
+		return page.Items, page.NextCursor, err
+	})
+	tasks.Items = items
+	tasks.NextCursor = rest
+	return tasks, err
+}
+
+// expects TaskAPI: GET /auth/mytask?status=&priority=&due_from=&due_to=&order=&cursor=
+func (d *Downstream) MyTasks(ctx context.Context, bearer string, q url.Values) (TaskListResponse, error) {
 	var tasks TaskListResponse
-	url := fmt.Sprintf("%s/auth/tasks?teamid=%v", d.TaskBase, teamID)
+	url := fmt.Sprintf("%s/auth/mytask?%s", d.TaskBase, q.Encode())
 	err := d.doJSON(ctx, "GET", url, bearer, &tasks)
 	return tasks, err
 }
 
+// maxPages bounds how many pages followCursor fetches for a single request
+const maxPages = 50
+
+// followCursor calls fetch with the cursor of the previous page, starting
+// with an empty one, and collects the items until the last page. Past
+// maxPages it stops and returns the cursor of the rest, which is empty when
+// the items are complete; callers keep it as their NextCursor so that pages
+// can tell the list is cut.
+func followCursor[T any](fetch func(cursor string) ([]T, string, error)) ([]T, string, error) {
+	out := make([]T, 0, 64)
+	cursor := ""
+	for range maxPages {
+		items, next, err := fetch(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		out = append(out, items...)
+		if next == "" {
+			return out, "", nil
+		}
+		cursor = next
+	}
+	log.Printf("stopped following the cursor after %d pages (%d items), the rest is left out", maxPages, len(out))
+	return out, cursor, nil
+}
+
+// cursorParam is the query parameter appended to a URL that already has a query.
+func cursorParam(cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	return "&cursor=" + url.QueryEscape(cursor)
+}
+
+// AllMyTasks follows next_cursor of /auth/mytask until the last page, the
+// cursor returned is that of the tasks left out past maxPages.
+func (d *Downstream) AllMyTasks(ctx context.Context, bearer string, filters url.Values) ([]Task, string, error) {
+	q := url.Values{}
+	for k, v := range filters {
+		q[k] = v
+	}
+	q.Set("limit", "100")
+
+	return followCursor(func(cursor string) ([]Task, string, error) {
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		page, err := d.MyTasks(ctx, bearer, q)
+		return page.Items, page.NextCursor, err
+	})
+}
+
 type TasksByTeamsReq struct {
 	TeamIDs []int64 `json:"teamids"`
 	Limit   int     `json:"limit"`
 }
 
 type ItemsResponse[T any] struct {
-	Items []T `json:"items"`
-	Limit int `json:"limit,omitempty"`
+	Items      []T    `json:"items"`
+	Limit      int    `json:"limit,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
+// AdminTeams returns every team, following next_cursor.
 func (d *Downstream) AdminTeams(ctx context.Context, bearer string) (ItemsResponse[Team], error) {
-	var resp ItemsResponse[Team]
-	err := d.doJSON(ctx, "GET", d.TeamBase+"/admin/teams", bearer, &resp)
+	resp := ItemsResponse[Team]{Limit: 200}
+	items, rest, err := followCursor(func(cursor string) ([]Team, string, error) {
+		var page ItemsResponse[Team]
+		err := d.doJSON(ctx, "GET", d.TeamBase+"/admin/teams?limit=200"+cursorParam(cursor), bearer, &page)
+		return page.Items, page.NextCursor, err
+	})
+	resp.Items = items
+	resp.NextCursor = rest
 	return resp, err
 }
 
+// TeamByID looks a single team up through the admin listing; admins only.
+func (d *Downstream) TeamByID(ctx context.Context, bearer string, teamID int64) (Team, error) {
+	var resp ItemsResponse[Team]
+	url := fmt.Sprintf("%s/admin/teams?teamid=%d", d.TeamBase, teamID)
+	if err := d.doJSON(ctx, "GET", url, bearer, &resp); err != nil {
+		return Team{}, err
+	}
+	if len(resp.Items) == 0 {
+		return Team{}, &DownstreamError{Method: "GET", URL: url, Status: http.StatusNotFound, Body: `{"error":"team not found"}`}
+	}
+	return resp.Items[0], nil
+}
+
 func (d *Downstream) TaskByID(ctx context.Context, bearer string, taskID int64) (Task, error) {
 	var out Task
 	url := fmt.Sprintf("%s/auth/tasks/%d", d.TaskBase, taskID)
@@ -191,9 +279,145 @@ func (d *Downstream) TaskByID(ctx context.Context, bearer string, taskID int64)
 	return out, err
 }
 
-func (d *Downstream) CommentsByTaskID(ctx context.Context, bearer string, taskID int64) (ItemsResponse[Comment], error) {
-	var out ItemsResponse[Comment]
-	url := fmt.Sprintf("%s/auth/comments?taskid=%d&limit=200", d.TaskBase, taskID)
+func (d *Downstream) TaskHistory(ctx context.Context, bearer string, taskID int64) (TaskHistoryResponse, error) {
+	var out TaskHistoryResponse
+	url := fmt.Sprintf("%s/auth/tasks/%d/history", d.TaskBase, taskID)
+	err := d.doJSON(ctx, "GET", url, bearer, &out)
+	return out, err
+}
+
+// CommentsByTaskID returns every comment thread of the task, following next_cursor.
+func (d *Downstream) CommentsByTaskID(ctx context.Context, bearer string, taskID int64) (CommentListResponse, error) {
+	out := CommentListResponse{Limit: 200}
+	first := true
+	items, rest, err := followCursor(func(cursor string) ([]Comment, string, error) {
+		var page CommentListResponse
+		url := fmt.Sprintf("%s/auth/comments?taskid=%d&limit=200%s", d.TaskBase, taskID, cursorParam(cursor))
+		err := d.doJSON(ctx, "GET", url, bearer, &page)
+		if first {
+			out.CanModerate = page.CanModerate
+			first = false
+		}
+		return page.Items, page.NextCursor, err
+	})
+	out.Items = items
+	out.NextCursor = rest
+	return out, err
+}
+
+// Team returns a team of the caller with its members.
+func (d *Downstream) Team(ctx context.Context, bearer string, teamID int64) (Team, error) {
+	var out Team
+	url := fmt.Sprintf("%s/auth/teams/%d", d.TeamBase, teamID)
+	err := d.doJSON(ctx, "GET", url, bearer, &out)
+	return out, err
+}
+
+func (d *Downstream) TeamLabels(ctx context.Context, bearer string, teamID int64) ([]Label, error) {
+	var out ItemsResponse[Label]
+	url := fmt.Sprintf("%s/auth/teams/%d/labels", d.TeamBase, teamID)
+	err := d.doJSON(ctx, "GET", url, bearer, &out)
+	return out.Items, err
+}
+
+// expects TaskAPI: GET /auth/search?q=&limit=
+func (d *Downstream) Search(ctx context.Context, bearer, q string) (SearchResponse, error) {
+	var out SearchResponse
+	url := fmt.Sprintf("%s/auth/search?q=%s&limit=50", d.TaskBase, url.QueryEscape(q))
+	err := d.doJSON(ctx, "GET", url, bearer, &out)
+	return out, err
+}
+
+// TrashedTeams lists the teams in the trash; admins only.
+func (d *Downstream) TrashedTeams(ctx context.Context, bearer string) (TrashListResponse[TrashedTeam], error) {
+	var out TrashListResponse[TrashedTeam]
+	err := d.doJSON(ctx, "GET", d.TeamBase+"/admin/teams/trash?limit=200", bearer, &out)
+	return out, err
+}
+
+// TrashedTasks lists the tasks in the trash; admins only.
+func (d *Downstream) TrashedTasks(ctx context.Context, bearer string) (TrashListResponse[TrashedTask], error) {
+	var out TrashListResponse[TrashedTask]
+	err := d.doJSON(ctx, "GET", d.TaskBase+"/admin/tasks/trash?limit=200", bearer, &out)
+	return out, err
+}
+
+// Templates lists the task templates of all the caller's teams.
+func (d *Downstream) Templates(ctx context.Context, bearer string) ([]TaskTemplate, error) {
+	var out ItemsResponse[TaskTemplate]
+	err := d.doJSON(ctx, "GET", d.TaskBase+"/auth/templates", bearer, &out)
+	return out.Items, err
+}
+
+// TimeEntries lists the time logged on a task, latest first.
+func (d *Downstream) TimeEntries(ctx context.Context, bearer string, taskID int64) ([]TimeEntry, error) {
+	var out ItemsResponse[TimeEntry]
+	url := fmt.Sprintf("%s/auth/tasks/%d/time", d.TaskBase, taskID)
+	err := d.doJSON(ctx, "GET", url, bearer, &out)
+	return out.Items, err
+}
+
+// RunningTimer returns the caller's running timer, nil when none runs.
+func (d *Downstream) RunningTimer(ctx context.Context, bearer string) (*TimeEntry, error) {
+	var out struct {
+		Running *TimeEntry `json:"running"`
+	}
+	err := d.doJSON(ctx, "GET", d.TaskBase+"/auth/timer", bearer, &out)
+	return out.Running, err
+}
+
+// TimeReport sums logged time for q: teamid or username, from and to.
+func (d *Downstream) TimeReport(ctx context.Context, bearer string, q url.Values) (TimeReport, error) {
+	var out TimeReport
+	err := d.doJSON(ctx, "GET", d.TaskBase+"/auth/reports/time?"+q.Encode(), bearer, &out)
+	return out, err
+}
+
+// Milestones lists the team's milestones, latest first.
+func (d *Downstream) Milestones(ctx context.Context, bearer string, teamID int64) ([]Milestone, error) {
+	var out ItemsResponse[Milestone]
+	url := fmt.Sprintf("%s/auth/milestones?teamid=%d", d.TaskBase, teamID)
 	err := d.doJSON(ctx, "GET", url, bearer, &out)
+	return out.Items, err
+}
+
+// MilestoneByID returns the milestone with its tasks and burndown.
+func (d *Downstream) MilestoneByID(ctx context.Context, bearer string, milestoneID int64) (MilestoneDetail, error) {
+	var out MilestoneDetail
+	url := fmt.Sprintf("%s/auth/milestones/%d", d.TaskBase, milestoneID)
+	err := d.doJSON(ctx, "GET", url, bearer, &out)
+	return out, err
+}
+
+// Mentions lists the latest mentions of the caller.
+func (d *Downstream) Mentions(ctx context.Context, bearer string, limit int) ([]Mention, error) {
+	var out ItemsResponse[Mention]
+	url := fmt.Sprintf("%s/auth/mentions?limit=%d", d.TaskBase, limit)
+	err := d.doJSON(ctx, "GET", url, bearer, &out)
+	return out.Items, err
+}
+
+// Notifications lists the caller's latest notifications, only the unread ones
+// with unread.
+func (d *Downstream) Notifications(ctx context.Context, bearer string, unread bool) (NotificationListResponse, error) {
+	var out NotificationListResponse
+	url := fmt.Sprintf("%s/auth/notifications?limit=100&unread=%t", d.TaskBase, unread)
+	err := d.doJSON(ctx, "GET", url, bearer, &out)
+	return out, err
+}
+
+// UnreadNotifications counts the caller's unread notifications.
+func (d *Downstream) UnreadNotifications(ctx context.Context, bearer string) (int, error) {
+	var out struct {
+		Unread int `json:"unread"`
+	}
+	err := d.doJSON(ctx, "GET", d.TaskBase+"/auth/notifications/unread", bearer, &out)
+	return out.Unread, err
+}
+
+// EmailPreferences returns the notification emails the caller wants.
+func (d *Downstream) EmailPreferences(ctx context.Context, bearer string) (EmailPreferences, error) {
+	var out EmailPreferences
+	err := d.doJSON(ctx, "GET", d.TaskBase+"/auth/notifications/email", bearer, &out)
 	return out, err
 }
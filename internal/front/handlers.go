@@ -2,14 +2,21 @@ package front
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+
+	"kyri56xcaesar/pms-proj/internal/utils"
 
 	"github.com/Nerzal/gocloak/v13"
 	"github.com/gin-gonic/gin"
@@ -200,18 +207,17 @@ func dashboardHandler(c *gin.Context) {
 	}
 
 	// Aggregate
-	counts := map[string]int{"TODO": 0, "IN_PROGRESS": 0, "DONE": 0}
+	workflows := make([]Workflow, 0, len(teams))
+	for _, t := range teams {
+		workflows = append(workflows, t.Workflow)
+	}
+	counts := countStatuses(allTasks, workflows...)
 	assigned := make([]Task, 0, 32)
 	created := make([]Task, 0, 32)
 
+	me := c.GetString("kc.username")
 	for _, task := range allTasks {
-		if _, ok := counts[task.Status]; ok {
-			counts[task.Status]++
-		} else {
-			// unknown statuses: either ignore or track separately
-		}
-
-		if task.Assignee == username {
+		if task.IsAssignee(me) {
 			assigned = append(assigned, task)
 		}
 		if task.Author == username {
@@ -245,6 +251,17 @@ func dashboardHandler(c *gin.Context) {
 	vm.CreatedByMe = created
 	vm.Teams = teams
 
+	vm.Mentions, err = ds.Mentions(c.Request.Context(), bearer, 10)
+	if err != nil {
+		log.Printf("failed to retrieve mentions: %v", err)
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	if !loadDashboardTime(c, bearer, &vm) {
+		return
+	}
+
 	c.HTML(http.StatusOK, "layout.html", gin.H{
 		"Title":  vm.Title,
 		"Active": vm.Active,
@@ -257,6 +274,55 @@ func dashboardHandler(c *gin.Context) {
 
 }
 
+// loadDashboardTime fills the time tracking part of the dashboard: the running
+// timer, my logged time and, for the teams I lead, theirs. ?from and ?to pick
+// the range, the last 7 days by default. On failure the response is already written.
+func loadDashboardTime(c *gin.Context, bearer string, vm *DashboardVM) bool {
+	ctx := c.Request.Context()
+	fail := func(err error) bool {
+		log.Printf("failed to retrieve time report: %v", err)
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return false
+	}
+
+	q := url.Values{}
+	if v := strings.TrimSpace(c.Query("from")); v != "" {
+		q.Set("from", v)
+	}
+	if v := strings.TrimSpace(c.Query("to")); v != "" {
+		q.Set("to", v)
+	}
+
+	timer, err := ds.RunningTimer(ctx, bearer)
+	if err != nil {
+		return fail(err)
+	}
+	vm.Timer = timer
+
+	if vm.MyTime, err = ds.TimeReport(ctx, bearer, q); err != nil {
+		return fail(err)
+	}
+	// the report echoes the range it used, to is exclusive
+	vm.ReportFrom = vm.MyTime.From.Format("2006-01-02")
+	vm.ReportTo = vm.MyTime.To.AddDate(0, 0, -1).Format("2006-01-02")
+
+	for _, t := range vm.Teams {
+		if t.Leader != vm.User.Username && !vm.User.IsAdmin {
+			continue
+		}
+		tq := url.Values{"teamid": {strconv.FormatInt(t.TeamID, 10)}}
+		for k, v := range q {
+			tq[k] = v
+		}
+		report, err := ds.TimeReport(ctx, bearer, tq)
+		if err != nil {
+			return fail(err)
+		}
+		vm.TeamTime = append(vm.TeamTime, TeamTimeVM{Team: t, Report: report})
+	}
+	return true
+}
+
 func logoutHandler(c *gin.Context) {
 	c.SetCookie(
 		"access_token",
@@ -335,57 +401,87 @@ func myTasksHandler(c *gin.Context) {
 		return
 	}
 
-	// 1) Get my teams (so we know which team tasks to pull)
+	// 1) Read the filters and forward them to the TaskAPI
+	filters := MyTasksFilters{
+		Status:   strings.TrimSpace(c.Query("status")),
+		Priority: strings.TrimSpace(c.Query("priority")),
+		DueFrom:  strings.TrimSpace(c.Query("due_from")),
+		DueTo:    strings.TrimSpace(c.Query("due_to")),
+		Order:    strings.TrimSpace(c.Query("order")),
+
+		Labels:     c.QueryArray("label"),
+		LabelMatch: strings.TrimSpace(c.Query("label_match")),
+	}
+	q := url.Values{}
+	if filters.Status != "" {
+		q.Set("status", filters.Status)
+	}
+	if filters.Priority != "" {
+		q.Set("priority", filters.Priority)
+	}
+	if filters.DueFrom != "" {
+		q.Set("due_from", filters.DueFrom)
+	}
+	if filters.DueTo != "" {
+		q.Set("due_to", filters.DueTo)
+	}
+	if filters.Order != "" {
+		q.Set("order", filters.Order)
+	}
+	for _, l := range filters.Labels {
+		q.Add("label", l)
+	}
+	if filters.LabelMatch != "" {
+		q.Set("label_match", filters.LabelMatch)
+	}
+
+	// 2) Tasks assigned to me across all my teams, every page
+	myTasks, rest, err := ds.AllMyTasks(c.Request.Context(), bearer, q)
+	if err != nil {
+		log.Printf("failed to retrieve tasks: %v", err)
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	// 3) Workflows of my teams, for the status selects and the counts
 	teamListResponse, err := ds.MyTeams(c.Request.Context(), bearer)
 	if err != nil {
 		log.Printf("failed to retrieve teams: %v", err)
 		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
 		return
 	}
-	teams := teamListResponse.Items
-
-	// 2) Fetch tasks for each team concurrently
-	type result struct {
-		teamID int64
-		tasks  []Task
-		err    error
-	}
-
-	sem := make(chan struct{}, 6)
-	ch := make(chan result, len(teams))
-
-	for _, t := range teams {
-		teamID := t.TeamID
-		sem <- struct{}{}
-		go func(teamID int64) {
-			defer func() { <-sem }()
-			tasksResponse, e := ds.TeamTasks(c.Request.Context(), bearer, teamID)
-			ch <- result{teamID: teamID, tasks: tasksResponse.Items, err: e}
-		}(teamID)
+	workflowByTeam := make(map[int64]Workflow, len(teamListResponse.Items))
+	workflows := make([]Workflow, 0, len(teamListResponse.Items))
+	labelNames := make([]string, 0, 16)
+	seenLabels := make(map[string]bool, 16)
+	for _, t := range teamListResponse.Items {
+		workflowByTeam[t.TeamID] = t.Workflow
+		workflows = append(workflows, t.Workflow)
+		// teams may share label names, the filter matches them by name
+		for _, l := range t.Labels {
+			if key := strings.ToLower(l.Name); !seenLabels[key] {
+				seenLabels[key] = true
+				labelNames = append(labelNames, l.Name)
+			}
+		}
 	}
+	sort.Slice(labelNames, func(i, j int) bool {
+		return strings.ToLower(labelNames[i]) < strings.ToLower(labelNames[j])
+	})
 
-	// 3) Merge + filter only tasks assigned to me
-	myTasks := make([]Task, 0, 64)
-	for i := 0; i < len(teams); i++ {
-		r := <-ch
-		if r.err != nil {
-			log.Printf("failed to retrieve tasks: %v", r.err)
-			c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + r.err.Error()})
+	var templates []TaskTemplate
+	if isLeader || isAdmin {
+		if templates, err = ds.Templates(c.Request.Context(), bearer); err != nil {
+			log.Printf("failed to retrieve templates: %v", err)
+			c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
 			return
 		}
-		for _, task := range r.tasks {
-			if task.Assignee == username {
-				myTasks = append(myTasks, task)
-			}
-		}
 	}
 
-	// Optional: counts per status (nice for header summary)
-	counts := map[string]int{"TODO": 0, "IN_PROGRESS": 0, "DONE": 0}
-	for _, t := range myTasks {
-		if _, ok := counts[t.Status]; ok {
-			counts[t.Status]++
-		}
+	counts := countStatuses(myTasks, workflows...)
+	statuses := make([]string, 0, len(counts))
+	for _, sc := range countStatuses(nil, workflows...) {
+		statuses = append(statuses, sc.Status)
 	}
 
 	// 4) Build VM
@@ -395,6 +491,12 @@ func myTasksHandler(c *gin.Context) {
 	vm.TotalTasks = len(myTasks)
 	vm.StatusCounts = counts
 	vm.Tasks = myTasks
+	vm.Workflows = workflowByTeam
+	vm.Statuses = statuses
+	vm.LabelNames = labelNames
+	vm.Templates = templates
+	vm.Filters = filters
+	vm.Truncated = rest != ""
 	vm.CanCreate = isLeader || isAdmin
 	vm.CanEdit = isLeader || isAdmin
 	vm.CanStatus = true // since verified already ensures student/admin; keep true
@@ -485,18 +587,29 @@ func myTeamsHandler(c *gin.Context) {
 		tasksByTeam[r.teamID] = r.tasks
 	}
 
+	// leaders keep task templates per team
+	templatesByTeam := make(map[int64][]TaskTemplate, len(teams))
+	if canManage {
+		templates, err := ds.Templates(c.Request.Context(), bearer)
+		if err != nil {
+			log.Printf("failed to retrieve templates: %v", err)
+			c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+			return
+		}
+		for _, t := range templates {
+			templatesByTeam[t.TeamID] = append(templatesByTeam[t.TeamID], t)
+		}
+	}
+
 	// 3) Build per-team summaries
 	rows := make([]MyTeamRowVM, 0, len(teams))
 	for _, team := range teams {
 		teamTasks := tasksByTeam[team.TeamID]
 
-		counts := map[string]int{"TODO": 0, "IN_PROGRESS": 0, "DONE": 0}
+		counts := countStatuses(teamTasks, team.Workflow)
 		preview := make([]TaskPreviewItem, 0, 5)
 
 		for _, task := range teamTasks {
-			if _, ok := counts[task.Status]; ok {
-				counts[task.Status]++
-			}
 			if len(preview) < 5 {
 				preview = append(preview, TaskPreviewItem{
 					TaskID: task.TaskID,
@@ -514,6 +627,7 @@ func myTeamsHandler(c *gin.Context) {
 				Total:   len(teamTasks),
 				Preview: preview,
 			},
+			Templates: templatesByTeam[team.TeamID],
 		})
 
 	}
@@ -589,11 +703,11 @@ func createTeamHandler(c *gin.Context) {
 
 	// Forward to TeamAPI: POST /admin/teams
 	if err := ds.PostJSON(c.Request.Context(), bearer, ds.TeamBase+"/admin/teams", req, nil); err != nil {
-		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
+		formFailed(c, "TeamAPI: ", err)
 		return
 	}
 
-	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
+	formDone(c, "/api/v1/auth/myteams")
 }
 
 func editTeamHandler(c *gin.Context) {
@@ -619,8 +733,17 @@ func editTeamHandler(c *gin.Context) {
 
 	req := gin.H{"teamid": teamID, "name": name, "description": desc}
 
-	// Forward to TeamAPI: PUT /admin/teams
-	if err := ds.PutJSON(c.Request.Context(), bearer, ds.TeamBase+"/admin/teams?teamid="+idStr, req, nil); err != nil {
+	// Forward to TeamAPI: PUT /admin/teams, against the version the form was opened with
+	if err := ds.PutJSONIfMatch(c.Request.Context(), bearer, ds.TeamBase+"/admin/teams?teamid="+idStr, formETag(c), req, nil); err != nil {
+		var stale struct {
+			Current Team `json:"current"`
+		}
+		if staleDownstream(err, &stale) {
+			c.HTML(http.StatusPreconditionFailed, "error.html", gin.H{"error": fmt.Sprintf(
+				"The team was changed by someone else meanwhile, it is now named %q with description %q. Reopen it to apply your edit again.",
+				stale.Current.Name, stale.Current.Description)})
+			return
+		}
 		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
 		return
 	}
@@ -652,11 +775,11 @@ func addMemberHandler(c *gin.Context) {
 
 	url := fmt.Sprintf("%s/leader/teams/%d/members", ds.TeamBase, teamID)
 	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
-		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
+		formFailed(c, "TeamAPI: ", err)
 		return
 	}
 
-	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
+	formDone(c, "/api/v1/auth/myteams")
 }
 
 func removeMemberHandler(c *gin.Context) {
@@ -691,23 +814,43 @@ func removeMemberHandler(c *gin.Context) {
 	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
 }
 
-func deleteTeamHandler(c *gin.Context) {
+func editWorkflowHandler(c *gin.Context) {
 	bearer := c.GetString("kc.access_token")
 	if bearer == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
 		return
 	}
 
-	teamIDStr := c.Param("teamid")
-	teamID, err := strconv.ParseInt(teamIDStr, 10, 64)
+	idStr := strings.TrimSpace(c.PostForm("teamid"))
+	terminal := strings.ToUpper(strings.TrimSpace(c.PostForm("terminal")))
+
+	teamID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil || teamID <= 0 {
 		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid teamid"})
 		return
 	}
 
-	// example: DELETE /admin/teams?teamid=123
-	url := fmt.Sprintf("%s/admin/teams?teamid=%d", ds.TeamBase, teamID)
-	if err := ds.Delete(c.Request.Context(), bearer, url); err != nil {
+	// one status per line (commas work too), in board order
+	names := strings.FieldsFunc(c.PostForm("statuses"), func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ','
+	})
+	statuses := make([]WorkflowStatus, 0, len(names))
+	for _, n := range names {
+		n = strings.ToUpper(strings.TrimSpace(n))
+		if n == "" {
+			continue
+		}
+		statuses = append(statuses, WorkflowStatus{Name: n, Terminal: n == terminal})
+	}
+	if len(statuses) == 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "statuses required"})
+		return
+	}
+
+	req := gin.H{"statuses": statuses}
+
+	url := fmt.Sprintf("%s/leader/teams/%d/workflow", ds.TeamBase, teamID)
+	if err := ds.PutJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
 		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
 		return
 	}
@@ -715,209 +858,560 @@ func deleteTeamHandler(c *gin.Context) {
 	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
 }
 
-func derefStr(p *string) string {
-	if p == nil {
-		return ""
+// labelForm reads the team id, name and colour of a label form.
+// On failure the error page is already written and ok is false.
+func labelForm(c *gin.Context) (teamID int64, req gin.H, ok bool) {
+	teamID, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("teamid")), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid teamid"})
+		return 0, nil, false
 	}
-	return *p
-}
-func derefBool(p *bool) bool {
-	if p == nil {
-		return false
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	color := strings.TrimSpace(c.PostForm("color"))
+	if name == "" || color == "" {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "label name and colour required"})
+		return 0, nil, false
 	}
-	return *p
+	return teamID, gin.H{"name": name, "color": color}, true
 }
 
-func taskDetailJSONHandler(c *gin.Context) {
+func createLabelHandler(c *gin.Context) {
 	bearer := c.GetString("kc.access_token")
 	if bearer == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
 		return
 	}
 
-	idStr := c.Param("id")
-	taskID, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil || taskID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+	teamID, req, ok := labelForm(c)
+	if !ok {
 		return
 	}
 
-	// fetch task + comments (parallel)
-	type resT struct {
-		task Task
-		err  error
-	}
-	type resC struct {
-		items []Comment
-		err   error
+	url := fmt.Sprintf("%s/leader/teams/%d/labels", ds.TeamBase, teamID)
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
+		return
 	}
 
-	chT := make(chan resT, 1)
-	chC := make(chan resC, 1)
+	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
+}
 
-	go func() {
-		t, e := ds.TaskByID(c.Request.Context(), bearer, taskID)
-		chT <- resT{task: t, err: e}
-	}()
-	go func() {
-		cr, e := ds.CommentsByTaskID(c.Request.Context(), bearer, taskID)
-		chC <- resC{items: cr.Items, err: e}
-	}()
+func editLabelHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
 
-	rt := <-chT
-	if rt.err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + rt.err.Error()})
+	labelID, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("labelid")), 10, 64)
+	if err != nil || labelID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid labelid"})
 		return
 	}
-	rc := <-chC
-	if rc.err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + rc.err.Error()})
+	teamID, req, ok := labelForm(c)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"task":     rt.task,
-		"comments": rc.items,
-	})
+	url := fmt.Sprintf("%s/leader/teams/%d/labels/%d", ds.TeamBase, teamID, labelID)
+	if err := ds.PutJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
 }
 
-func taskStatusHandler(c *gin.Context) {
+func deleteLabelHandler(c *gin.Context) {
 	bearer := c.GetString("kc.access_token")
 	if bearer == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
 		return
 	}
 
-	idStr := c.Param("id")
-	taskID, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil || taskID <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+	teamID, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("teamid")), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid teamid"})
+		return
+	}
+	labelID, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("labelid")), 10, 64)
+	if err != nil || labelID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid labelid"})
 		return
 	}
 
-	status := strings.TrimSpace(c.PostForm("status"))
-	if status == "" {
-		// allow JSON too
-		var body struct {
-			Status string `json:"status"`
-		}
-		if err := c.ShouldBindJSON(&body); err == nil {
-			status = strings.TrimSpace(body.Status)
-		}
+	url := fmt.Sprintf("%s/leader/teams/%d/labels/%d", ds.TeamBase, teamID, labelID)
+	if err := ds.Delete(c.Request.Context(), bearer, url); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
+		return
 	}
 
-	switch status {
-	case "TODO", "IN_PROGRESS", "DONE":
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
+}
+
+func deleteTeamHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
 		return
 	}
 
-	req := gin.H{"taskid": taskID, "status": status}
-	log.Printf("sending req to update stauts: %+v", req)
-	url := fmt.Sprintf("%s/auth/change-status?taskid=%v&status=%v", ds.TaskBase, taskID, status)
+	teamIDStr := c.Param("teamid")
+	teamID, err := strconv.ParseInt(teamIDStr, 10, 64)
+	if err != nil || teamID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid teamid"})
+		return
+	}
 
-	if err := ds.PatchJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + err.Error()})
+	// example: DELETE /admin/teams?teamid=123
+	url := fmt.Sprintf("%s/admin/teams?teamid=%d", ds.TeamBase, teamID)
+	if err := ds.Delete(c.Request.Context(), bearer, url); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
 }
 
-type CreateTaskForm struct {
-	TeamID      string
-	Title       string
-	Description string
-	Assignee    string
-	Priority    string
-	Deadline    string // yyyy-mm-dd from <input type="date">
+func derefStr(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+func derefBool(p *bool) bool {
+	if p == nil {
+		return false
+	}
+	return *p
 }
 
-func createTaskHandler(c *gin.Context) {
+func teamBoardHandler(c *gin.Context) {
+	username := c.GetString("kc.username")
+	email := c.GetString("kc.email")
+	firstname := c.GetString("kc.firstname")
+	lastname := c.GetString("kc.lastname")
+	rolesAny, _ := c.Get("kc.roles")
+	roles, _ := rolesAny.([]string)
+
+	isAdmin := false
+	for _, r := range roles {
+		if r == "admin" {
+			isAdmin = true
+		}
+	}
+
 	bearer := c.GetString("kc.access_token")
 	if bearer == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
 		return
 	}
 
-	// Read form fields
-	f := CreateTaskForm{
-		TeamID:      strings.TrimSpace(c.PostForm("teamid")),
-		Title:       strings.TrimSpace(c.PostForm("title")),
-		Description: strings.TrimSpace(c.PostForm("description")),
-		Assignee:    strings.TrimSpace(c.PostForm("assignee")),
-		Priority:    strings.TrimSpace(c.PostForm("priority")),
-		Deadline:    strings.TrimSpace(c.PostForm("deadline")),
-	}
-
-	// Validate teamid
-	teamID, err := strconv.ParseInt(f.TeamID, 10, 64)
+	teamID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil || teamID <= 0 {
-		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid teamid"})
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid team id"})
 		return
 	}
 
-	if f.Title == "" {
-		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "title required"})
+	// 1) the team (with its workflow)
+	team, ok := pageTeam(c, bearer, teamID, isAdmin)
+	if !ok {
 		return
 	}
-	if f.Assignee == "" {
-		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "assignee required"})
+
+	// 2) its tasks
+	tasksResponse, err := ds.TeamTasks(c.Request.Context(), bearer, teamID)
+	if err != nil {
+		log.Printf("failed to retrieve tasks: %v", err)
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
 		return
 	}
 
-	// Normalize priority
-	pr := strings.ToUpper(f.Priority)
-	if pr == "" {
-		pr = "MEDIUM"
+	// 3) one column per status, ?assignee= (a mention's link) keeps that user's tasks
+	tasks := tasksResponse.Items
+	assignee := strings.TrimSpace(c.Query("assignee"))
+	if assignee != "" {
+		tasks = slices.DeleteFunc(tasks, func(t Task) bool { return !t.IsAssignee(assignee) })
 	}
-	switch pr {
-	case "LOW", "MEDIUM", "HIGH":
-	default:
-		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid priority"})
+	columns := boardColumns(team.Workflow, tasks)
+
+	var vm BoardVM
+	vm.Title = team.Name + " · Board"
+	vm.Active = "teams"
+	vm.Team = team
+	vm.Columns = columns
+	vm.Assignee = assignee
+	vm.Truncated = tasksResponse.NextCursor != ""
+	vm.CanEdit = isAdmin || team.Leader == username
+
+	vm.User.Username = username
+	vm.User.Roles = roles
+	vm.User.IsAdmin = isAdmin
+	vm.User.Email = email
+	vm.User.Firstname = firstname
+	vm.User.Lastname = lastname
+
+	c.HTML(http.StatusOK, "layout.html", gin.H{
+		"Title":  vm.Title,
+		"Active": vm.Active,
+		"User":   vm.User,
+		"Page":   "pages/board.html",
+		"VM":     vm,
+	})
+}
+
+// pageTeam finds the team of a team page: one of mine, or any team for admins.
+// On failure the error page is already rendered.
+func pageTeam(c *gin.Context, bearer string, teamID int64, isAdmin bool) (Team, bool) {
+	teamListResponse, err := ds.MyTeams(c.Request.Context(), bearer)
+	if err != nil {
+		log.Printf("failed to retrieve teams: %v", err)
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TeamAPI: " + err.Error()})
+		return Team{}, false
+	}
+	for _, t := range teamListResponse.Items {
+		if t.TeamID == teamID {
+			return t, true
+		}
+	}
+	if isAdmin {
+		if team, err := ds.TeamByID(c.Request.Context(), bearer, teamID); err == nil {
+			return team, true
+		}
+	}
+	c.HTML(http.StatusNotFound, "error.html", gin.H{"error": "team not found"})
+	return Team{}, false
+}
+
+// boardColumns puts the tasks in one column per status, in workflow order;
+// statuses dropped from the workflow but still on tasks get a column at the end.
+func boardColumns(wf Workflow, tasks []Task) []BoardColumn {
+	columns := make([]BoardColumn, 0, len(wf))
+	idx := make(map[string]int, len(wf))
+	for _, s := range wf {
+		idx[s.Name] = len(columns)
+		columns = append(columns, BoardColumn{Status: s.Name, Terminal: s.Terminal})
+	}
+	for _, t := range tasks {
+		i, ok := idx[t.Status]
+		if !ok {
+			i = len(columns)
+			idx[t.Status] = i
+			columns = append(columns, BoardColumn{Status: t.Status})
+		}
+		columns[i].Tasks = append(columns[i].Tasks, t)
+	}
+	return columns
+}
+
+// sprintsHandler shows the team's milestones and one of them (?milestoneid,
+// else the active one, else the latest) with its tasks and burndown.
+func sprintsHandler(c *gin.Context) {
+	username := c.GetString("kc.username")
+	rolesAny, _ := c.Get("kc.roles")
+	roles, _ := rolesAny.([]string)
+
+	isAdmin := false
+	for _, r := range roles {
+		if r == "admin" {
+			isAdmin = true
+		}
+	}
+
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
 		return
 	}
 
-	// Deadline: optional
-	// You can pass it as string to TaskAPI and let it parse, or parse here.
-	// We'll parse here into RFC3339 to be consistent.
-	var deadlineRFC3339 *string
-	if f.Deadline != "" {
-		// HTML date is yyyy-mm-dd
-		d, err := time.Parse("2006-01-02", f.Deadline)
+	teamID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid team id"})
+		return
+	}
+	team, ok := pageTeam(c, bearer, teamID, isAdmin)
+	if !ok {
+		return
+	}
+
+	milestones, err := ds.Milestones(c.Request.Context(), bearer, teamID)
+	if err != nil {
+		log.Printf("failed to retrieve milestones: %v", err)
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	var selectedID int64
+	if v := c.Query("milestoneid"); v != "" {
+		if selectedID, err = strconv.ParseInt(v, 10, 64); err != nil || selectedID <= 0 {
+			c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid milestone id"})
+			return
+		}
+	} else if len(milestones) > 0 {
+		selectedID = milestones[0].MilestoneID
+		for _, m := range milestones {
+			if m.State == "active" {
+				selectedID = m.MilestoneID
+				break
+			}
+		}
+	}
+
+	var vm SprintsVM
+	if selectedID != 0 {
+		detail, err := ds.MilestoneByID(c.Request.Context(), bearer, selectedID)
 		if err != nil {
-			c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid deadline"})
+			log.Printf("failed to retrieve milestone: %v", err)
+			c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
 			return
 		}
-		// choose midnight UTC; or local. UTC is simplest for APIs.
-		v := d.UTC().Format(time.RFC3339)
-		deadlineRFC3339 = &v
+		if detail.Milestone.TeamID != teamID {
+			c.HTML(http.StatusNotFound, "error.html", gin.H{"error": "milestone not found"})
+			return
+		}
+		vm.Selected = &detail
+		vm.Columns = boardColumns(team.Workflow, detail.Tasks)
+		vm.Chart = burndownChart(detail.Burndown, 600, 200)
 	}
 
-	// Build JSON request for TaskAPI
-	req := gin.H{
-		"teamid":      teamID,
-		"title":       f.Title,
-		"description": f.Description,
-		"assignee":    f.Assignee,
-		"priority":    pr,
+	vm.Title = team.Name + " · Sprints"
+	vm.Active = "teams"
+	vm.Team = team
+	vm.Milestones = milestones
+	vm.CanEdit = isAdmin || team.Leader == username
+
+	vm.User.Username = username
+	vm.User.Roles = roles
+	vm.User.IsAdmin = isAdmin
+	vm.User.Email = c.GetString("kc.email")
+	vm.User.Firstname = c.GetString("kc.firstname")
+	vm.User.Lastname = c.GetString("kc.lastname")
+
+	c.HTML(http.StatusOK, "layout.html", gin.H{
+		"Title":  vm.Title,
+		"Active": vm.Active,
+		"User":   vm.User,
+		"Page":   "pages/sprints.html",
+		"VM":     vm,
+	})
+}
+
+// burndownChart lays the burndown out in a width x height box with a margin,
+// remaining and ideal sharing the y axis from 0 to the highest count.
+func burndownChart(points []BurndownPoint, width, height int) BurndownChart {
+	chart := BurndownChart{Width: width, Height: height, Max: 1}
+	if len(points) == 0 {
+		return chart
 	}
-	if deadlineRFC3339 != nil {
-		req["deadline"] = *deadlineRFC3339
+	chart.First = points[0].Day.Format("2006-01-02")
+	chart.Last = points[len(points)-1].Day.Format("2006-01-02")
+
+	for _, p := range points {
+		chart.Max = max(chart.Max, int(math.Ceil(p.Ideal)))
+		if p.Remaining != nil {
+			chart.Max = max(chart.Max, *p.Remaining)
+		}
 	}
 
-	// Forward to TaskAPI
-	url := fmt.Sprintf("%s/auth/tasks", ds.TaskBase)
-	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
-		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+	const margin = 20
+	x := func(i int) float64 {
+		if len(points) == 1 {
+			return float64(width) / 2
+		}
+		return margin + float64(i)*float64(width-2*margin)/float64(len(points)-1)
+	}
+	y := func(v float64) float64 {
+		return margin + float64(height-2*margin)*(1-v/float64(chart.Max))
+	}
+
+	ideal := make([]string, 0, len(points))
+	actual := make([]string, 0, len(points))
+	for i, p := range points {
+		ideal = append(ideal, fmt.Sprintf("%.1f,%.1f", x(i), y(p.Ideal)))
+		if p.Remaining != nil {
+			actual = append(actual, fmt.Sprintf("%.1f,%.1f", x(i), y(float64(*p.Remaining))))
+		}
+	}
+	chart.Ideal = strings.Join(ideal, " ")
+	chart.Actual = strings.Join(actual, " ")
+	return chart
+}
+
+func searchHandler(c *gin.Context) {
+	username := c.GetString("kc.username")
+	rolesAny, _ := c.Get("kc.roles")
+	roles, _ := rolesAny.([]string)
+
+	isAdmin := false
+	for _, r := range roles {
+		if r == "admin" {
+			isAdmin = true
+		}
+	}
+
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	var vm SearchVM
+	vm.Title = "Search"
+	vm.Active = "search"
+	vm.Query = strings.TrimSpace(c.Query("q"))
+
+	if vm.Query != "" {
+		res, err := ds.Search(c.Request.Context(), bearer, vm.Query)
+		if err != nil {
+			log.Printf("failed to search: %v", err)
+			c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+			return
+		}
+		vm.Hits = res.Items
+	}
+
+	vm.User.Username = username
+	vm.User.Roles = roles
+	vm.User.IsAdmin = isAdmin
+	vm.User.Email = c.GetString("kc.email")
+	vm.User.Firstname = c.GetString("kc.firstname")
+	vm.User.Lastname = c.GetString("kc.lastname")
+
+	c.HTML(http.StatusOK, "layout.html", gin.H{
+		"Title":  vm.Title,
+		"Active": vm.Active,
+		"User":   vm.User,
+		"Page":   "pages/search.html",
+		"VM":     vm,
+	})
+}
+
+func taskDetailJSONHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	idStr := c.Param("id")
+	taskID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+
+	// fetch task + comments + history (parallel)
+	type resT struct {
+		task Task
+		err  error
+	}
+	type resC struct {
+		items       []Comment
+		canModerate bool
+		err         error
+	}
+	type resH struct {
+		items []TaskEvent
+		err   error
+	}
+	type resE struct {
+		items []TimeEntry
+		timer *TimeEntry
+		err   error
+	}
+
+	chT := make(chan resT, 1)
+	chC := make(chan resC, 1)
+	chH := make(chan resH, 1)
+	chE := make(chan resE, 1)
+
+	go func() {
+		t, e := ds.TaskByID(c.Request.Context(), bearer, taskID)
+		chT <- resT{task: t, err: e}
+	}()
+	go func() {
+		cr, e := ds.CommentsByTaskID(c.Request.Context(), bearer, taskID)
+		chC <- resC{items: cr.Items, canModerate: cr.CanModerate, err: e}
+	}()
+	go func() {
+		hr, e := ds.TaskHistory(c.Request.Context(), bearer, taskID)
+		chH <- resH{items: hr.Items, err: e}
+	}()
+	go func() {
+		entries, e := ds.TimeEntries(c.Request.Context(), bearer, taskID)
+		if e != nil {
+			chE <- resE{err: e}
+			return
+		}
+		timer, e := ds.RunningTimer(c.Request.Context(), bearer)
+		chE <- resE{items: entries, timer: timer, err: e}
+	}()
+
+	rt := <-chT
+	if rt.err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + rt.err.Error()})
+		return
+	}
+	rc := <-chC
+	if rc.err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + rc.err.Error()})
+		return
+	}
+	rh := <-chH
+	if rh.err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + rh.err.Error()})
+		return
+	}
+	re := <-chE
+	if re.err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + re.err.Error()})
+		return
+	}
+
+	// leaders pick the task's labels from those of the team
+	teamLabels := []Label{}
+	if rc.canModerate {
+		teamLabels, err = ds.TeamLabels(c.Request.Context(), bearer, rt.task.TeamID)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "TeamAPI: " + err.Error()})
+			return
+		}
+	}
+
+	// the sprint names, and the ones leaders may move the task to
+	milestones, err := ds.Milestones(c.Request.Context(), bearer, rt.task.TeamID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	// the members are linked when mentioned and suggested in the comment box
+	team, err := ds.Team(c.Request.Context(), bearer, rt.task.TeamID)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TeamAPI: " + err.Error()})
 		return
 	}
+	members := make([]string, 0, len(team.Members))
+	for _, m := range team.Members {
+		members = append(members, m.Username)
+	}
 
-	c.Redirect(http.StatusSeeOther, "/api/v1/auth/mytasks")
+	c.JSON(http.StatusOK, gin.H{
+		"task":         rt.task,
+		"comments":     rc.items,
+		"history":      rh.items,
+		"time_entries": re.items,
+		"timer":        re.timer,
+		"team_labels":  teamLabels,
+		"milestones":   milestones,
+		"members":      members,
+		"me":           c.GetString("kc.username"),
+		"can_moderate": rc.canModerate,
+	})
 }
 
-func addCommentHandler(c *gin.Context) {
+func taskStatusHandler(c *gin.Context) {
 	bearer := c.GetString("kc.access_token")
 	if bearer == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
@@ -931,29 +1425,1346 @@ func addCommentHandler(c *gin.Context) {
 		return
 	}
 
-	body := strings.TrimSpace(c.PostForm("body"))
-	if body == "" {
+	status := strings.TrimSpace(c.PostForm("status"))
+	if status == "" {
 		// allow JSON too
-		var j struct {
-			Body string `json:"body"`
+		var body struct {
+			Status string `json:"status"`
 		}
-		if err := c.ShouldBindJSON(&j); err == nil {
-			body = strings.TrimSpace(j.Body)
+		if err := c.ShouldBindJSON(&body); err == nil {
+			status = strings.TrimSpace(body.Status)
 		}
 	}
-	if body == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "body required"})
+
+	// which statuses exist depends on the team's workflow, the TaskAPI checks it
+	if status == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status required"})
 		return
 	}
 
-	req := gin.H{"taskid": taskID, "body": body}
-	url := fmt.Sprintf("%s/auth/comments", ds.TaskBase)
+	req := gin.H{"taskid": taskID, "status": status}
+	log.Printf("sending req to update stauts: %+v", req)
+	url := fmt.Sprintf("%s/auth/change-status?taskid=%v&status=%v", ds.TaskBase, taskID, url.QueryEscape(status))
+	// the user confirmed closing a task that still has open subtasks
+	if ok, _ := strconv.ParseBool(c.PostForm("confirm")); ok {
+		url += "&confirm=true"
+	}
+	// a leader starts a task despite unfinished blockers
+	if ok, _ := strconv.ParseBool(c.PostForm("force")); ok {
+		url += "&force=true"
+	}
 
-	var resp any
-	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, &resp); err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + err.Error()})
+	// the task as the user saw it; a stale version answers 412 with the current task
+	var out struct {
+		Version int `json:"version"`
+	}
+	if err := ds.PatchJSONIfMatch(c.Request.Context(), bearer, url, formETag(c), req, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"status": "ok"})
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "version": out.Version})
+}
+
+// formETag turns the version field of a form into the ETag the form was
+// rendered from; empty when missing, which the services refuse with 428.
+func formETag(c *gin.Context) string {
+	v, err := strconv.Atoi(strings.TrimSpace(c.PostForm("version")))
+	if err != nil || v <= 0 {
+		return ""
+	}
+	return utils.ETag(v)
+}
+
+// staleDownstream reports whether err is a 412 of a downstream service and
+// decodes its body, which carries the current representation, into out.
+func staleDownstream(err error, out any) bool {
+	var de *DownstreamError
+	if !errors.As(err, &de) || de.Status != http.StatusPreconditionFailed {
+		return false
+	}
+	return json.Unmarshal([]byte(de.Body), out) == nil
+}
+
+// bulkTasksHandler forwards a bulk operation on the selected tasks (form: taskid
+// repeated, op, and assignees, priority or status) and answers the per-task results.
+func bulkTasksHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	ids := make([]int64, 0, len(c.PostFormArray("taskid")))
+	for _, s := range c.PostFormArray("taskid") {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "select at least one task"})
+		return
+	}
+
+	op := c.PostForm("op")
+	req := gin.H{"taskids": ids, "op": op}
+	switch op {
+	case "assign":
+		req["assignees"] = splitUsernames(c.PostForm("assignees"))
+	case "priority":
+		req["priority"] = c.PostForm("priority")
+	case "status":
+		req["status"] = strings.TrimSpace(c.PostForm("status"))
+	case "delete":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown bulk operation"})
+		return
+	}
+
+	url := ds.TaskBase + "/auth/tasks/bulk"
+	q := make([]string, 0, 2)
+	// closing tasks with open subtasks was confirmed / blockers are overridden
+	if ok, _ := strconv.ParseBool(c.PostForm("confirm")); ok {
+		q = append(q, "confirm=true")
+	}
+	if ok, _ := strconv.ParseBool(c.PostForm("force")); ok {
+		q = append(q, "force=true")
+	}
+	if len(q) > 0 {
+		url += "?" + strings.Join(q, "&")
+	}
+
+	var out BulkResponse
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// countStatuses counts tasks per status. The statuses of the given workflows come
+// first, in workflow order; statuses only found on tasks are appended sorted.
+func countStatuses(tasks []Task, workflows ...Workflow) []StatusCount {
+	idx := make(map[string]int, 8)
+	out := make([]StatusCount, 0, 8)
+	add := func(status string) int {
+		if i, ok := idx[status]; ok {
+			return i
+		}
+		idx[status] = len(out)
+		out = append(out, StatusCount{Status: status})
+		return len(out) - 1
+	}
+
+	for _, wf := range workflows {
+		for _, s := range wf {
+			add(s.Name)
+		}
+	}
+	known := len(out)
+	for _, t := range tasks {
+		out[add(t.Status)].Count++
+	}
+
+	extra := out[known:]
+	sort.Slice(extra, func(i, j int) bool { return extra[i].Status < extra[j].Status })
+	return out
+}
+
+// respondDownstreamError passes client errors (4xx) of a backend through with their
+// status and body so the page can show them; anything else is a 502.
+func respondDownstreamError(c *gin.Context, prefix string, err error) {
+	var de *DownstreamError
+	if errors.As(err, &de) && de.Status >= 400 && de.Status < 500 {
+		if json.Valid([]byte(de.Body)) && strings.HasPrefix(strings.TrimSpace(de.Body), "{") {
+			c.Data(de.Status, "application/json; charset=utf-8", []byte(de.Body))
+			return
+		}
+		c.JSON(de.Status, gin.H{"error": de.Message()})
+		return
+	}
+	c.JSON(http.StatusBadGateway, gin.H{"error": prefix + err.Error()})
+}
+
+// formFailed answers a form post the backend refused. Forms posted with htmx
+// show the error next to their fields, they get the backend's 4xx JSON (with
+// "field" and "invalid" on a 422); plain posts get the error page.
+func formFailed(c *gin.Context, prefix string, err error) {
+	if c.GetHeader("HX-Request") == "true" {
+		respondDownstreamError(c, prefix, err)
+		return
+	}
+	c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": prefix + err.Error()})
+}
+
+// formDone sends a successful form post on to location, through HX-Redirect
+// for htmx.
+func formDone(c *gin.Context, location string) {
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", location)
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, location)
+}
+
+// splitUsernames reads a comma or space separated list of usernames, a leading
+// @ allowed. It is never nil so an empty list still clears in JSON.
+func splitUsernames(s string) []string {
+	out := []string{}
+	for _, u := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		if u = strings.TrimPrefix(u, "@"); u != "" && !slices.Contains(out, u) {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+type CreateTaskForm struct {
+	TeamID      string
+	Title       string
+	Description string
+	Assignees   []string
+	Priority    string
+	Deadline    string // yyyy-mm-dd from <input type="date">
+	TemplateID  string
+	Estimate    string // minutes or a duration like 1h30m
+}
+
+func createTaskHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	// Read form fields
+	f := CreateTaskForm{
+		TeamID:      strings.TrimSpace(c.PostForm("teamid")),
+		Title:       strings.TrimSpace(c.PostForm("title")),
+		Description: strings.TrimSpace(c.PostForm("description")),
+		Assignees:   splitUsernames(c.PostForm("assignees")),
+		Priority:    strings.TrimSpace(c.PostForm("priority")),
+		Deadline:    strings.TrimSpace(c.PostForm("deadline")),
+		TemplateID:  strings.TrimSpace(c.PostForm("templateid")),
+		Estimate:    strings.TrimSpace(c.PostForm("estimate")),
+	}
+
+	// Validate teamid
+	teamID, err := strconv.ParseInt(f.TeamID, 10, 64)
+	if err != nil || teamID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid teamid"})
+		return
+	}
+
+	// a template fills the title from its pattern
+	var templateID int64
+	if f.TemplateID != "" {
+		if templateID, err = strconv.ParseInt(f.TemplateID, 10, 64); err != nil || templateID <= 0 {
+			c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid template"})
+			return
+		}
+	}
+	if f.Title == "" && templateID == 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "title required"})
+		return
+	}
+	if len(f.Assignees) == 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "assignee required"})
+		return
+	}
+
+	// Normalize priority, left empty the template's applies
+	pr := strings.ToUpper(f.Priority)
+	if pr == "" && templateID == 0 {
+		pr = "MEDIUM"
+	}
+	switch pr {
+	case "", "LOW", "MEDIUM", "HIGH":
+	default:
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid priority"})
+		return
+	}
+
+	// Deadline: optional
+	// You can pass it as string to TaskAPI and let it parse, or parse here.
+	// We'll parse here into RFC3339 to be consistent.
+	var deadlineRFC3339 *string
+	if f.Deadline != "" {
+		// HTML date is yyyy-mm-dd
+		d, err := time.Parse("2006-01-02", f.Deadline)
+		if err != nil {
+			c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid deadline"})
+			return
+		}
+		// choose midnight UTC; or local. UTC is simplest for APIs.
+		v := d.UTC().Format(time.RFC3339)
+		deadlineRFC3339 = &v
+	}
+
+	estimate := 0
+	if f.Estimate != "" {
+		if estimate, err = parseMinutes(f.Estimate); err != nil {
+			c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid estimate"})
+			return
+		}
+	}
+
+	// Build JSON request for TaskAPI
+	req := gin.H{
+		"teamid":      teamID,
+		"title":       f.Title,
+		"description": f.Description,
+		"assignees":   f.Assignees,
+		"priority":    pr,
+	}
+	if deadlineRFC3339 != nil {
+		req["deadline"] = *deadlineRFC3339
+	}
+	if templateID != 0 {
+		req["templateid"] = templateID
+	}
+	if estimate > 0 {
+		req["estimate_minutes"] = estimate
+	}
+
+	// Forward to TaskAPI
+	url := fmt.Sprintf("%s/auth/tasks", ds.TaskBase)
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
+		formFailed(c, "TaskAPI: ", err)
+		return
+	}
+
+	formDone(c, "/api/v1/auth/mytasks")
+}
+
+func addCommentHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	idStr := c.Param("id")
+	taskID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+
+	body := strings.TrimSpace(c.PostForm("body"))
+	parentStr := strings.TrimSpace(c.PostForm("parent_commentid"))
+	if body == "" {
+		// allow JSON too
+		var j struct {
+			Body            string `json:"body"`
+			ParentCommentID int64  `json:"parent_commentid"`
+		}
+		if err := c.ShouldBindJSON(&j); err == nil {
+			body = strings.TrimSpace(j.Body)
+			if j.ParentCommentID > 0 {
+				parentStr = strconv.FormatInt(j.ParentCommentID, 10)
+			}
+		}
+	}
+	if body == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body required"})
+		return
+	}
+
+	req := gin.H{"taskid": taskID, "body": body}
+	if parentStr != "" {
+		parentID, err := strconv.ParseInt(parentStr, 10, 64)
+		if err != nil || parentID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid parent comment id"})
+			return
+		}
+		req["parent_commentid"] = parentID
+	}
+	url := fmt.Sprintf("%s/auth/comments", ds.TaskBase)
+
+	var resp any
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, &resp); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"status": "ok"})
+}
+
+func editCommentHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	commentID, err := strconv.ParseInt(c.Param("commentid"), 10, 64)
+	if err != nil || commentID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment id"})
+		return
+	}
+
+	body := strings.TrimSpace(c.PostForm("body"))
+	if body == "" {
+		// allow JSON too
+		var j struct {
+			Body string `json:"body"`
+		}
+		if err := c.ShouldBindJSON(&j); err == nil {
+			body = strings.TrimSpace(j.Body)
+		}
+	}
+	if body == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body required"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/comments?commentid=%d", ds.TaskBase, commentID)
+	if err := ds.PutJSON(c.Request.Context(), bearer, url, gin.H{"body": body}, nil); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func deleteCommentHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	commentID, err := strconv.ParseInt(c.Param("commentid"), 10, 64)
+	if err != nil || commentID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment id"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/comments?commentid=%d", ds.TaskBase, commentID)
+	if err := ds.Delete(c.Request.Context(), bearer, url); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func addSubtaskHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	parentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || parentID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+
+	title := strings.TrimSpace(c.PostForm("title"))
+	if title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "title required"})
+		return
+	}
+
+	// subtasks live in the parent's team and default to its assignees
+	parent, err := ds.TaskByID(c.Request.Context(), bearer, parentID)
+	if err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+	assignees := splitUsernames(c.PostForm("assignees"))
+	if len(assignees) == 0 {
+		assignees = parent.Assignees
+	}
+
+	req := gin.H{
+		"teamid":        parent.TeamID,
+		"title":         title,
+		"assignees":     assignees,
+		"priority":      parent.Priority,
+		"parent_taskid": parentID,
+	}
+
+	var resp struct {
+		TaskID int64 `json:"taskid"`
+	}
+	url := fmt.Sprintf("%s/auth/tasks", ds.TaskBase)
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, &resp); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"status": "ok", "taskid": resp.TaskID})
+}
+
+func addChecklistItemHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+
+	body := strings.TrimSpace(c.PostForm("body"))
+	if body == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body required"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/checklist", ds.TaskBase)
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, gin.H{"taskid": taskID, "body": body}, nil); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"status": "ok"})
+}
+
+func toggleChecklistItemHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	itemID, err := strconv.ParseInt(c.Param("itemid"), 10, 64)
+	if err != nil || itemID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+		return
+	}
+	done, err := strconv.ParseBool(c.PostForm("done"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "done must be true or false"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/checklist?itemid=%d", ds.TaskBase, itemID)
+	if err := ds.PutJSON(c.Request.Context(), bearer, url, gin.H{"done": done}, nil); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func deleteChecklistItemHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	itemID, err := strconv.ParseInt(c.Param("itemid"), 10, 64)
+	if err != nil || itemID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid item id"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/checklist?itemid=%d", ds.TaskBase, itemID)
+	if err := ds.Delete(c.Request.Context(), bearer, url); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func addBlockerHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+	blockerID, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(c.PostForm("blocker_taskid")), "#"), 10, 64)
+	if err != nil || blockerID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid blocker task id"})
+		return
+	}
+
+	req := gin.H{"blocker_taskid": blockerID, "blocked_taskid": taskID}
+	url := fmt.Sprintf("%s/auth/dependencies", ds.TaskBase)
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"status": "ok"})
+}
+
+func removeDependencyHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	blockerID, err := strconv.ParseInt(c.PostForm("blocker_taskid"), 10, 64)
+	if err != nil || blockerID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid blocker task id"})
+		return
+	}
+	blockedID, err := strconv.ParseInt(c.PostForm("blocked_taskid"), 10, 64)
+	if err != nil || blockedID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid blocked task id"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/dependencies?blocker_taskid=%d&blocked_taskid=%d", ds.TaskBase, blockerID, blockedID)
+	if err := ds.Delete(c.Request.Context(), bearer, url); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func setTaskLabelsHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+
+	// an empty list clears the labels
+	labelIDs := make([]int64, 0, 8)
+	for _, v := range c.PostFormArray("labelid") {
+		id, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid label id"})
+			return
+		}
+		labelIDs = append(labelIDs, id)
+	}
+
+	url := fmt.Sprintf("%s/auth/tasks?taskid=%d", ds.TaskBase, taskID)
+	var out struct {
+		Version int `json:"version"`
+	}
+	if err := ds.PutJSONIfMatch(c.Request.Context(), bearer, url, formETag(c), gin.H{"labelids": labelIDs}, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "version": out.Version})
+}
+
+// setRecurrenceHandler makes the task repeat: freq (daily, weekly, monthly),
+// interval and, for weekly, weekday (MO..SU, repeated).
+func setRecurrenceHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+	interval := 1
+	if v := strings.TrimSpace(c.PostForm("interval")); v != "" {
+		if interval, err = strconv.Atoi(v); err != nil || interval <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid interval"})
+			return
+		}
+	}
+
+	req := gin.H{
+		"freq":     strings.TrimSpace(c.PostForm("freq")),
+		"interval": interval,
+		"weekdays": c.PostFormArray("weekday"),
+	}
+	var out Recurrence
+	url := fmt.Sprintf("%s/auth/tasks/%d/recurrence", ds.TaskBase, taskID)
+	if err := ds.PutJSON(c.Request.Context(), bearer, url, req, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// recurrenceStateHandler pauses (state=paused), resumes (active) or stops the recurrence.
+func recurrenceStateHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+
+	var out Recurrence
+	req := gin.H{"state": strings.TrimSpace(c.PostForm("state"))}
+	url := fmt.Sprintf("%s/auth/tasks/%d/recurrence", ds.TaskBase, taskID)
+	if err := ds.PatchJSON(c.Request.Context(), bearer, url, req, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// templateForm reads the form of the team templates modal; labelid repeats and
+// checklist holds one item per line.
+func templateForm(c *gin.Context) (gin.H, bool) {
+	teamID, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("teamid")), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid teamid"})
+		return nil, false
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	pattern := strings.TrimSpace(c.PostForm("title_pattern"))
+	if name == "" || pattern == "" {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "template name and title required"})
+		return nil, false
+	}
+
+	labelIDs := make([]int64, 0, 4)
+	for _, v := range c.PostFormArray("labelid") {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid label"})
+			return nil, false
+		}
+		labelIDs = append(labelIDs, id)
+	}
+	checklist := make([]string, 0, 8)
+	for _, line := range strings.Split(c.PostForm("checklist"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			checklist = append(checklist, line)
+		}
+	}
+
+	req := gin.H{
+		"teamid":        teamID,
+		"name":          name,
+		"title_pattern": pattern,
+		"description":   strings.TrimSpace(c.PostForm("description")),
+		"priority":      strings.ToUpper(strings.TrimSpace(c.PostForm("priority"))),
+		"labelids":      labelIDs,
+		"checklist":     checklist,
+	}
+	if v := strings.TrimSpace(c.PostForm("deadline_days")); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid deadline days"})
+			return nil, false
+		}
+		req["deadline_days"] = days
+	}
+	return req, true
+}
+
+func createTemplateHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	req, ok := templateForm(c)
+	if !ok {
+		return
+	}
+
+	if err := ds.PostJSON(c.Request.Context(), bearer, ds.TaskBase+"/auth/templates", req, nil); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
+}
+
+func editTemplateHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	templateID, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("templateid")), 10, 64)
+	if err != nil || templateID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid templateid"})
+		return
+	}
+	req, ok := templateForm(c)
+	if !ok {
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/templates/%d", ds.TaskBase, templateID)
+	if err := ds.PutJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
+}
+
+func deleteTemplateHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	templateID, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("templateid")), 10, 64)
+	if err != nil || templateID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid templateid"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/templates/%d", ds.TaskBase, templateID)
+	if err := ds.Delete(c.Request.Context(), bearer, url); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/api/v1/auth/myteams")
+}
+
+// parseMinutes reads a duration typed by a user: plain minutes ("90") or a Go
+// duration ("1h30m").
+func parseMinutes(v string) (int, error) {
+	v = strings.TrimSpace(v)
+	if n, err := strconv.Atoi(v); err == nil {
+		if n < 0 {
+			return 0, errors.New("negative duration")
+		}
+		return n, nil
+	}
+	d, err := time.ParseDuration(strings.ReplaceAll(v, " ", ""))
+	if err != nil || d < 0 {
+		return 0, errors.New("invalid duration")
+	}
+	return int(d.Round(time.Minute) / time.Minute), nil
+}
+
+// formatMinutes renders logged or estimated time as "1h 30m", taking an int or
+// a *int (none when nil).
+func formatMinutes(v any) string {
+	var m int
+	switch x := v.(type) {
+	case int:
+		m = x
+	case *int:
+		if x == nil {
+			return "-"
+		}
+		m = *x
+	}
+	if m < 60 {
+		return fmt.Sprintf("%dm", m)
+	}
+	if m%60 == 0 {
+		return fmt.Sprintf("%dh", m/60)
+	}
+	return fmt.Sprintf("%dh %dm", m/60, m%60)
+}
+
+// startTimerHandler starts the caller's timer on the task. A 409 names the
+// task a timer already runs on, switch=true stops that one first.
+func startTimerHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+	switchTask, _ := strconv.ParseBool(c.PostForm("switch"))
+
+	var out TimeEntry
+	url := fmt.Sprintf("%s/auth/tasks/%d/timer", ds.TaskBase, taskID)
+	req := gin.H{"note": strings.TrimSpace(c.PostForm("note")), "switch": switchTask}
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, out)
+}
+
+func stopTimerHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	req := gin.H{}
+	if note := strings.TrimSpace(c.PostForm("note")); note != "" {
+		req["note"] = note
+	}
+
+	var out TimeEntry
+	if err := ds.PostJSON(c.Request.Context(), bearer, ds.TaskBase+"/auth/timer/stop", req, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// logTimeHandler records spent (see parseMinutes) on the task without a timer.
+func logTimeHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+	minutes, err := parseMinutes(c.PostForm("spent"))
+	if err != nil || minutes == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time spent required, e.g. 45 or 1h30m"})
+		return
+	}
+
+	var out TimeEntry
+	url := fmt.Sprintf("%s/auth/tasks/%d/time", ds.TaskBase, taskID)
+	req := gin.H{"minutes": minutes, "note": strings.TrimSpace(c.PostForm("note"))}
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, out)
+}
+
+// setEstimateHandler sets the task's estimate (see parseMinutes), empty or 0 clears it.
+func setEstimateHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+	minutes := 0
+	if v := strings.TrimSpace(c.PostForm("estimate")); v != "" {
+		if minutes, err = parseMinutes(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid estimate, e.g. 90 or 1h30m"})
+			return
+		}
+	}
+
+	url := fmt.Sprintf("%s/auth/tasks?taskid=%d", ds.TaskBase, taskID)
+	var out struct {
+		Version int `json:"version"`
+	}
+	if err := ds.PutJSONIfMatch(c.Request.Context(), bearer, url, formETag(c), gin.H{"estimate_minutes": minutes}, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "version": out.Version})
+}
+
+// sprintForm reads a milestone from the sprint forms: name and its first and
+// last day. On failure the error page is already rendered.
+func sprintForm(c *gin.Context) (gin.H, bool) {
+	name := strings.TrimSpace(c.PostForm("name"))
+	startsOn, err1 := time.Parse("2006-01-02", c.PostForm("starts_on"))
+	endsOn, err2 := time.Parse("2006-01-02", c.PostForm("ends_on"))
+	if name == "" || err1 != nil || err2 != nil {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "name, start and end dates required"})
+		return nil, false
+	}
+	return gin.H{"name": name, "starts_on": startsOn, "ends_on": endsOn}, true
+}
+
+// sprintsURL is the sprints page of the form's team, on the given milestone.
+func sprintsURL(c *gin.Context, milestoneID int64) string {
+	u := fmt.Sprintf("/api/v1/auth/teams/%s/sprints", url.PathEscape(c.PostForm("teamid")))
+	if milestoneID > 0 {
+		u += fmt.Sprintf("?milestoneid=%d", milestoneID)
+	}
+	return u
+}
+
+func createSprintHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	teamID, err := strconv.ParseInt(strings.TrimSpace(c.PostForm("teamid")), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid teamid"})
+		return
+	}
+	req, ok := sprintForm(c)
+	if !ok {
+		return
+	}
+	req["teamid"] = teamID
+
+	var out struct {
+		MilestoneID int64 `json:"milestoneid"`
+	}
+	if err := ds.PostJSON(c.Request.Context(), bearer, ds.TaskBase+"/auth/milestones", req, &out); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, sprintsURL(c, out.MilestoneID))
+}
+
+func editSprintHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	milestoneID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || milestoneID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid milestone id"})
+		return
+	}
+	req, ok := sprintForm(c)
+	if !ok {
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/milestones/%d", ds.TaskBase, milestoneID)
+	if err := ds.PutJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, sprintsURL(c, milestoneID))
+}
+
+// sprintStateHandler starts or closes a milestone; closing moves its unfinished
+// tasks to next_milestoneid, or by default to the next planned one.
+func sprintStateHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	milestoneID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || milestoneID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid milestone id"})
+		return
+	}
+
+	var req gin.H
+	action := c.PostForm("action")
+	switch action {
+	case "start":
+	case "close":
+		if v := strings.TrimSpace(c.PostForm("next_milestoneid")); v != "" {
+			next, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || next <= 0 {
+				c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid next milestone"})
+				return
+			}
+			req = gin.H{"next_milestoneid": next}
+		}
+	default:
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "action must be start or close"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/milestones/%d/%s", ds.TaskBase, milestoneID, action)
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, req, nil); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, sprintsURL(c, milestoneID))
+}
+
+func deleteSprintHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	milestoneID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || milestoneID <= 0 {
+		c.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "invalid milestone id"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/milestones/%d", ds.TaskBase, milestoneID)
+	if err := ds.Delete(c.Request.Context(), bearer, url); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, sprintsURL(c, 0))
+}
+
+// setTaskMilestoneHandler moves the task to a sprint of its team, milestoneid
+// empty or 0 takes it out of its sprint.
+func setTaskMilestoneHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+	var milestoneID int64
+	if v := strings.TrimSpace(c.PostForm("milestoneid")); v != "" {
+		if milestoneID, err = strconv.ParseInt(v, 10, 64); err != nil || milestoneID < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid milestoneid"})
+			return
+		}
+	}
+
+	url := fmt.Sprintf("%s/auth/tasks?taskid=%d", ds.TaskBase, taskID)
+	var out struct {
+		Version int `json:"version"`
+	}
+	if err := ds.PutJSONIfMatch(c.Request.Context(), bearer, url, formETag(c), gin.H{"milestoneid": milestoneID}, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "version": out.Version})
+}
+
+// watchTaskHandler adds the user to the task's watchers, or removes them with
+// watch=false.
+func watchTaskHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+	watch, err := strconv.ParseBool(c.DefaultPostForm("watch", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid watch"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/tasks/%d/watch", ds.TaskBase, taskID)
+	if watch {
+		err = ds.PostJSON(c.Request.Context(), bearer, url, nil, nil)
+	} else {
+		err = ds.Delete(c.Request.Context(), bearer, url)
+	}
+	if err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "watching": watch})
+}
+
+// setAssigneesHandler replaces the task's assignees with the given usernames,
+// which must be members of its team.
+func setAssigneesHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/tasks?taskid=%d", ds.TaskBase, taskID)
+	req := gin.H{"assignees": splitUsernames(c.PostForm("assignees"))}
+	var out struct {
+		Version int `json:"version"`
+	}
+	if err := ds.PutJSONIfMatch(c.Request.Context(), bearer, url, formETag(c), req, &out); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "version": out.Version})
+}
+
+// notificationsHandler lists my notifications, only the unread ones with ?unread=true.
+func notificationsHandler(c *gin.Context) {
+	rolesAny, _ := c.Get("kc.roles")
+	roles, _ := rolesAny.([]string)
+
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	var vm NotificationsVM
+	vm.Title = "Notifications"
+	vm.Active = "notifications"
+	vm.UnreadOnly = c.Query("unread") == "true"
+
+	res, err := ds.Notifications(c.Request.Context(), bearer, vm.UnreadOnly)
+	if err != nil {
+		log.Printf("failed to retrieve notifications: %v", err)
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+	vm.Items = res.Items
+	vm.Unread = res.Unread
+
+	vm.Email, err = ds.EmailPreferences(c.Request.Context(), bearer)
+	if err != nil {
+		log.Printf("failed to retrieve email preferences: %v", err)
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	vm.User.Username = c.GetString("kc.username")
+	vm.User.Roles = roles
+	vm.User.IsAdmin = slices.Contains(roles, "admin")
+	vm.User.Email = c.GetString("kc.email")
+	vm.User.Firstname = c.GetString("kc.firstname")
+	vm.User.Lastname = c.GetString("kc.lastname")
+
+	c.HTML(http.StatusOK, "layout.html", gin.H{
+		"Title":  vm.Title,
+		"Active": vm.Active,
+		"User":   vm.User,
+		"Page":   "pages/notifications.html",
+		"VM":     vm,
+	})
+}
+
+// notificationBadgeHandler renders the unread count of the nav's bell, which
+// polls it with htmx.
+func notificationBadgeHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	n, err := ds.UnreadNotifications(c.Request.Context(), bearer)
+	if err != nil {
+		// the bell keeps its last count, htmx does not swap errors
+		log.Printf("failed to count notifications: %v", err)
+		c.Status(http.StatusBadGateway)
+		return
+	}
+	c.HTML(http.StatusOK, "partials/notification_badge.html", gin.H{"Unread": n})
+}
+
+// readNotificationHandler marks one of my notifications read.
+func readNotificationHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid notification id"})
+		return
+	}
+
+	url := fmt.Sprintf("%s/auth/notifications/%d/read", ds.TaskBase, id)
+	if err := ds.PostJSON(c.Request.Context(), bearer, url, nil, nil); err != nil {
+		respondDownstreamError(c, "TaskAPI: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readAllNotificationsHandler marks all my notifications read and goes back to them.
+func readAllNotificationsHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	if err := ds.PostJSON(c.Request.Context(), bearer, ds.TaskBase+"/auth/notifications/read-all", nil, nil); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/api/v1/auth/notifications")
+}
+
+// emailPreferencesHandler saves which notification emails I want, an
+// unchecked box is one I do not.
+func emailPreferencesHandler(c *gin.Context) {
+	bearer := c.GetString("kc.access_token")
+	if bearer == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "access_token missing"})
+		return
+	}
+
+	req := map[string]bool{}
+	for _, kind := range []string{"assigned", "deadline", "mention"} {
+		req[kind], _ = strconv.ParseBool(c.PostForm(kind))
+	}
+	if err := ds.PutJSON(c.Request.Context(), bearer, ds.TaskBase+"/auth/notifications/email", req, nil); err != nil {
+		c.HTML(http.StatusBadGateway, "error.html", gin.H{"error": "TaskAPI: " + err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/api/v1/auth/notifications")
 }
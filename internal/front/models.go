@@ -1,15 +1,62 @@
 package front
 
-import "time"
+import (
+	"html/template"
+	"slices"
+	"strings"
+	"time"
+)
 
 type Team struct {
 	TeamID      int64        `json:"teamid"`
 	Name        string       `json:"name"`
 	Description string       `json:"description"`
 	CreatedAt   string       `json:"created_at"`
+	Version     int          `json:"version"`
 	Leader      string       `json:"leader"` // optional
 	MemberCount int          `json:"memberCount"`
 	Members     []TeamMember `json:"members"`
+	Workflow    Workflow     `json:"workflow"`
+	Labels      []Label      `json:"labels"`
+}
+
+// Label is a team scoped task label; Color is #rrggbb.
+type Label struct {
+	LabelID int64  `json:"labelid"`
+	TeamID  int64  `json:"teamid,omitempty"`
+	Name    string `json:"name"`
+	Color   string `json:"color"`
+}
+
+// WorkflowStatus is one status of a team's workflow, in board order.
+type WorkflowStatus struct {
+	Name     string `json:"name"`
+	Terminal bool   `json:"terminal"`
+}
+
+type Workflow []WorkflowStatus
+
+func (w Workflow) Names() []string {
+	out := make([]string, 0, len(w))
+	for _, s := range w {
+		out = append(out, s.Name)
+	}
+	return out
+}
+
+func (w Workflow) Terminal() string {
+	for _, s := range w {
+		if s.Terminal {
+			return s.Name
+		}
+	}
+	return ""
+}
+
+// StatusCount is the number of tasks in one status; lists of them keep workflow order.
+type StatusCount struct {
+	Status string
+	Count  int
 }
 
 type TeamMember struct {
@@ -24,23 +71,150 @@ type Task struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	Author      string    `json:"author"`
-	Assignee    string    `json:"assignee"`
+	Assignees   []string  `json:"assignees"`
+	Watchers    []string  `json:"watchers"`
 	Status      string    `json:"status"`
 	Deadline    time.Time `json:"deadline"`
 	Priority    string    `json:"priority"`
 	CreatedAt   time.Time `json:"created_at"`
+	Version     int       `json:"version"`
+
+	ParentTaskID *int64          `json:"parent_taskid,omitempty"`
+	Labels       []Label         `json:"labels"`
+	Subtasks     []Task          `json:"subtasks,omitempty"`
+	Checklist    []ChecklistItem `json:"checklist,omitempty"`
+	Progress     *TaskProgress   `json:"progress,omitempty"`
+	BlockedBy    []Task          `json:"blocked_by,omitempty"`
+	Blocks       []Task          `json:"blocks,omitempty"`
+	RecurrenceOf *int64          `json:"recurrence_of,omitempty"`
+	Recurrence   *Recurrence     `json:"recurrence,omitempty"`
+
+	EstimateMinutes *int   `json:"estimate_minutes,omitempty"`
+	LoggedMinutes   int    `json:"logged_minutes,omitempty"`
+	MilestoneID     *int64 `json:"milestoneid,omitempty"`
+}
+
+// IsAssignee tells whether username is one of the task's assignees.
+func (t Task) IsAssignee(username string) bool {
+	return username != "" && slices.Contains(t.Assignees, username)
+}
+
+// TaskTemplate presets new tasks of a team, see TaskAPI templates.go.
+type TaskTemplate struct {
+	TemplateID   int64    `json:"templateid"`
+	TeamID       int64    `json:"teamid"`
+	Name         string   `json:"name"`
+	TitlePattern string   `json:"title_pattern"`
+	Description  string   `json:"description"`
+	Priority     string   `json:"priority"`
+	Checklist    []string `json:"checklist"`
+	DeadlineDays *int     `json:"deadline_days,omitempty"`
+	Labels       []Label  `json:"labels"`
+}
+
+// HasLabel reports whether the template applies the label, for the edit form.
+func (t TaskTemplate) HasLabel(labelID int64) bool {
+	for _, l := range t.Labels {
+		if l.LabelID == labelID {
+			return true
+		}
+	}
+	return false
+}
+
+// Recurrence repeats a template task, see TaskAPI recurrence.go.
+type Recurrence struct {
+	TemplateTaskID int64     `json:"template_taskid"`
+	Freq           string    `json:"freq"`
+	Interval       int       `json:"interval"`
+	Weekdays       []string  `json:"weekdays"`
+	State          string    `json:"state"`
+	LastDue        time.Time `json:"last_due"`
+	NextDue        time.Time `json:"next_due"`
+}
+
+// TimeEntry is time logged on a task, see TaskAPI timetracking.go.
+// A running timer has no EndedAt.
+type TimeEntry struct {
+	EntryID   int64      `json:"entryid"`
+	TaskID    int64      `json:"taskid"`
+	Username  string     `json:"username"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Minutes   *int       `json:"minutes,omitempty"`
+	Note      string     `json:"note"`
+}
+
+// TimeReport sums the time logged by a team or a user over [From, To).
+type TimeReport struct {
+	From         time.Time        `json:"from"`
+	To           time.Time        `json:"to"`
+	TeamID       int64            `json:"teamid,omitempty"`
+	Username     string           `json:"username,omitempty"`
+	TotalMinutes int              `json:"total_minutes"`
+	ByUser       []TimeReportUser `json:"by_user"`
+	ByTask       []TimeReportTask `json:"by_task"`
+}
+
+type TimeReportUser struct {
+	Username string `json:"username"`
+	Minutes  int    `json:"minutes"`
+}
+
+type TimeReportTask struct {
+	TaskID          int64  `json:"taskid"`
+	TeamID          int64  `json:"teamid"`
+	Title           string `json:"title"`
+	Status          string `json:"status"`
+	EstimateMinutes *int   `json:"estimate_minutes,omitempty"`
+	Minutes         int    `json:"minutes"`
+	TotalMinutes    int    `json:"total_minutes"`
+}
+
+type ChecklistItem struct {
+	ItemID   int64  `json:"itemid"`
+	TaskID   int64  `json:"taskid"`
+	Body     string `json:"body"`
+	Done     bool   `json:"done"`
+	Position int    `json:"position"`
+}
+
+type TaskProgress struct {
+	SubtasksDone   int `json:"subtasks_done"`
+	SubtasksTotal  int `json:"subtasks_total"`
+	ChecklistDone  int `json:"checklist_done"`
+	ChecklistTotal int `json:"checklist_total"`
+	Percent        int `json:"percent"`
 }
 
 type TeamListResponse struct {
-	Items []Team `json:"items"`
-	Limit int    `json:"limit"`
+	Items      []Team `json:"items"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// BulkResult is the outcome of a bulk operation for one task, see mtask.
+type BulkResult struct {
+	TaskID  int64          `json:"taskid"`
+	OK      bool           `json:"ok"`
+	Code    int            `json:"code"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type BulkResponse struct {
+	Op      string       `json:"op"`
+	Results []BulkResult `json:"results"`
+	Applied int          `json:"applied"`
+	Failed  int          `json:"failed"`
 }
 
 type TaskListResponse struct {
-	Items  []Task `json:"items"`
-	Limit  int    `json:"limit"`
-	Order  string `json:"order"`
-	Status string `json:"status"`
+	Items      []Task `json:"items"`
+	Limit      int    `json:"limit"`
+	Order      string `json:"order"`
+	Status     string `json:"status"`
+	NextCursor string `json:"next_cursor"`
 }
 
 type UserVM struct {
@@ -63,13 +237,28 @@ type DashboardVM struct {
 	TotalTeams int
 	TotalTasks int
 
-	StatusCounts map[string]int // TODO, IN_PROGRESS, DONE
+	StatusCounts []StatusCount
 
 	AssignedToMe []Task
 	CreatedByMe  []Task
 
 	// optional: list team names too
 	Teams []Team
+
+	// the latest times someone named me with @username
+	Mentions []Mention
+
+	// time tracking: the running timer, my logged time and that of the teams I lead
+	Timer      *TimeEntry
+	MyTime     TimeReport
+	TeamTime   []TeamTimeVM
+	ReportFrom string // yyyy-mm-dd
+	ReportTo   string // yyyy-mm-dd, included
+}
+
+type TeamTimeVM struct {
+	Team   Team
+	Report TimeReport
 }
 
 type TaskPreviewItem struct {
@@ -79,7 +268,7 @@ type TaskPreviewItem struct {
 
 type TeamTasksSummary struct {
 	TeamID int64
-	Counts map[string]int // TODO/IN_PROGRESS/DONE
+	Counts []StatusCount
 	Total  int
 
 	Preview []TaskPreviewItem // NEW (replaces PreviewTitles)}
@@ -91,6 +280,8 @@ type MyTeamRowVM struct {
 	Summary TeamTasksSummary
 
 	Preview []TaskPreviewItem // NEW (replaces PreviewTitles)
+
+	Templates []TaskTemplate // leader/admin only
 }
 
 type MyTeamsVM struct {
@@ -116,15 +307,180 @@ type MyTasksVM struct {
 	CanStatus bool // student/leader/admin
 
 	TotalTasks   int
-	StatusCounts map[string]int
+	StatusCounts []StatusCount
 	Tasks        []Task
+	// more tasks match than one page load fetches, see followCursor
+	Truncated bool
+
+	// statuses offered per team for the status select, and across teams for the filter
+	Workflows map[int64]Workflow
+	Statuses  []string
+	// label names of all my teams, for the filter
+	LabelNames []string
+	// templates offered by the create task modal
+	Templates []TaskTemplate
+
+	Filters MyTasksFilters
+}
+
+// WorkflowFor returns the statuses a task of teamID can be moved to.
+func (vm MyTasksVM) WorkflowFor(teamID int64) Workflow {
+	return vm.Workflows[teamID]
+}
+
+// SearchHit is a task found by the TaskAPI search, by its own text or by a comment.
+type SearchHit struct {
+	Kind      string    `json:"kind"` // task/comment
+	TaskID    int64     `json:"taskid"`
+	TeamID    int64     `json:"teamid"`
+	Title     string    `json:"title"`
+	Status    string    `json:"status"`
+	CommentID *int64    `json:"commentid,omitempty"`
+	Snippet   string    `json:"snippet"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SnippetHTML renders the snippet; the TaskAPI escapes it and only adds <mark> tags.
+func (h SearchHit) SnippetHTML() template.HTML {
+	return template.HTML(h.Snippet)
+}
+
+type SearchResponse struct {
+	Items []SearchHit `json:"items"`
+	Q     string      `json:"q"`
+}
+
+type SearchVM struct {
+	Title  string
+	Active string
+	User   UserVM
+
+	Query string
+	Hits  []SearchHit
+}
+
+type BoardColumn struct {
+	Status   string
+	Terminal bool
+	Tasks    []Task
+}
+
+// Milestone is a sprint of a team, see TaskAPI milestones.go.
+type Milestone struct {
+	MilestoneID int64      `json:"milestoneid"`
+	TeamID      int64      `json:"teamid"`
+	Name        string     `json:"name"`
+	StartsOn    time.Time  `json:"starts_on"`
+	EndsOn      time.Time  `json:"ends_on"`
+	State       string     `json:"state"` // planned, active or closed
+	ClosedAt    *time.Time `json:"closed_at,omitempty"`
+	TotalTasks  int        `json:"total_tasks"`
+	DoneTasks   int        `json:"done_tasks"`
+}
+
+// Percent is the share of its tasks that are done.
+func (m Milestone) Percent() int {
+	if m.TotalTasks == 0 {
+		return 0
+	}
+	return m.DoneTasks * 100 / m.TotalTasks
+}
+
+// BurndownPoint has no Remaining for days to come.
+type BurndownPoint struct {
+	Day       time.Time `json:"day"`
+	Remaining *int      `json:"remaining"`
+	Ideal     float64   `json:"ideal"`
+}
+
+type MilestoneDetail struct {
+	Milestone Milestone       `json:"milestone"`
+	Tasks     []Task          `json:"tasks"`
+	Burndown  []BurndownPoint `json:"burndown"`
+}
+
+// BurndownChart is a burndown laid out for an SVG of Width x Height:
+// Ideal and Actual are polyline points.
+type BurndownChart struct {
+	Width, Height int
+	Ideal, Actual string
+	Max           int
+	First, Last   string // first and last day
+}
+
+type SprintsVM struct {
+	Title  string
+	Active string
+	User   UserVM
+
+	Team       Team
+	Milestones []Milestone
+	Selected   *MilestoneDetail
+	Columns    []BoardColumn // the selected milestone's tasks by status
+	Chart      BurndownChart
+
+	CanEdit bool // leader of the team or admin
+}
+
+// NextCandidates are the milestones the selected one's unfinished tasks may roll into.
+func (vm SprintsVM) NextCandidates() []Milestone {
+	out := make([]Milestone, 0, len(vm.Milestones))
+	for _, m := range vm.Milestones {
+		if m.State != "closed" && (vm.Selected == nil || m.MilestoneID != vm.Selected.Milestone.MilestoneID) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+type BoardVM struct {
+	Title  string
+	Active string
+	User   UserVM
+
+	Team     Team
+	Columns  []BoardColumn
+	Assignee string // only this user's tasks are shown
+	// the team has more tasks than one page load fetches, see followCursor
+	Truncated bool
+
+	CanEdit bool // leader of the team or admin, offers the leader bulk actions
+}
+
+// Statuses are the board's columns, offered by the bulk status action.
+func (vm BoardVM) Statuses() []string {
+	out := make([]string, 0, len(vm.Columns))
+	for _, col := range vm.Columns {
+		out = append(out, col.Status)
+	}
+	return out
+}
+
+// MyTasksFilters echoes the filter form of the my tasks page
+type MyTasksFilters struct {
+	Status     string
+	Priority   string
+	DueFrom    string
+	DueTo      string
+	Order      string
+	Labels     []string
+	LabelMatch string
+}
+
+func (f MyTasksFilters) HasLabel(name string) bool {
+	for _, l := range f.Labels {
+		if strings.EqualFold(l, name) {
+			return true
+		}
+	}
+	return false
 }
 
 type AdminTeamRowVM struct {
 	Team Team
 
 	TotalTasks    int
-	StatusCounts  map[string]int
+	StatusCounts  []StatusCount
 	PreviewTitles []string
 }
 
@@ -136,6 +492,41 @@ type AdminTeamsVM struct {
 	Rows []AdminTeamRowVM
 }
 
+// TrashListResponse is a trash listing of TeamAPI or TaskAPI.
+type TrashListResponse[T any] struct {
+	Items         []T `json:"items"`
+	RetentionDays int `json:"retention_days"`
+}
+
+type TrashedTeam struct {
+	TeamID    int64     `json:"teamid"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by"`
+	TaskCount int       `json:"task_count"`
+}
+
+type TrashedTask struct {
+	TaskID      int64     `json:"taskid"`
+	TeamID      int64     `json:"teamid"`
+	TeamName    string    `json:"team_name"`
+	Title       string    `json:"title"`
+	Status      string    `json:"status"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	DeletedBy   string    `json:"deleted_by"`
+	TeamTrashed bool      `json:"team_trashed"`
+}
+
+type AdminTrashVM struct {
+	Title  string
+	Active string
+	User   UserVM
+
+	Teams         []TrashedTeam
+	Tasks         []TrashedTask
+	RetentionDays int
+}
+
 type UserPick struct {
 	ID       string
 	Username string
@@ -143,9 +534,90 @@ type UserPick struct {
 }
 
 type Comment struct {
-	CommentID int64     `json:"commentid"`
+	CommentID       int64      `json:"commentid"`
+	TaskID          int64      `json:"taskid"`
+	ParentCommentID *int64     `json:"parent_commentid,omitempty"`
+	Author          string     `json:"author"`
+	Body            string     `json:"body"`
+	CreatedAt       time.Time  `json:"created_at"`
+	EditedAt        *time.Time `json:"edited_at,omitempty"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+	Depth           int        `json:"depth"`
+}
+
+// TaskEvent is one entry of a task's history as returned by mtask.
+type TaskEvent struct {
+	EventID   int64     `json:"eventid"`
 	TaskID    int64     `json:"taskid"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Field     string    `json:"field,omitempty"`
+	OldValue  string    `json:"old_value,omitempty"`
+	NewValue  string    `json:"new_value,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TaskHistoryResponse struct {
+	Items []TaskEvent `json:"items"`
+}
+
+type CommentListResponse struct {
+	Items       []Comment `json:"items"`
+	Limit       int       `json:"limit"`
+	CanModerate bool      `json:"can_moderate"`
+	NextCursor  string    `json:"next_cursor"`
+}
+
+// Mention is the user named with @username in a task's description or, with
+// CommentID, in one of its comments; Excerpt is that text.
+type Mention struct {
+	MentionID int64     `json:"mentionid"`
+	TaskID    int64     `json:"taskid"`
+	TeamID    int64     `json:"teamid"`
+	TaskTitle string    `json:"task_title"`
+	CommentID *int64    `json:"commentid,omitempty"`
+	Username  string    `json:"username"`
 	Author    string    `json:"author"`
-	Body      string    `json:"body"`
+	Excerpt   string    `json:"excerpt"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// Notification tells the user what Actor did to a task they work on or
+// follow; Kind is assigned, status, comment or mention, or deadline (from
+// nobody) when the task is due soon.
+type Notification struct {
+	NotificationID int64      `json:"notificationid"`
+	Kind           string     `json:"kind"`
+	TaskID         int64      `json:"taskid"`
+	TeamID         int64      `json:"teamid"`
+	TaskTitle      string     `json:"task_title"`
+	Actor          string     `json:"actor"`
+	Detail         string     `json:"detail"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ReadAt         *time.Time `json:"read_at,omitempty"`
+}
+
+type NotificationListResponse struct {
+	Items  []Notification `json:"items"`
+	Unread int            `json:"unread"`
+}
+
+type NotificationsVM struct {
+	Title  string
+	Active string
+	User   UserVM
+
+	Items      []Notification
+	Unread     int
+	UnreadOnly bool
+	Email      EmailPreferences
+}
+
+// EmailPreferences are the notification emails the user wants; Enabled is
+// false when the task service sends none.
+type EmailPreferences struct {
+	Assigned bool `json:"assigned"`
+	Deadline bool `json:"deadline"`
+	Mention  bool `json:"mention"`
+	Enabled  bool `json:"enabled"`
+}
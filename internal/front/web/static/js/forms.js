@@ -0,0 +1,32 @@
+// Forms marked data-inline-errors post with htmx (hx-swap="none") and show a
+// refused request next to the field it is about. The backends answer a 422
+// with {"error", "field", "invalid"}; the error goes to the form's
+// .field-error[data-for=field], or its first .field-error, and the field is
+// marked invalid. A page instead of JSON (a front side check) shows its text.
+document.addEventListener('htmx:afterRequest', function (event) {
+  const form = event.detail.elt;
+  if (!form.matches || !form.matches('form[data-inline-errors]')) return;
+
+  form.querySelectorAll('.field-error').forEach(el => { el.textContent = ''; });
+  form.querySelectorAll('[aria-invalid]').forEach(el => el.removeAttribute('aria-invalid'));
+  if (event.detail.successful) return;
+
+  const xhr = event.detail.xhr;
+  let body;
+  try {
+    body = JSON.parse(xhr.responseText);
+  } catch (e) {
+    const doc = new DOMParser().parseFromString(xhr.responseText || '', 'text/html');
+    const text = doc.querySelector('h1') ? doc.querySelector('h1').textContent.trim() : '';
+    body = { error: text.replace(/^error\s*/i, '') || `request failed (${xhr.status})` };
+  }
+
+  const field = body.field ? form.querySelector(`[name="${CSS.escape(body.field)}"]`) : null;
+  const slot = (body.field && form.querySelector(`.field-error[data-for="${CSS.escape(body.field)}"]`)) ||
+    form.querySelector('.field-error');
+  if (slot) slot.textContent = body.error || 'request failed';
+  if (field) {
+    field.setAttribute('aria-invalid', 'true');
+    field.focus();
+  }
+});
@@ -95,20 +95,73 @@ func setRoutes() {
 	kcAuth := mustInitKcAuth()
 	// need to enforce middleware check for authz
 	secure := engine.Group("/auth")
-	secure.Use(kcAuth.RequireRoles("leader", "student", "admin"))
+	secure.Use(kcAuth.RequireRoles("leader", "student", "admin"), rememberEmail)
 	{
 		secure.GET("/mytask", handlePersonalTask)
 		secure.GET("/tasks", handleListTasks)
 		secure.GET("/tasks/:id", handleGetTaskByID) // NEW
+		secure.GET("/tasks/:id/history", handleTaskHistory)
+		secure.GET("/search", handleSearch)
 
 		secure.POST("/tasks", handleTaskCreate)
 		secure.PUT("/tasks", handleTaskUpdate)
 		secure.DELETE("/tasks", handleTaskDelete)
 		secure.PATCH("/change-status", handleTaskPatch)
+		secure.POST("/tasks/bulk", handleTaskBulk)
+		secure.POST("/tasks/:id/watch", handleTaskWatch)
+		secure.DELETE("/tasks/:id/watch", handleTaskWatch)
 
 		secure.POST("/comments", handleCommentCreate)
+		secure.PUT("/comments", handleCommentUpdate)
 		secure.DELETE("/comments", handleCommentDelete)
 		secure.GET("/comments", handleCommentList)
+		secure.GET("/mentions", handleMentionList)
+
+		secure.GET("/notifications", handleNotificationList)
+		secure.GET("/notifications/unread", handleNotificationCount)
+		secure.POST("/notifications/:id/read", handleNotificationRead)
+		secure.POST("/notifications/read-all", handleNotificationReadAll)
+		secure.GET("/notifications/email", handleEmailPreferencesGet)
+		secure.PUT("/notifications/email", handleEmailPreferencesSet)
+
+		secure.POST("/checklist", handleChecklistCreate)
+		secure.PUT("/checklist", handleChecklistUpdate)
+		secure.DELETE("/checklist", handleChecklistDelete)
+
+		secure.POST("/dependencies", handleDependencyCreate)
+		secure.DELETE("/dependencies", handleDependencyDelete)
+
+		secure.GET("/templates", handleTemplateList)
+		secure.GET("/templates/:id", handleTemplateGet)
+		secure.POST("/templates", handleTemplateCreate)
+		secure.PUT("/templates/:id", handleTemplateUpdate)
+		secure.DELETE("/templates/:id", handleTemplateDelete)
+
+		secure.GET("/tasks/:id/recurrence", handleRecurrenceGet)
+		secure.PUT("/tasks/:id/recurrence", handleRecurrenceSet)
+		secure.PATCH("/tasks/:id/recurrence", handleRecurrenceState)
+
+		secure.GET("/tasks/:id/time", handleTimeEntryList)
+		secure.POST("/tasks/:id/time", handleTimeLog)
+		secure.POST("/tasks/:id/timer", handleTimerStart)
+		secure.GET("/timer", handleTimerGet)
+		secure.POST("/timer/stop", handleTimerStop)
+		secure.GET("/reports/time", handleTimeReport)
+
+		secure.GET("/milestones", handleMilestoneList)
+		secure.GET("/milestones/:id", handleMilestoneGet)
+		secure.POST("/milestones", handleMilestoneCreate)
+		secure.PUT("/milestones/:id", handleMilestoneUpdate)
+		secure.POST("/milestones/:id/start", handleMilestoneStart)
+		secure.POST("/milestones/:id/close", handleMilestoneClose)
+		secure.DELETE("/milestones/:id", handleMilestoneDelete)
+	}
+
+	admin := engine.Group("/admin")
+	admin.Use(kcAuth.RequireRoles("admin"))
+	{
+		admin.GET("/tasks/trash", handleTrashList)
+		admin.POST("/tasks/:id/restore", handleTrashRestore)
 	}
 }
 
@@ -116,6 +169,8 @@ func InitAndServe(confPath string) {
 	config = loadConfig(confPath)
 
 	initSqlPath = config.InitSQLPath
+	mustInitTransitions(config.StatusTransitions)
+	mustInitGatedStatuses(config.BlockerGatedStatuses)
 
 	engine = gin.Default()
 	setGinMode(config.ApiGinMode)
@@ -124,11 +179,17 @@ func InitAndServe(confPath string) {
 	setRoutes()
 
 	initDBConn()
+	mustInitMailer()
 
 	// serve http
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	go purgeTrash(ctx, config.TrashRetentionDays)
+	go runRecurrences(ctx, time.Duration(max(config.RecurrenceCheckMinutes, 1))*time.Minute)
+	go runMailer(ctx, time.Minute)
+	go runDeadlineReminders(ctx, time.Duration(config.DeadlineReminderHours)*time.Hour)
+
 	server := &http.Server{
 		Addr:              fmt.Sprintf(":%s", config.Port),
 		Handler:           engine,
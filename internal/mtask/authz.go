@@ -0,0 +1,150 @@
+package mtask
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	errNotAllowed = errors.New("not allowed")
+	errAuthzDB    = errors.New("db error")
+)
+
+func mustUsername(c *gin.Context) (string, bool) {
+	v, ok := c.Get("kc.username")
+	if !ok {
+		return "", false
+	}
+	s, ok := v.(string)
+	return s, ok && s != ""
+}
+
+func isAdmin(c *gin.Context) bool {
+	rolesAny, _ := c.Get("kc.roles")
+	roles, _ := rolesAny.([]string)
+	for _, r := range roles {
+		if r == "admin" {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureCanViewTeam allows admins and members of the team.
+func ensureCanViewTeam(c *gin.Context, teamID int64) error {
+	if isAdmin(c) {
+		return nil
+	}
+
+	username, ok := mustUsername(c)
+	if !ok {
+		return errNotAllowed
+	}
+
+	ok, err := IsTeamMember(c.Request.Context(), teamID, username)
+	if err != nil {
+		log.Printf("IsTeamMember failed: %v", err)
+		return errAuthzDB
+	}
+	if !ok {
+		return errNotAllowed
+	}
+	return nil
+}
+
+// ensureCanManageTeam allows admins and the leader of the team.
+func ensureCanManageTeam(c *gin.Context, teamID int64) error {
+	if isAdmin(c) {
+		return nil
+	}
+
+	username, ok := mustUsername(c)
+	if !ok {
+		return errNotAllowed
+	}
+
+	ok, err := IsTeamLeader(c.Request.Context(), teamID, username)
+	if err != nil {
+		log.Printf("IsTeamLeader failed: %v", err)
+		return errAuthzDB
+	}
+	if !ok {
+		return errNotAllowed
+	}
+	return nil
+}
+
+// respondAuthzError writes the response for an error returned by one of the ensure* checks.
+func respondAuthzError(c *gin.Context, err error) {
+	c.JSON(authzErrorResponse(err))
+}
+
+func authzErrorResponse(err error) (int, gin.H) {
+	if errors.Is(err, errAuthzDB) {
+		return http.StatusInternalServerError, gin.H{"error": "db error"}
+	}
+	return http.StatusForbidden, gin.H{"error": err.Error()}
+}
+
+// loadTaskFor fetches the task and runs check against its team.
+// On failure the response is already written and ok is false.
+func loadTaskFor(c *gin.Context, taskID int64, check func(*gin.Context, int64) error) (*Task, bool) {
+	task, err := getTask(c.Request.Context(), pool, taskID, false)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+			return nil, false
+		}
+		log.Printf("failed to get task: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return nil, false
+	}
+
+	if err := check(c, task.TeamID); err != nil {
+		respondAuthzError(c, err)
+		return nil, false
+	}
+	return task, true
+}
+
+// ensureCanWorkOnTask allows admins, the team leader and the task's assignees.
+func ensureCanWorkOnTask(c *gin.Context, task *Task) error {
+	if username, _ := mustUsername(c); task.IsAssignee(username) {
+		return nil
+	}
+	return ensureCanManageTeam(c, task.TeamID)
+}
+
+// loadCommentFor fetches the comment and checks that the caller may edit or delete it:
+// its author, the leader of the task's team or an admin.
+// On failure the response is already written and ok is false.
+func loadCommentFor(c *gin.Context, commentID int64) (*Comment, bool) {
+	cmt, err := GetCommentByID(c.Request.Context(), commentID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+			return nil, false
+		}
+		log.Printf("failed to get comment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return nil, false
+	}
+
+	task, ok := loadTaskFor(c, cmt.TaskID, ensureCanViewTeam)
+	if !ok {
+		return nil, false
+	}
+
+	if username, _ := mustUsername(c); username != "" && username == cmt.Author {
+		return cmt, true
+	}
+	if err := ensureCanManageTeam(c, task.TeamID); err != nil {
+		respondAuthzError(c, err)
+		return nil, false
+	}
+	return cmt, true
+}
@@ -0,0 +1,160 @@
+package mtask
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// bulk task operations
+//
+// POST /auth/tasks/bulk runs the checks of the single-task endpoints for every
+// task first: assign, priority and delete need the team's leader (PUT/DELETE
+// /tasks), status follows the transition policy like PATCH /change-status,
+// ?confirm=true and ?force=true included. The tasks that pass are changed in
+// one transaction, those that do not are reported and left alone. A task
+// changed by someone else between its check and the transaction is refused
+// with 412 like a stale If-Match, its checks no longer hold.
+
+func handleTaskBulk(c *gin.Context) {
+	var req BulkTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	update := &UpdateTaskRequest{}
+	switch req.Op {
+	case "assign":
+		update.Assignees = req.Assignees
+	case "priority":
+		update.Priority = req.Priority
+	case "status":
+		if req.Status != nil && !validStatusName(*req.Status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+			return
+		}
+		update.Status = req.Status
+	case "delete":
+		update = nil
+	}
+	if update != nil && update.Assignees == nil && update.Priority == nil && update.Status == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provide the " + req.Op + " value"})
+		return
+	}
+
+	// sorted so concurrent batches lock their rows in the same order
+	ids := slices.Clone(req.TaskIDs)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	results := make([]BulkResult, 0, len(ids))
+	apply := make([]int64, 0, len(ids))
+	// the version each task was checked at
+	versions := make(map[int64]int, len(ids))
+	for _, id := range ids {
+		version, code, body := bulkCheck(c, id, req)
+		if code != 0 {
+			results = append(results, bulkFailure(id, code, body))
+			continue
+		}
+		apply = append(apply, id)
+		versions[id] = version
+	}
+
+	var missing, stale []int64
+	if len(apply) > 0 {
+		actor, _ := mustUsername(c)
+		var err error
+		missing, stale, err = BulkUpdateTasks(c.Request.Context(), apply, versions, actor, update)
+		if err != nil {
+			log.Printf("failed to apply bulk %s: %v", req.Op, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if req.Op == "status" {
+			wakeRecurrences()
+		}
+		wakeMailer()
+	}
+	for _, id := range apply {
+		if slices.Contains(missing, id) {
+			results = append(results, bulkFailure(id, http.StatusNotFound, gin.H{"error": "task not found"}))
+			continue
+		}
+		if slices.Contains(stale, id) {
+			results = append(results, bulkFailure(id, http.StatusPreconditionFailed, gin.H{"error": errVersionMismatch.Error()}))
+			continue
+		}
+		results = append(results, BulkResult{TaskID: id, OK: true, Code: http.StatusOK})
+	}
+	slices.SortFunc(results, func(a, b BulkResult) int { return cmp.Compare(a.TaskID, b.TaskID) })
+
+	failed := len(ids) - len(apply) + len(missing) + len(stale)
+	c.JSON(http.StatusOK, gin.H{
+		"op":      req.Op,
+		"results": results,
+		"applied": len(ids) - failed,
+		"failed":  failed,
+	})
+}
+
+// bulkCheck runs the checks the single-task endpoint of req.Op does for one
+// task and returns the version of the task it checked. It returns the refusal
+// that endpoint would answer, code is 0 when the task may be changed.
+func bulkCheck(c *gin.Context, taskID int64, req BulkTaskRequest) (version, code int, body gin.H) {
+	task, err := getTask(c.Request.Context(), pool, taskID, false)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, http.StatusNotFound, gin.H{"error": "task not found"}
+		}
+		log.Printf("failed to get task: %v", err)
+		return 0, http.StatusInternalServerError, gin.H{"error": "db error"}
+	}
+	version = task.Version
+
+	if req.Op != "status" {
+		if err := ensureCanManageTeam(c, task.TeamID); err != nil {
+			code, body = authzErrorResponse(err)
+			return version, code, body
+		}
+		if req.Op == "assign" {
+			code, body = assigneesResponse(c, task.TeamID, *req.Assignees)
+		}
+		return version, code, body
+	}
+
+	if err := ensureCanViewTeam(c, task.TeamID); err != nil {
+		code, body = authzErrorResponse(err)
+		return version, code, body
+	}
+	if err := checkTransition(c, task, *req.Status); err != nil {
+		code, body = transitionErrorResponse(task, *req.Status, err)
+		return version, code, body
+	}
+	if code, body = openSubtasksResponse(c, task, *req.Status); code != 0 {
+		return version, code, body
+	}
+	code, body = blockersResponse(c, task, *req.Status)
+	return version, code, body
+}
+
+func bulkFailure(taskID int64, code int, body gin.H) BulkResult {
+	r := BulkResult{TaskID: taskID, Code: code}
+	for k, v := range body {
+		if k == "error" {
+			r.Error, _ = v.(string)
+			continue
+		}
+		if r.Details == nil {
+			r.Details = make(map[string]any, len(body))
+		}
+		r.Details[k] = v
+	}
+	return r
+}
@@ -36,6 +36,27 @@ type Config struct {
 	ClientID     string
 	ClientSecret string
 
+	// status transitions, see workflow.go
+	StatusTransitions []string
+	// statuses a task with unfinished blockers cannot enter, see dependencies.go
+	BlockerGatedStatuses []string
+	// days a deleted task stays in the trash, see trash.go
+	TrashRetentionDays int
+	// minutes between two runs of the recurrence scheduler, see recurrence.go
+	RecurrenceCheckMinutes int
+	// hours before a deadline its assignees are reminded, 0 for never, see email.go
+	DeadlineReminderHours int
+
+	// email notifications, off without an SMTP address, see email.go
+	SMTPAddress       string
+	SMTPUsername      string
+	SMTPPassword      string
+	MailFrom          string
+	MailTemplatesPath string
+	MailMaxAttempts   int
+	// the front's address, for the links in the emails
+	AppURL string
+
 	// database
 	DBUser     string
 	DBPassword string
@@ -70,6 +91,20 @@ func loadConfig(path string) Config {
 		ClientID:     getEnv("KC_CLIENT", "admin"),
 		ClientSecret: getEnv("KC_CLIENT_SECRET", ""),
 
+		StatusTransitions:      getEnvFields("STATUS_TRANSITIONS", defaultTransitions),
+		BlockerGatedStatuses:   getEnvFields("BLOCKER_GATED_STATUSES", defaultGatedStatuses),
+		TrashRetentionDays:     getIntEnv("TRASH_RETENTION_DAYS", 30),
+		RecurrenceCheckMinutes: getIntEnv("RECURRENCE_CHECK_MINUTES", 5),
+		DeadlineReminderHours:  getIntEnv("DEADLINE_REMINDER_HOURS", 24),
+
+		SMTPAddress:       getEnv("SMTP_ADDRESS", ""),
+		SMTPUsername:      getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:      getEnv("SMTP_PASSWORD", ""),
+		MailFrom:          getEnv("MAIL_FROM", "TaskBoard <noreply@localhost>"),
+		MailTemplatesPath: getEnv("MAIL_TEMPLATES_PATH", "./internal/mtask/mail"),
+		MailMaxAttempts:   getIntEnv("MAIL_MAX_ATTEMPTS", 6),
+		AppURL:            getEnv("APP_URL", "http://localhost:5045"),
+
 		DBUser:     getEnv("DB_USER", "postgres"),
 		DBPassword: getEnv("DB_PASSWORD", "postgres"),
 		DBAddress:  getEnv("DB_ADDRESS", "api-db:5432"),
@@ -90,8 +125,10 @@ func getSecret(env string, fail bool) []byte {
 	return []byte(secret)
 }
 
+// getEnv returns the value of env, fallback when it is unset or blank (as the
+// keys config/template leaves empty are).
 func getEnv(env, fallback string) string {
-	if value, exists := os.LookupEnv(env); exists {
+	if value, exists := os.LookupEnv(env); exists && strings.TrimSpace(value) != "" {
 		return value
 	}
 
@@ -99,7 +136,7 @@ func getEnv(env, fallback string) string {
 }
 
 func getEnvFields(env string, fallback []string) []string {
-	if value, exists := os.LookupEnv(env); exists {
+	if value, exists := os.LookupEnv(env); exists && strings.TrimSpace(value) != "" {
 		fields := strings.Split(strings.TrimSpace(value), ",")
 
 		return fields
@@ -142,6 +179,10 @@ func (cfg *Config) toString() string {
 		if byteSlice, ok := fieldValue.([]byte); ok {
 			fieldValue = string(byteSlice)
 		}
+		// the mail server's credentials stay out of the logs
+		if fieldName == "SMTPPassword" && cfg.SMTPPassword != "" {
+			fieldValue = "***"
+		}
 
 		strBuilder.WriteString("[CFG]")
 		if i < 9 {
@@ -2,14 +2,27 @@ package mtask
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
+	"html"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"kyri56xcaesar/pms-proj/internal/utils"
+
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// querier is satisfied by both the pool and a transaction
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 func CreateTask(ctx context.Context, author string, req CreateTaskRequest) (int64, error) {
 	status := req.Status
 	if status == "" {
@@ -20,27 +33,310 @@ func CreateTask(ctx context.Context, author string, req CreateTaskRequest) (int6
 		priority = "MEDIUM"
 	}
 
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
 	var id int64
-	err := pool.QueryRow(ctx, `
-		INSERT INTO tasks (teamid, title, description, author, assignee, status, deadline, priority)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+	err = tx.QueryRow(ctx, `
+		INSERT INTO tasks (teamid, title, description, author, status, deadline, priority, parent_taskid, estimate_minutes, milestoneid)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,NULLIF($9,0),$10)
 		RETURNING taskid
-	`, req.TeamID, req.Title, req.Description, author, req.Assignee, status, req.Deadline, priority).Scan(&id)
-	return id, err
+	`, req.TeamID, req.Title, req.Description, author, status, req.Deadline, priority, req.ParentTaskID,
+		req.EstimateMinutes, req.MilestoneID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(req.Assignees) > 0 {
+		assignees, err := setTaskAssignees(ctx, tx, id, req.Assignees)
+		if err != nil {
+			return 0, err
+		}
+		if err := notify(ctx, tx, notifyAssigned, id, author, "", assignees); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(req.LabelIDs) > 0 {
+		if _, err := setTaskLabels(ctx, tx, id, req.TeamID, req.LabelIDs); err != nil {
+			return 0, err
+		}
+	}
+	mentioned, err := syncMentions(ctx, tx, id, nil, author, req.Description)
+	if err != nil {
+		return 0, err
+	}
+	if err := notify(ctx, tx, notifyMention, id, author, req.Description, mentioned); err != nil {
+		return 0, err
+	}
+	if len(req.Checklist) > 0 {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO task_checklist_items (taskid, body, position)
+			SELECT $1, body, pos - 1 FROM unnest($2::text[]) WITH ORDINALITY AS c(body, pos)
+		`, id, req.Checklist)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = insertTaskEvents(ctx, tx, TaskEvent{
+		TaskID:   id,
+		TeamID:   req.TeamID,
+		Actor:    author,
+		Action:   "created",
+		NewValue: req.Title,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
-func DeleteTask(ctx context.Context, taskID int64) error {
-	ct, err := pool.Exec(ctx, `DELETE FROM tasks WHERE taskid = $1`, taskID)
+func DeleteTask(ctx context.Context, taskID int64, actor string) error {
+	tx, err := pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	if ct.RowsAffected() == 0 {
-		return pgx.ErrNoRows
+	defer tx.Rollback(ctx)
+
+	if _, err := deleteTask(ctx, tx, taskID, actor); err != nil {
+		return err
 	}
-	return nil
+
+	return tx.Commit(ctx)
+}
+
+// subtaskTree is the CTE of the task bound to $1 and its subtasks at any depth,
+// the task and its subtasks (under the alias c) matching cond.
+func subtaskTree(cond string) string {
+	return `WITH RECURSIVE tree AS (
+			SELECT c.taskid FROM tasks c WHERE c.taskid = $1 AND ` + cond + `
+			UNION
+			SELECT c.taskid FROM tasks c JOIN tree ON c.parent_taskid = tree.taskid
+			WHERE ` + cond + `
+		)`
+}
+
+// deleteTask moves the task to the trash inside tx with its live subtasks, all
+// with the same deleted_at so that they are restored together, and records the
+// deletions in their history. It returns the ids of the tasks it trashed.
+func deleteTask(ctx context.Context, tx pgx.Tx, taskID int64, actor string) ([]int64, error) {
+	rows, err := tx.Query(ctx, subtaskTree("c.deleted_at IS NULL")+`
+		UPDATE tasks SET deleted_at = now(), deleted_by = $2
+		WHERE taskid IN (SELECT taskid FROM tree) AND deleted_at IS NULL
+		RETURNING taskid, teamid, COALESCE(title,'')
+	`, taskID, actor)
+	if err != nil {
+		return nil, err
+	}
+	type trashedRow struct {
+		TaskID int64
+		TeamID int64
+		Title  string
+	}
+	trashed, err := pgx.CollectRows(rows, pgx.RowToStructByPos[trashedRow])
+	if err != nil {
+		return nil, err
+	}
+	// the tree is empty when the task is gone or already in the trash
+	if len(trashed) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+
+	ids := make([]int64, 0, len(trashed))
+	events := make([]TaskEvent, 0, len(trashed))
+	for _, t := range trashed {
+		ids = append(ids, t.TaskID)
+		events = append(events, TaskEvent{
+			TaskID:   t.TaskID,
+			TeamID:   t.TeamID,
+			Actor:    actor,
+			Action:   "deleted",
+			OldValue: t.Title,
+		})
+	}
+	return ids, insertTaskEvents(ctx, tx, events...)
+}
+
+var (
+	errNotInTrash  = errors.New("task is not in the trash")
+	errTeamInTrash = errors.New("the task's team is in the trash, restore the team first")
+	// subtasks trashed with their parent are restored with it
+	errParentInTrash = errors.New("the task's parent is in the trash, restore the parent first")
+)
+
+// ListTrashedTasks returns the tasks deleted on their own, most recent first.
+// Tasks of a team in the trash are restored with their team, see mteam, and
+// subtasks trashed with their parent with the parent.
+func ListTrashedTasks(ctx context.Context, limit int) ([]TrashedTask, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT t.taskid, t.teamid, COALESCE(tm.name,''), COALESCE(t.title,''), COALESCE(t.status,''),
+		       t.deleted_at, COALESCE(t.deleted_by,''), tm.deleted_at IS NOT NULL
+		FROM tasks t
+		LEFT JOIN teams tm ON tm.teamid = t.teamid
+		WHERE t.deleted_at IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM tasks p WHERE p.taskid = t.parent_taskid AND p.deleted_at = t.deleted_at)
+		ORDER BY t.deleted_at DESC, t.taskid DESC
+		LIMIT $1
+	`, normalizeLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[TrashedTask])
+}
+
+// RestoreTask takes a task out of the trash and records it in its history.
+func RestoreTask(ctx context.Context, taskID int64, actor string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		deletedAt     *time.Time
+		deletedBy     *string
+		teamTrashed   bool
+		parentTrashed bool
+	)
+	err = tx.QueryRow(ctx, `
+		SELECT t.deleted_at, t.deleted_by,
+		       EXISTS (SELECT 1 FROM teams tm WHERE tm.teamid = t.teamid AND tm.deleted_at IS NOT NULL),
+		       EXISTS (SELECT 1 FROM tasks p WHERE p.taskid = t.parent_taskid AND p.deleted_at IS NOT NULL)
+		FROM tasks t
+		WHERE t.taskid = $1
+		FOR UPDATE
+	`, taskID).Scan(&deletedAt, &deletedBy, &teamTrashed, &parentTrashed)
+	if err != nil {
+		return err
+	}
+	if deletedAt == nil {
+		return errNotInTrash
+	}
+	if teamTrashed {
+		return errTeamInTrash
+	}
+	if parentTrashed {
+		return errParentInTrash
+	}
+
+	// the subtasks trashed with it come back, those deleted on their own stay
+	rows, err := tx.Query(ctx, subtaskTree("c.deleted_at = $2 AND c.deleted_by IS NOT DISTINCT FROM $3")+`
+		UPDATE tasks SET deleted_at = NULL, deleted_by = NULL, version = version + 1
+		WHERE taskid IN (SELECT taskid FROM tree)
+		RETURNING taskid, teamid, COALESCE(title,'')
+	`, taskID, *deletedAt, deletedBy)
+	if err != nil {
+		return err
+	}
+	events, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (TaskEvent, error) {
+		e := TaskEvent{Actor: actor, Action: "restored"}
+		err := row.Scan(&e.TaskID, &e.TeamID, &e.NewValue)
+		return e, err
+	})
+	if err != nil {
+		return err
+	}
+	if err := insertTaskEvents(ctx, tx, events...); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// PurgeTrashedTasks deletes for good the tasks in the trash since before cutoff,
+// with their comments, checklist and links; task_events keeps their history.
+func PurgeTrashedTasks(ctx context.Context, cutoff time.Time) (int64, error) {
+	ct, err := pool.Exec(ctx, `DELETE FROM tasks WHERE deleted_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
+
+// BulkUpdateTasks applies req to every task, or deletes them when req is nil,
+// in one transaction. Each task must still be at its version in versions, the
+// one its checks ran on. Tasks gone in the meantime are returned in missing,
+// those changed in stale, and skipped; any other error rolls the whole batch
+// back.
+func BulkUpdateTasks(ctx context.Context, taskIDs []int64, versions map[int64]int, actor string, req *UpdateTaskRequest) (missing, stale []int64, err error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// subtasks go to the trash with their parent, also when listed themselves
+	var trashed []int64
+	for _, id := range taskIDs {
+		if req == nil {
+			if slices.Contains(trashed, id) {
+				continue
+			}
+			var ids []int64
+			ids, err = deleteTaskAt(ctx, tx, id, actor, versions[id])
+			trashed = append(trashed, ids...)
+		} else {
+			_, err = updateTask(ctx, tx, id, actor, versions[id], *req)
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			missing = append(missing, id)
+			continue
+		}
+		if errors.Is(err, errVersionMismatch) {
+			stale = append(stale, id)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return missing, stale, tx.Commit(ctx)
+}
+
+// deleteTaskAt is deleteTask for a task still at version, a different current
+// version fails with errVersionMismatch.
+func deleteTaskAt(ctx context.Context, tx pgx.Tx, taskID int64, actor string, version int) ([]int64, error) {
+	task, err := getTask(ctx, tx, taskID, true)
+	if err != nil {
+		return nil, err
+	}
+	if task.Version != version {
+		return nil, errVersionMismatch
+	}
+	return deleteTask(ctx, tx, taskID, actor)
+}
+
+var errVersionMismatch = errors.New("task was changed by someone else")
+
+// UpdateTask applies req when the task is still at version and returns its new
+// version. A different current version fails with errVersionMismatch.
+func UpdateTask(ctx context.Context, taskID int64, actor string, version int, req UpdateTaskRequest) (int, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	next, err := updateTask(ctx, tx, taskID, actor, version, req)
+	if err != nil {
+		return 0, err
+	}
+
+	return next, tx.Commit(ctx)
 }
 
-func UpdateTask(ctx context.Context, taskID int64, req UpdateTaskRequest) error {
+// updateTask applies req inside tx and records one history event per changed field.
+// version 0 skips the version check.
+func updateTask(ctx context.Context, tx pgx.Tx, taskID int64, actor string, version int, req UpdateTaskRequest) (int, error) {
 	sets := make([]string, 0, 6)
 	args := make([]any, 0, 7)
 	i := 1
@@ -55,18 +351,14 @@ func UpdateTask(ctx context.Context, taskID int64, req UpdateTaskRequest) error
 		args = append(args, *req.Description)
 		i++
 	}
-	if req.Assignee != nil {
-		sets = append(sets, fmt.Sprintf("assignee = $%d", i))
-		args = append(args, *req.Assignee)
-		i++
-	}
 	if req.Status != nil {
 		sets = append(sets, fmt.Sprintf("status = $%d", i))
 		args = append(args, *req.Status)
 		i++
 	}
 	if req.Deadline != nil {
-		sets = append(sets, fmt.Sprintf("deadline = $%d", i))
+		// a new deadline gets its own reminder
+		sets = append(sets, fmt.Sprintf("deadline = $%d, deadline_reminded_at = NULL", i))
 		args = append(args, *req.Deadline)
 		i++
 	}
@@ -75,124 +367,324 @@ func UpdateTask(ctx context.Context, taskID int64, req UpdateTaskRequest) error
 		args = append(args, *req.Priority)
 		i++
 	}
+	if req.EstimateMinutes != nil {
+		sets = append(sets, fmt.Sprintf("estimate_minutes = NULLIF($%d, 0)", i))
+		args = append(args, *req.EstimateMinutes)
+		i++
+	}
+	if req.MilestoneID != nil {
+		sets = append(sets, fmt.Sprintf("milestoneid = NULLIF($%d, 0)", i))
+		args = append(args, *req.MilestoneID)
+		i++
+	}
 
-	if len(sets) == 0 {
-		return fmt.Errorf("no fields to update")
+	if len(sets) == 0 && req.LabelIDs == nil && req.Assignees == nil {
+		return 0, fmt.Errorf("no fields to update")
+	}
+
+	// lock the row so the recorded before values match what gets overwritten
+	before, err := getTask(ctx, tx, taskID, true)
+	if err != nil {
+		return 0, err
+	}
+	if version != 0 && before.Version != version {
+		return 0, errVersionMismatch
 	}
 
+	// a labels or assignees only update changes the task too
+	sets = append(sets, "version = version + 1")
 	args = append(args, taskID)
-	q := fmt.Sprintf("UPDATE tasks SET %s WHERE taskid = $%d", strings.Join(sets, ", "), i)
+	q := fmt.Sprintf("UPDATE tasks SET %s WHERE taskid = $%d RETURNING version", strings.Join(sets, ", "), i)
 
-	ct, err := pool.Exec(ctx, q, args...)
-	if err != nil {
-		return err
+	var next int
+	if err := tx.QueryRow(ctx, q, args...).Scan(&next); err != nil {
+		return 0, err
 	}
-	if ct.RowsAffected() == 0 {
-		return pgx.ErrNoRows
+
+	events := taskChanges(before, actor, req)
+	if req.Description != nil {
+		mentioned, err := syncMentions(ctx, tx, taskID, nil, actor, *req.Description)
+		if err != nil {
+			return 0, err
+		}
+		if err := notify(ctx, tx, notifyMention, taskID, actor, *req.Description, mentioned); err != nil {
+			return 0, err
+		}
+	}
+	if req.Status != nil && *req.Status != before.Status {
+		watchers, err := taskFollowers(ctx, tx, taskID, false)
+		if err != nil {
+			return 0, err
+		}
+		change := fmt.Sprintf("%s → %s", before.Status, *req.Status)
+		if err := notify(ctx, tx, notifyStatus, taskID, actor, change, watchers); err != nil {
+			return 0, err
+		}
+	}
+	if req.Assignees != nil {
+		after, err := setTaskAssignees(ctx, tx, taskID, *req.Assignees)
+		if err != nil {
+			return 0, err
+		}
+		added := slices.DeleteFunc(slices.Clone(after), func(u string) bool { return before.IsAssignee(u) })
+		if err := notify(ctx, tx, notifyAssigned, taskID, actor, "", added); err != nil {
+			return 0, err
+		}
+		if old, cur := strings.Join(before.Assignees, ", "), strings.Join(after, ", "); old != cur {
+			events = append(events, TaskEvent{
+				TaskID:   taskID,
+				TeamID:   before.TeamID,
+				Actor:    actor,
+				Action:   "updated",
+				Field:    "assignees",
+				OldValue: old,
+				NewValue: cur,
+			})
+		}
+	}
+	if req.LabelIDs != nil {
+		after, err := setTaskLabels(ctx, tx, taskID, before.TeamID, *req.LabelIDs)
+		if err != nil {
+			return 0, err
+		}
+		if old, cur := labelNames(before.Labels), labelNames(after); old != cur {
+			events = append(events, TaskEvent{
+				TaskID:   taskID,
+				TeamID:   before.TeamID,
+				Actor:    actor,
+				Action:   "updated",
+				Field:    "labels",
+				OldValue: old,
+				NewValue: cur,
+			})
+		}
 	}
-	return nil
-}
 
-type ListTasksFilter struct {
-	TeamID   int64
-	Assignee string
-	Status   string
-	Limit    int
-	Order    string
+	return next, insertTaskEvents(ctx, tx, events...)
 }
 
-func ListTasks(ctx context.Context, f ListTasksFilter) ([]Task, error) {
-	if f.TeamID <= 0 {
-		return nil, fmt.Errorf("teamid required")
+// setTaskAssignees replaces the assignees of a task with usernames and returns
+// them sorted, without duplicates.
+func setTaskAssignees(ctx context.Context, tx pgx.Tx, taskID int64, usernames []string) ([]string, error) {
+	if _, err := tx.Exec(ctx, `DELETE FROM task_assignees WHERE taskid = $1`, taskID); err != nil {
+		return nil, err
 	}
-	limit := normalizeLimit(f.Limit)
-	orderSQL := taskOrderClause(f.Order)
 
-	where := []string{"teamid = $1"}
-	args := []any{f.TeamID}
-	i := 2
+	rows, err := tx.Query(ctx, `
+		INSERT INTO task_assignees (taskid, username)
+		SELECT DISTINCT $1::bigint, btrim(u) FROM unnest($2::text[]) AS u
+		WHERE btrim(u) <> ''
+		RETURNING username
+	`, taskID, usernames)
+	if err != nil {
+		return nil, err
+	}
+	after, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(after)
+	return after, nil
+}
 
-	if strings.TrimSpace(f.Assignee) != "" {
-		where = append(where, fmt.Sprintf("assignee = $%d", i))
-		args = append(args, strings.TrimSpace(f.Assignee))
-		i++
+// SetTaskWatch adds username to the task's watchers, or removes them when
+// watch is false, and returns the watchers now set.
+func SetTaskWatch(ctx context.Context, taskID int64, username string, watch bool) ([]string, error) {
+	q := `INSERT INTO task_watchers (taskid, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	if !watch {
+		q = `DELETE FROM task_watchers WHERE taskid = $1 AND username = $2`
 	}
-	if strings.TrimSpace(f.Status) != "" {
-		where = append(where, fmt.Sprintf("status = $%d", i))
-		args = append(args, strings.TrimSpace(f.Status))
-		i++
+	if _, err := pool.Exec(ctx, q, taskID, username); err != nil {
+		return nil, err
 	}
 
-	q := fmt.Sprintf(`
-		SELECT taskid, teamid, title, COALESCE(description,''), author, COALESCE(assignee,''), status,
-		       deadline, priority, created_at
-		FROM tasks
-		WHERE %s
-		ORDER BY %s
-		LIMIT $%d
-	`, strings.Join(where, " AND "), orderSQL, i)
+	rows, err := pool.Query(ctx, `
+		SELECT username FROM task_watchers WHERE taskid = $1 ORDER BY username
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
 
-	args = append(args, limit)
+var errUnknownLabel = errors.New("unknown label for this team")
 
-	rows, err := pool.Query(ctx, q, args...)
+// setTaskLabels replaces the labels of a task with labelIDs, which must all be
+// labels of the task's team. It returns the labels now set.
+func setTaskLabels(ctx context.Context, tx pgx.Tx, taskID, teamID int64, labelIDs []int64) ([]Label, error) {
+	if _, err := tx.Exec(ctx, `DELETE FROM task_labels WHERE taskid = $1`, taskID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, `
+		INSERT INTO task_labels (taskid, labelid)
+		SELECT $1, l.labelid
+		FROM team_labels l
+		WHERE l.teamid = $2 AND l.labelid = ANY($3)
+		RETURNING labelid
+	`, taskID, teamID, labelIDs)
+	if err != nil {
+		return nil, err
+	}
+	inserted, err := pgx.CollectRows(rows, pgx.RowTo[int64])
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	out := make([]Task, 0, limit)
-	for rows.Next() {
-		var t Task
-		var deadline *time.Time
-		if err := rows.Scan(&t.TaskID, &t.TeamID, &t.Title, &t.Description, &t.Author, &t.Assignee,
-			&t.Status, &deadline, &t.Priority, &t.CreatedAt); err != nil {
-			return nil, err
+	want := make(map[int64]bool, len(labelIDs))
+	for _, id := range labelIDs {
+		want[id] = true
+	}
+	if len(inserted) != len(want) {
+		return nil, errUnknownLabel
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT l.labelid, l.name, l.color
+		FROM task_labels tl
+		JOIN team_labels l ON l.labelid = tl.labelid
+		WHERE tl.taskid = $1
+		ORDER BY lower(l.name)
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Label])
+}
+
+func labelNames(labels []Label) string {
+	names := make([]string, 0, len(labels))
+	for _, l := range labels {
+		names = append(names, l.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// labelCondition matches tasks carrying any (or, with match "all", every) of the
+// label names, compared ignoring case. The names are bound to $arg.
+func labelCondition(match string, arg int) string {
+	if match == "all" {
+		return fmt.Sprintf(`(
+			SELECT COUNT(DISTINCT lower(l.name))
+			FROM task_labels tl
+			JOIN team_labels l ON l.labelid = tl.labelid
+			WHERE tl.taskid = tasks.taskid AND lower(l.name) = ANY($%[1]d)
+		) = cardinality($%[1]d::text[])`, arg)
+	}
+	return fmt.Sprintf(`EXISTS(
+			SELECT 1
+			FROM task_labels tl
+			JOIN team_labels l ON l.labelid = tl.labelid
+			WHERE tl.taskid = tasks.taskid AND lower(l.name) = ANY($%d)
+		)`, arg)
+}
+
+// normalizeLabelNames lower-cases and de-duplicates label filter values.
+func normalizeLabelNames(names []string) []string {
+	out := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n == "" || seen[n] {
+			continue
 		}
-		if deadline != nil {
-			t.Deadline = *deadline
+		seen[n] = true
+		out = append(out, n)
+	}
+	return out
+}
+
+// taskChanges lists the fields req actually changes compared to before.
+func taskChanges(before *Task, actor string, req UpdateTaskRequest) []TaskEvent {
+	events := make([]TaskEvent, 0, 6)
+	add := func(field, oldValue, newValue string) {
+		if oldValue == newValue {
+			return
 		}
-		out = append(out, t)
+		events = append(events, TaskEvent{
+			TaskID:   before.TaskID,
+			TeamID:   before.TeamID,
+			Actor:    actor,
+			Action:   "updated",
+			Field:    field,
+			OldValue: oldValue,
+			NewValue: newValue,
+		})
 	}
-	return out, rows.Err()
+
+	if req.Title != nil {
+		add("title", before.Title, *req.Title)
+	}
+	if req.Description != nil {
+		add("description", before.Description, *req.Description)
+	}
+	if req.Status != nil {
+		add("status", before.Status, *req.Status)
+	}
+	if req.Deadline != nil {
+		add("deadline", formatEventTime(before.Deadline), formatEventTime(*req.Deadline))
+	}
+	if req.Priority != nil {
+		add("priority", before.Priority, *req.Priority)
+	}
+	if req.EstimateMinutes != nil {
+		add("estimate", formatMinutes(before.EstimateMinutes), formatMinutes(req.EstimateMinutes))
+	}
+	if req.MilestoneID != nil {
+		add("milestone", formatMilestone(before.MilestoneID), formatMilestone(req.MilestoneID))
+	}
+	return events
 }
 
-func CreateComment(ctx context.Context, taskID int64, author, body string) (int64, error) {
-	body = strings.TrimSpace(body)
-	if body == "" {
-		return 0, fmt.Errorf("empty body")
+// formatMilestone renders a milestone for the history as "#id", none for nil or 0.
+// The burndown reads these values back to tell when a task joined or left.
+func formatMilestone(id *int64) string {
+	if id == nil || *id == 0 {
+		return ""
 	}
+	return "#" + strconv.FormatInt(*id, 10)
+}
 
-	var id int64
-	err := pool.QueryRow(ctx, `
-		INSERT INTO task_comments (taskid, author, body)
-		VALUES ($1, $2, $3)
-		RETURNING commentid
-	`, taskID, author, body).Scan(&id)
-	return id, err
+// formatMinutes renders an estimate for the history, none for nil or 0.
+func formatMinutes(m *int) string {
+	if m == nil || *m == 0 {
+		return ""
+	}
+	return strconv.Itoa(*m) + "m"
 }
 
-func DeleteComment(ctx context.Context, commentID int64) error {
-	ct, err := pool.Exec(ctx, `DELETE FROM task_comments WHERE commentid = $1`, commentID)
-	if err != nil {
-		return err
+func formatEventTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
 	}
-	if ct.RowsAffected() == 0 {
-		return pgx.ErrNoRows
+	return t.UTC().Format(time.RFC3339)
+}
+
+func insertTaskEvents(ctx context.Context, q querier, events ...TaskEvent) error {
+	for _, ev := range events {
+		_, err := q.Exec(ctx, `
+			INSERT INTO task_events (taskid, teamid, actor, action, field, old_value, new_value)
+			VALUES ($1, $2, $3, $4, NULLIF($5,''), NULLIF($6,''), NULLIF($7,''))
+		`, ev.TaskID, ev.TeamID, ev.Actor, ev.Action, ev.Field, ev.OldValue, ev.NewValue)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func ListComments(ctx context.Context, taskID int64, limit int) ([]Comment, error) {
-	if taskID <= 0 {
-		return nil, fmt.Errorf("taskid required")
+// ListTaskEvents returns the history of a task, oldest first.
+func ListTaskEvents(ctx context.Context, taskID int64, limit int) ([]TaskEvent, error) {
+	if limit <= 0 {
+		limit = 200
 	}
-	limit = normalizeLimit(limit)
 
 	rows, err := pool.Query(ctx, `
-		SELECT commentid, taskid, author, body, created_at
-		FROM task_comments
+		SELECT eventid, taskid, COALESCE(teamid, 0), actor, action,
+		       COALESCE(field,''), COALESCE(old_value,''), COALESCE(new_value,''), created_at
+		FROM task_events
 		WHERE taskid = $1
-		ORDER BY created_at ASC
+		ORDER BY created_at ASC, eventid ASC
 		LIMIT $2
 	`, taskID, limit)
 	if err != nil {
@@ -200,64 +692,640 @@ func ListComments(ctx context.Context, taskID int64, limit int) ([]Comment, erro
 	}
 	defer rows.Close()
 
-	out := make([]Comment, 0, limit)
+	out := make([]TaskEvent, 0, 16)
 	for rows.Next() {
-		var cmt Comment
-		if err := rows.Scan(&cmt.CommentID, &cmt.TaskID, &cmt.Author, &cmt.Body, &cmt.CreatedAt); err != nil {
+		var ev TaskEvent
+		if err := rows.Scan(&ev.EventID, &ev.TaskID, &ev.TeamID, &ev.Actor, &ev.Action,
+			&ev.Field, &ev.OldValue, &ev.NewValue, &ev.CreatedAt); err != nil {
 			return nil, err
 		}
-		out = append(out, cmt)
+		out = append(out, ev)
 	}
 	return out, rows.Err()
 }
 
-func GetTaskByID(ctx context.Context, taskID int64) (*Task, error) {
-	row := pool.QueryRow(ctx, `
-		SELECT taskid, teamid, COALESCE(title,''), COALESCE(description,''),
-		       COALESCE(author,''), COALESCE(assignee,''), COALESCE(status,''),
-		       deadline, COALESCE(priority,''), created_at
-		FROM tasks
-		WHERE taskid = $1
-	`, taskID)
-
-	var t Task
-	var deadline sql.NullTime
+type ListTasksFilter struct {
+	TeamID int64
+	// tasks assigned to any of them
+	Assignees  []string
+	Status     string
+	Labels     []string
+	LabelMatch string // any (default) or all
+	// 0 for any milestone
+	MilestoneID int64
+	Cursor      string
+	Limit       int
+	Order       string
+}
 
-	if err := row.Scan(
-		&t.TaskID,
-		&t.TeamID,
-		&t.Title,
-		&t.Description,
-		&t.Author,
-		&t.Assignee,
-		&t.Status,
-		&deadline,
-		&t.Priority,
-		&t.CreatedAt,
-	); err != nil {
-		return nil, err
+// ListTasks returns one page of a team's tasks.
+// The second return value is the cursor of the next page, empty on the last one.
+func ListTasks(ctx context.Context, f ListTasksFilter) ([]Task, string, error) {
+	if f.TeamID <= 0 {
+		return nil, "", fmt.Errorf("teamid required")
 	}
+	limit := normalizeLimit(f.Limit)
+	ks := taskKeysetFor(f.Order)
 
-	if deadline.Valid {
-		t.Deadline = deadline.Time
-	}
+	where := []string{"teamid = $1", liveTask}
+	args := []any{f.TeamID}
+	i := 2
 
-	return &t, nil
+	if len(f.Assignees) > 0 {
+		where = append(where, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM task_assignees a WHERE a.taskid = tasks.taskid AND a.username = ANY($%d))", i))
+		args = append(args, f.Assignees)
+		i++
+	}
+	if strings.TrimSpace(f.Status) != "" {
+		where = append(where, fmt.Sprintf("status = $%d", i))
+		args = append(args, strings.TrimSpace(f.Status))
+		i++
+	}
+	if labels := normalizeLabelNames(f.Labels); len(labels) > 0 {
+		where = append(where, labelCondition(f.LabelMatch, i))
+		args = append(args, labels)
+		i++
+	}
+	if f.MilestoneID > 0 {
+		where = append(where, fmt.Sprintf("milestoneid = $%d", i))
+		args = append(args, f.MilestoneID)
+		i++
+	}
+	if f.Cursor != "" {
+		cur, err := utils.DecodeCursor(f.Cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errBadCursor, err)
+		}
+		if !ks.validKey(cur.Key) {
+			return nil, "", fmt.Errorf("%w: bad key %q", errBadCursor, cur.Key)
+		}
+		where = append(where, ks.after(i, i+1))
+		args = append(args, cur.Key, cur.ID)
+		i += 2
+	}
+
+	q := fmt.Sprintf(`
+		SELECT `+taskColumns+`
+		FROM tasks
+		WHERE %s
+		ORDER BY %s
+		LIMIT $%d
+	`, strings.Join(where, " AND "), ks.orderBy(), i)
+
+	// one extra row tells whether there is a next page
+	args = append(args, limit+1)
+
+	rows, err := pool.Query(ctx, q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	out, err := scanTasks(rows, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(out) > limit {
+		out = out[:limit]
+		next = ks.cursorFor(out[limit-1])
+	}
+	return out, next, nil
 }
 
-func ListCommentsByTaskID(ctx context.Context, taskID int64, limit int, order string) ([]Comment, error) {
-	orderSQL := "created_at ASC"
-	if order == "created_desc" {
-		orderSQL = "created_at DESC"
+// liveTaskOf is the condition keeping the tasks under alias that are not in the
+// trash, themselves or through their team (teams is owned by mteam).
+func liveTaskOf(alias string) string {
+	return fmt.Sprintf(`%[1]s.deleted_at IS NULL AND NOT EXISTS (
+		SELECT 1 FROM teams trashed WHERE trashed.teamid = %[1]s.teamid AND trashed.deleted_at IS NOT NULL)`, alias)
+}
+
+// liveTask is liveTaskOf for queries selecting FROM tasks without an alias.
+var liveTask = liveTaskOf("tasks")
+
+// taskColumns is the select list shared by every task query, read back by scanTask.
+// It must be selected FROM tasks without an alias.
+const taskColumns = `taskid, teamid, COALESCE(title,''), COALESCE(description,''),
+		       COALESCE(author,''), COALESCE(status,''),
+		       deadline, COALESCE(priority,''), created_at, version, parent_taskid, recurrence_of, estimate_minutes, milestoneid,
+		       ARRAY(SELECT a.username FROM task_assignees a WHERE a.taskid = tasks.taskid ORDER BY a.username),
+		       ARRAY(SELECT w.username FROM task_watchers w WHERE w.taskid = tasks.taskid ORDER BY w.username),
+		       COALESCE((
+		         SELECT json_agg(json_build_object('labelid', l.labelid, 'name', l.name, 'color', l.color)
+		                         ORDER BY lower(l.name))
+		         FROM task_labels tl
+		         JOIN team_labels l ON l.labelid = tl.labelid
+		         WHERE tl.taskid = tasks.taskid
+		       ), '[]'::json)`
+
+func scanTask(row pgx.Row) (Task, error) {
+	var t Task
+	var deadline *time.Time
+	if err := row.Scan(&t.TaskID, &t.TeamID, &t.Title, &t.Description, &t.Author,
+		&t.Status, &deadline, &t.Priority, &t.CreatedAt, &t.Version, &t.ParentTaskID, &t.RecurrenceOf, &t.EstimateMinutes, &t.MilestoneID,
+		&t.Assignees, &t.Watchers, &t.Labels); err != nil {
+		return t, err
+	}
+	if deadline != nil {
+		t.Deadline = *deadline
 	}
+	return t, nil
+}
 
-	rows, err := pool.Query(ctx, fmt.Sprintf(`
-		SELECT commentid, taskid, COALESCE(author,''), body, created_at
+func scanTasks(rows pgx.Rows, capacity int) ([]Task, error) {
+	out := make([]Task, 0, capacity)
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+var errBadCursor = errors.New("bad cursor")
+
+type MyTasksFilter struct {
+	Username   string
+	Status     string
+	Priority   string
+	DueFrom    *time.Time
+	DueBefore  *time.Time
+	Labels     []string
+	LabelMatch string // any (default) or all
+	Cursor     string
+	Limit      int
+	Order      string
+}
+
+// ListTasksForUser returns the tasks assigned to the user across every team they belong to.
+// The second return value is the cursor of the next page, empty on the last one.
+func ListTasksForUser(ctx context.Context, f MyTasksFilter) ([]Task, string, error) {
+	if strings.TrimSpace(f.Username) == "" {
+		return nil, "", fmt.Errorf("username required")
+	}
+	limit := normalizeLimit(f.Limit)
+	ks := taskKeysetFor(f.Order)
+
+	where := []string{
+		"EXISTS (SELECT 1 FROM task_assignees a WHERE a.taskid = tasks.taskid AND a.username = $1)",
+		"teamid IN (SELECT teamid FROM team_members WHERE username = $1)",
+		liveTask,
+	}
+	args := []any{f.Username}
+	i := 2
+
+	if f.Status != "" {
+		where = append(where, fmt.Sprintf("status = $%d", i))
+		args = append(args, f.Status)
+		i++
+	}
+	if f.Priority != "" {
+		where = append(where, fmt.Sprintf("priority = $%d", i))
+		args = append(args, f.Priority)
+		i++
+	}
+	if f.DueFrom != nil {
+		where = append(where, fmt.Sprintf("deadline >= $%d", i))
+		args = append(args, *f.DueFrom)
+		i++
+	}
+	if f.DueBefore != nil {
+		where = append(where, fmt.Sprintf("deadline < $%d", i))
+		args = append(args, *f.DueBefore)
+		i++
+	}
+	if labels := normalizeLabelNames(f.Labels); len(labels) > 0 {
+		where = append(where, labelCondition(f.LabelMatch, i))
+		args = append(args, labels)
+		i++
+	}
+	if f.Cursor != "" {
+		cur, err := utils.DecodeCursor(f.Cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errBadCursor, err)
+		}
+		if !ks.validKey(cur.Key) {
+			return nil, "", fmt.Errorf("%w: bad key %q", errBadCursor, cur.Key)
+		}
+		where = append(where, ks.after(i, i+1))
+		args = append(args, cur.Key, cur.ID)
+		i += 2
+	}
+
+	q := fmt.Sprintf(`
+		SELECT `+taskColumns+`
+		FROM tasks
+		WHERE %s
+		ORDER BY %s
+		LIMIT $%d
+	`, strings.Join(where, " AND "), ks.orderBy(), i)
+
+	// one extra row tells whether there is a next page
+	args = append(args, limit+1)
+
+	rows, err := pool.Query(ctx, q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	out, err := scanTasks(rows, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(out) > limit {
+		out = out[:limit]
+		next = ks.cursorFor(out[limit-1])
+	}
+	return out, next, nil
+}
+
+// CreateComment stores a comment, parentID is nil for a top level comment.
+func CreateComment(ctx context.Context, taskID int64, parentID *int64, author, body string) (int64, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return 0, fmt.Errorf("empty body")
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO task_comments (taskid, parent_commentid, author, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING commentid
+	`, taskID, parentID, author, body).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	mentioned, err := syncMentions(ctx, tx, taskID, &id, author, body)
+	if err != nil {
+		return 0, err
+	}
+	if err := notify(ctx, tx, notifyMention, taskID, author, body, mentioned); err != nil {
+		return 0, err
+	}
+
+	// the mentioned users are told once, by the mention
+	followers, err := taskFollowers(ctx, tx, taskID, true)
+	if err != nil {
+		return 0, err
+	}
+	followers = slices.DeleteFunc(followers, func(u string) bool { return slices.Contains(mentioned, u) })
+	if err := notify(ctx, tx, notifyComment, taskID, author, body, followers); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit(ctx)
+}
+
+// DeleteComment removes the comment. One with replies is kept as a tombstone,
+// its body and mentions gone, so that the replies of others stay in their
+// thread; tombstones left without replies are removed with the last one.
+func DeleteComment(ctx context.Context, commentID int64) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ct, err := tx.Exec(ctx, `
+		UPDATE task_comments SET body = '', deleted_at = now()
+		WHERE commentid = $1 AND deleted_at IS NULL
+		  AND EXISTS (SELECT 1 FROM task_comments r WHERE r.parent_commentid = $1)
+	`, commentID)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() > 0 {
+		if _, err := tx.Exec(ctx, `DELETE FROM task_mentions WHERE commentid = $1`, commentID); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
+	var parentID *int64
+	err = tx.QueryRow(ctx, `
+		DELETE FROM task_comments
+		WHERE commentid = $1 AND deleted_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM task_comments r WHERE r.parent_commentid = $1)
+		RETURNING parent_commentid
+	`, commentID).Scan(&parentID)
+	if err != nil {
+		return err
+	}
+	for parentID != nil {
+		err = tx.QueryRow(ctx, `
+			DELETE FROM task_comments
+			WHERE commentid = $1 AND deleted_at IS NOT NULL
+			  AND NOT EXISTS (SELECT 1 FROM task_comments r WHERE r.parent_commentid = $1)
+			RETURNING parent_commentid
+		`, *parentID).Scan(&parentID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func GetCommentByID(ctx context.Context, commentID int64) (*Comment, error) {
+	var cmt Comment
+	err := pool.QueryRow(ctx, `
+		SELECT commentid, taskid, parent_commentid, COALESCE(author,''), body, created_at, edited_at, deleted_at
+		FROM task_comments
+		WHERE commentid = $1
+	`, commentID).Scan(&cmt.CommentID, &cmt.TaskID, &cmt.ParentCommentID, &cmt.Author, &cmt.Body,
+		&cmt.CreatedAt, &cmt.EditedAt, &cmt.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &cmt, nil
+}
+
+func UpdateComment(ctx context.Context, commentID int64, body string) error {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return fmt.Errorf("empty body")
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// the mentions stay the comment author's when a moderator edits it
+	var (
+		taskID int64
+		author string
+	)
+	err = tx.QueryRow(ctx, `
+		UPDATE task_comments SET body = $1, edited_at = now()
+		WHERE commentid = $2 AND deleted_at IS NULL
+		RETURNING taskid, COALESCE(author, '')
+	`, body, commentID).Scan(&taskID, &author)
+	if err != nil {
+		return err
+	}
+	mentioned, err := syncMentions(ctx, tx, taskID, &commentID, author, body)
+	if err != nil {
+		return err
+	}
+	if err := notify(ctx, tx, notifyMention, taskID, author, body, mentioned); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// syncMentions records the team members mentioned in text, the task's
+// description when commentID is nil or else that comment, and drops the
+// mentions the text no longer has. It returns the users newly mentioned.
+func syncMentions(ctx context.Context, q querier, taskID int64, commentID *int64, author, text string) ([]string, error) {
+	names := parseMentions(text)
+	_, err := q.Exec(ctx, `
+		DELETE FROM task_mentions
+		WHERE taskid = $1 AND commentid IS NOT DISTINCT FROM $2 AND username <> ALL($3::text[])
+	`, taskID, commentID, names)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+
+	rows, err := q.Query(ctx, `
+		INSERT INTO task_mentions (taskid, commentid, username, author)
+		SELECT t.taskid, $2::bigint, m.username, $4::text
+		FROM tasks t
+		JOIN team_members m ON m.teamid = t.teamid
+		WHERE t.taskid = $1 AND lower(m.username) = ANY($3::text[]) AND m.username <> $4
+		ON CONFLICT DO NOTHING
+		RETURNING username
+	`, taskID, commentID, names, author)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// ListMentions returns the mentions of username on live tasks of the teams
+// they are still a member of, latest first.
+func ListMentions(ctx context.Context, username string, limit int) ([]Mention, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT mn.mentionid, t.taskid, t.teamid, COALESCE(t.title, ''), mn.commentid, mn.username, mn.author,
+		       left(COALESCE(c.body, t.description, ''), 280), mn.created_at
+		FROM task_mentions mn
+		JOIN tasks t ON t.taskid = mn.taskid
+		LEFT JOIN task_comments c ON c.commentid = mn.commentid
+		WHERE mn.username = $1 AND `+liveTaskOf("t")+`
+		  AND EXISTS (SELECT 1 FROM team_members m WHERE m.teamid = t.teamid AND m.username = $1)
+		ORDER BY mn.created_at DESC, mn.mentionid DESC
+		LIMIT $2
+	`, username, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Mention])
+}
+
+// notify records a notification of kind about the task for every one of
+// usernames but actor, detail is cut to its first 200 characters. The kinds
+// that are emailed are queued for it.
+func notify(ctx context.Context, q querier, kind string, taskID int64, actor, detail string, usernames []string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+	_, err := q.Exec(ctx, `
+		INSERT INTO notifications (username, kind, taskid, actor, detail, email_pending)
+		SELECT DISTINCT u, $1::text, $2::bigint, $3::text, left($4::text, 200), $6::boolean
+		FROM unnest($5::text[]) AS u
+		WHERE u <> $3
+	`, kind, taskID, actor, strings.TrimSpace(detail), usernames, emailKind(kind))
+	return err
+}
+
+// taskFollowers returns the task's watchers, and with assignees its assignees too.
+func taskFollowers(ctx context.Context, q querier, taskID int64, assignees bool) ([]string, error) {
+	rows, err := q.Query(ctx, `
+		SELECT username FROM task_watchers WHERE taskid = $1
+		UNION
+		SELECT username FROM task_assignees WHERE taskid = $1 AND $2
+	`, taskID, assignees)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// ListNotifications returns the notifications of username about live tasks,
+// latest first, only the unread ones with unread, and how many are unread.
+func ListNotifications(ctx context.Context, username string, unread bool, limit int) ([]Notification, int, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT n.notificationid, n.username, n.kind, t.taskid, t.teamid, COALESCE(t.title, ''),
+		       n.actor, n.detail, n.created_at, n.read_at
+		FROM notifications n
+		JOIN tasks t ON t.taskid = n.taskid
+		WHERE n.username = $1 AND (NOT $2 OR n.read_at IS NULL) AND `+liveTaskOf("t")+`
+		ORDER BY n.created_at DESC, n.notificationid DESC
+		LIMIT $3
+	`, username, unread, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	items, err := pgx.CollectRows(rows, pgx.RowToStructByPos[Notification])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n, err := CountUnreadNotifications(ctx, username)
+	return items, n, err
+}
+
+func CountUnreadNotifications(ctx context.Context, username string) (int, error) {
+	var n int
+	err := pool.QueryRow(ctx, `
+		SELECT count(*)
+		FROM notifications n
+		JOIN tasks t ON t.taskid = n.taskid
+		WHERE n.username = $1 AND n.read_at IS NULL AND `+liveTaskOf("t")+`
+	`, username).Scan(&n)
+	return n, err
+}
+
+// RemindDeadlines notifies the assignees of the live tasks due within the
+// next hours and not in their team's terminal status, once per deadline. It
+// returns how many tasks were due.
+func RemindDeadlines(ctx context.Context, within time.Duration) (int64, error) {
+	ct, err := pool.Exec(ctx, `
+		WITH due AS (
+			UPDATE tasks SET deadline_reminded_at = now()
+			WHERE deadline > now() AND deadline <= now() + $1::interval
+			  AND deadline_reminded_at IS NULL AND `+liveTask+`
+			  AND status IS DISTINCT FROM COALESCE(
+			      (SELECT s.name FROM team_statuses s WHERE s.teamid = tasks.teamid AND s.terminal), $2)
+			RETURNING taskid, deadline
+		), reminded AS (
+			INSERT INTO notifications (username, kind, taskid, actor, detail, email_pending)
+			SELECT a.username, $3::text, due.taskid, '', to_char(due.deadline AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI "UTC"'), $4::boolean
+			FROM due JOIN task_assignees a ON a.taskid = due.taskid
+		)
+		SELECT FROM due
+	`, within, defaultWorkflow.Terminal(), notifyDeadline, emailKind(notifyDeadline))
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
+
+// ClaimPendingEmails takes up to limit queued notification emails that are due,
+// oldest first, and leases them: they come due again after lease unless
+// finished or retried before. Those of tasks gone to the trash wait for them.
+func ClaimPendingEmails(ctx context.Context, limit int, lease time.Duration) ([]PendingEmail, error) {
+	rows, err := pool.Query(ctx, `
+		UPDATE notifications n
+		SET email_attempts = n.email_attempts + 1, email_next_at = now() + $2::interval
+		FROM tasks t
+		WHERE t.taskid = n.taskid AND n.notificationid IN (
+			SELECT q.notificationid
+			FROM notifications q
+			JOIN tasks qt ON qt.taskid = q.taskid
+			WHERE q.email_pending AND q.email_next_at <= now() AND `+liveTaskOf("qt")+`
+			ORDER BY q.email_next_at
+			LIMIT $1
+			FOR UPDATE OF q SKIP LOCKED
+		)
+		RETURNING n.notificationid, n.username, n.kind, t.taskid, t.teamid, COALESCE(t.title, ''),
+		          n.actor, n.detail, n.created_at, n.read_at, n.email_attempts
+	`, limit, lease)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[PendingEmail])
+}
+
+// FinishEmail takes the notification's email off the queue, sent or not.
+func FinishEmail(ctx context.Context, notificationID int64, sent bool) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE notifications
+		SET email_pending = false, emailed_at = CASE WHEN $2 THEN now() END
+		WHERE notificationid = $1
+	`, notificationID, sent)
+	return err
+}
+
+// RetryEmail makes the notification's email due again at.
+func RetryEmail(ctx context.Context, notificationID int64, at time.Time) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE notifications SET email_next_at = $2 WHERE notificationid = $1
+	`, notificationID, at)
+	return err
+}
+
+func GetEmailPreferences(ctx context.Context, username string) (EmailPreferences, error) {
+	p := EmailPreferences{Assigned: true, Deadline: true, Mention: true}
+	err := pool.QueryRow(ctx, `
+		SELECT assigned, deadline, mention FROM email_preferences WHERE username = $1
+	`, username).Scan(&p.Assigned, &p.Deadline, &p.Mention)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+	}
+	return p, err
+}
+
+// SetEmailPreferences changes the fields req sets and returns the preferences.
+func SetEmailPreferences(ctx context.Context, username string, req EmailPreferencesRequest) (EmailPreferences, error) {
+	var p EmailPreferences
+	err := pool.QueryRow(ctx, `
+		INSERT INTO email_preferences (username, assigned, deadline, mention)
+		VALUES ($1, COALESCE($2, true), COALESCE($3, true), COALESCE($4, true))
+		ON CONFLICT (username) DO UPDATE SET
+			assigned = COALESCE($2, email_preferences.assigned),
+			deadline = COALESCE($3, email_preferences.deadline),
+			mention = COALESCE($4, email_preferences.mention),
+			updated_at = now()
+		RETURNING assigned, deadline, mention
+	`, username, req.Assigned, req.Deadline, req.Mention).Scan(&p.Assigned, &p.Deadline, &p.Mention)
+	return p, err
+}
+
+// MarkNotificationsRead marks the notifications of username read, those of
+// notificationIDs or all of them when it is empty. It returns how many were unread.
+func MarkNotificationsRead(ctx context.Context, username string, notificationIDs []int64) (int64, error) {
+	ct, err := pool.Exec(ctx, `
+		UPDATE notifications SET read_at = now()
+		WHERE username = $1 AND read_at IS NULL AND (COALESCE(cardinality($2::bigint[]), 0) = 0 OR notificationid = ANY($2))
+	`, username, notificationIDs)
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
+
+func ListComments(ctx context.Context, taskID int64, limit int) ([]Comment, error) {
+	if taskID <= 0 {
+		return nil, fmt.Errorf("taskid required")
+	}
+	limit = normalizeLimit(limit)
+
+	rows, err := pool.Query(ctx, `
+		SELECT commentid, taskid, parent_commentid, author, body, created_at, edited_at, deleted_at
 		FROM task_comments
 		WHERE taskid = $1
-		ORDER BY %s
+		ORDER BY created_at ASC
 		LIMIT $2
-	`, orderSQL), taskID, limit)
+	`, taskID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -266,10 +1334,1359 @@ func ListCommentsByTaskID(ctx context.Context, taskID int64, limit int, order st
 	out := make([]Comment, 0, limit)
 	for rows.Next() {
 		var cmt Comment
-		if err := rows.Scan(&cmt.CommentID, &cmt.TaskID, &cmt.Author, &cmt.Body, &cmt.CreatedAt); err != nil {
+		if err := rows.Scan(&cmt.CommentID, &cmt.TaskID, &cmt.ParentCommentID, &cmt.Author, &cmt.Body,
+			&cmt.CreatedAt, &cmt.EditedAt, &cmt.DeletedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, cmt)
 	}
 	return out, rows.Err()
 }
+
+// GetTaskByID returns the task with its subtasks, checklist and rollup progress.
+func GetTaskByID(ctx context.Context, taskID int64) (*Task, error) {
+	t, err := getTask(ctx, pool, taskID, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := LoadTaskDetails(ctx, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// LoadTaskDetails fills in what GetTaskByID returns beyond the task's row: its
+// subtasks, checklist, dependencies, progress, recurrence and logged time.
+func LoadTaskDetails(ctx context.Context, t *Task) error {
+	var err error
+	if t.Subtasks, err = ListSubtasks(ctx, t.TaskID); err != nil {
+		return err
+	}
+	if t.Checklist, err = ListChecklistItems(ctx, t.TaskID); err != nil {
+		return err
+	}
+	if t.BlockedBy, err = ListBlockers(ctx, t.TaskID); err != nil {
+		return err
+	}
+	if t.Blocks, err = ListDependents(ctx, t.TaskID); err != nil {
+		return err
+	}
+	wf, err := TeamWorkflow(ctx, t.TeamID)
+	if err != nil {
+		return err
+	}
+	t.Progress = rollupProgress(t.Subtasks, t.Checklist, wf)
+
+	rec, err := GetRecurrence(ctx, t.TaskID)
+	switch {
+	case err == nil:
+		t.Recurrence = rec
+	case !errors.Is(err, pgx.ErrNoRows):
+		return err
+	}
+
+	return pool.QueryRow(ctx, `
+		SELECT COALESCE(sum(minutes), 0) FROM time_entries WHERE taskid = $1 AND ended_at IS NOT NULL
+	`, t.TaskID).Scan(&t.LoggedMinutes)
+}
+
+// getTask loads only the task row through q, forUpdate locks the row until the transaction ends.
+func getTask(ctx context.Context, q querier, taskID int64, forUpdate bool) (*Task, error) {
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE"
+	}
+
+	row := q.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s
+		FROM tasks
+		WHERE taskid = $1 AND %s
+		%s
+	`, taskColumns, liveTask, lock), taskID)
+
+	t, err := scanTask(row)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func ListSubtasks(ctx context.Context, parentID int64) ([]Task, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+taskColumns+`
+		FROM tasks
+		WHERE parent_taskid = $1 AND `+liveTask+`
+		ORDER BY created_at ASC, taskid ASC
+	`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTasks(rows, 8)
+}
+
+// CountOpenSubtasks counts the children of a task that are not in the terminal status.
+func CountOpenSubtasks(ctx context.Context, parentID int64, terminal string) (int, error) {
+	var n int
+	err := pool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM tasks
+		WHERE parent_taskid = $1 AND COALESCE(status,'') <> $2 AND `+liveTask+`
+	`, parentID, terminal).Scan(&n)
+	return n, err
+}
+
+func rollupProgress(subtasks []Task, checklist []ChecklistItem, wf Workflow) *TaskProgress {
+	p := &TaskProgress{
+		SubtasksTotal:  len(subtasks),
+		ChecklistTotal: len(checklist),
+	}
+	terminal := wf.Terminal()
+	for _, st := range subtasks {
+		if st.Status == terminal {
+			p.SubtasksDone++
+		}
+	}
+	for _, it := range checklist {
+		if it.Done {
+			p.ChecklistDone++
+		}
+	}
+	if total := p.SubtasksTotal + p.ChecklistTotal; total > 0 {
+		p.Percent = (p.SubtasksDone + p.ChecklistDone) * 100 / total
+	}
+	return p
+}
+
+var (
+	errDependencyCycle  = errors.New("dependency would create a cycle")
+	errDependencyExists = errors.New("dependency already exists")
+)
+
+// AddDependency records that blocker blocks blocked, on both tasks' history.
+// Links closing a cycle are rejected with errDependencyCycle.
+func AddDependency(ctx context.Context, blocker, blocked *Task, actor string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// serialize links so two concurrent ones cannot close a cycle together
+	if _, err := tx.Exec(ctx, `LOCK TABLE task_dependencies IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	// a cycle appears if blocker is already reachable from blocked
+	var cycle bool
+	err = tx.QueryRow(ctx, `
+		WITH RECURSIVE reach(taskid) AS (
+			SELECT blocked_taskid FROM task_dependencies WHERE blocker_taskid = $1
+			UNION
+			SELECT d.blocked_taskid
+			FROM task_dependencies d
+			JOIN reach r ON d.blocker_taskid = r.taskid
+		)
+		SELECT EXISTS(SELECT 1 FROM reach WHERE taskid = $2)
+	`, blocked.TaskID, blocker.TaskID).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return errDependencyCycle
+	}
+
+	ct, err := tx.Exec(ctx, `
+		INSERT INTO task_dependencies (blocker_taskid, blocked_taskid, created_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, blocker.TaskID, blocked.TaskID, actor)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return errDependencyExists
+	}
+
+	err = insertTaskEvents(ctx, tx, dependencyEvents(blocker, blocked, actor, false)...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func RemoveDependency(ctx context.Context, blocker, blocked *Task, actor string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ct, err := tx.Exec(ctx, `
+		DELETE FROM task_dependencies
+		WHERE blocker_taskid = $1 AND blocked_taskid = $2
+	`, blocker.TaskID, blocked.TaskID)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	err = insertTaskEvents(ctx, tx, dependencyEvents(blocker, blocked, actor, true)...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func dependencyEvents(blocker, blocked *Task, actor string, removed bool) []TaskEvent {
+	ev := func(t *Task, field string, other *Task) TaskEvent {
+		e := TaskEvent{TaskID: t.TaskID, TeamID: t.TeamID, Actor: actor, Action: "updated", Field: field}
+		ref := fmt.Sprintf("#%d %s", other.TaskID, other.Title)
+		if removed {
+			e.OldValue = ref
+		} else {
+			e.NewValue = ref
+		}
+		return e
+	}
+	return []TaskEvent{
+		ev(blocked, "blocked_by", blocker),
+		ev(blocker, "blocks", blocked),
+	}
+}
+
+// ListBlockers returns the tasks blocking taskID.
+func ListBlockers(ctx context.Context, taskID int64) ([]Task, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+taskColumns+`
+		FROM tasks
+		WHERE taskid IN (SELECT blocker_taskid FROM task_dependencies WHERE blocked_taskid = $1)
+		  AND `+liveTask+`
+		ORDER BY taskid ASC
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTasks(rows, 4)
+}
+
+// ListDependents returns the tasks blocked by taskID.
+func ListDependents(ctx context.Context, taskID int64) ([]Task, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+taskColumns+`
+		FROM tasks
+		WHERE taskid IN (SELECT blocked_taskid FROM task_dependencies WHERE blocker_taskid = $1)
+		  AND `+liveTask+`
+		ORDER BY taskid ASC
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTasks(rows, 4)
+}
+
+func ListChecklistItems(ctx context.Context, taskID int64) ([]ChecklistItem, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT itemid, taskid, body, done, position, created_at
+		FROM task_checklist_items
+		WHERE taskid = $1
+		ORDER BY position ASC, itemid ASC
+	`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ChecklistItem, 0, 8)
+	for rows.Next() {
+		var it ChecklistItem
+		if err := rows.Scan(&it.ItemID, &it.TaskID, &it.Body, &it.Done, &it.Position, &it.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+func GetChecklistItem(ctx context.Context, itemID int64) (*ChecklistItem, error) {
+	var it ChecklistItem
+	err := pool.QueryRow(ctx, `
+		SELECT itemid, taskid, body, done, position, created_at
+		FROM task_checklist_items
+		WHERE itemid = $1
+	`, itemID).Scan(&it.ItemID, &it.TaskID, &it.Body, &it.Done, &it.Position, &it.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &it, nil
+}
+
+// CreateChecklistItem appends an item at the end of the task's checklist.
+func CreateChecklistItem(ctx context.Context, taskID int64, body string) (int64, error) {
+	var id int64
+	err := pool.QueryRow(ctx, `
+		INSERT INTO task_checklist_items (taskid, body, position)
+		VALUES ($1, $2, (SELECT COALESCE(MAX(position), -1) + 1 FROM task_checklist_items WHERE taskid = $1))
+		RETURNING itemid
+	`, taskID, body).Scan(&id)
+	return id, err
+}
+
+func UpdateChecklistItem(ctx context.Context, itemID int64, req UpdateChecklistItemRequest) error {
+	sets := make([]string, 0, 2)
+	args := make([]any, 0, 3)
+	i := 1
+
+	if req.Body != nil {
+		sets = append(sets, fmt.Sprintf("body = $%d", i))
+		args = append(args, strings.TrimSpace(*req.Body))
+		i++
+	}
+	if req.Done != nil {
+		sets = append(sets, fmt.Sprintf("done = $%d", i))
+		args = append(args, *req.Done)
+		i++
+	}
+
+	if len(sets) == 0 {
+		return fmt.Errorf("no fields to update")
+	}
+
+	args = append(args, itemID)
+	q := fmt.Sprintf("UPDATE task_checklist_items SET %s WHERE itemid = $%d", strings.Join(sets, ", "), i)
+
+	ct, err := pool.Exec(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func DeleteChecklistItem(ctx context.Context, itemID int64) error {
+	ct, err := pool.Exec(ctx, `DELETE FROM task_checklist_items WHERE itemid = $1`, itemID)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ListCommentsByTaskID returns one page of the comments of a task as a flattened
+// thread: every reply follows its parent and carries its nesting depth.
+// Pages are cut between threads, limit counts top level comments and each one
+// comes with all of its replies. order applies to the top level comments,
+// replies are always listed oldest first. The second return value is the
+// cursor of the next page, empty on the last one.
+func ListCommentsByTaskID(ctx context.Context, taskID int64, limit int, order, cursor string) ([]Comment, string, error) {
+	dir, op := "ASC", ">"
+	if order == "created_desc" {
+		dir, op = "DESC", "<"
+	}
+
+	args := []any{taskID, limit + 1}
+	after := ""
+	if cursor != "" {
+		cur, err := utils.DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errBadCursor, err)
+		}
+		if !utils.ValidCursorTime(cur.Key) {
+			return nil, "", fmt.Errorf("%w: bad key %q", errBadCursor, cur.Key)
+		}
+		after = fmt.Sprintf("AND (created_at, commentid) %s ($3::text::timestamptz, $4)", op)
+		args = append(args, cur.Key, cur.ID)
+	}
+
+	rows, err := pool.Query(ctx, fmt.Sprintf(`
+		WITH RECURSIVE roots AS (
+			SELECT commentid, created_at
+			FROM task_comments
+			WHERE taskid = $1 AND parent_commentid IS NULL %[1]s
+			ORDER BY created_at %[2]s, commentid %[2]s
+			LIMIT $2
+		),
+		thread AS (
+			SELECT c.commentid, c.taskid, c.parent_commentid, COALESCE(c.author,'') AS author, c.body,
+			       c.created_at, c.edited_at, c.deleted_at, 0 AS depth,
+			       c.created_at AS root_created, c.commentid AS rootid, ARRAY[c.commentid] AS path
+			FROM task_comments c
+			JOIN roots r ON r.commentid = c.commentid
+
+			UNION ALL
+
+			SELECT c.commentid, c.taskid, c.parent_commentid, COALESCE(c.author,''), c.body,
+			       c.created_at, c.edited_at, c.deleted_at, t.depth + 1,
+			       t.root_created, t.rootid, t.path || c.commentid
+			FROM task_comments c
+			JOIN thread t ON c.parent_commentid = t.commentid
+		)
+		SELECT commentid, taskid, parent_commentid, author, body, created_at, edited_at, deleted_at, depth,
+		       root_created, rootid
+		FROM thread
+		ORDER BY root_created %[2]s, rootid %[2]s, path
+	`, after, dir), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var (
+		out      = make([]Comment, 0, limit)
+		roots    = 0
+		lastRoot utils.Cursor
+		next     string
+	)
+	for rows.Next() {
+		var (
+			cmt         Comment
+			rootCreated time.Time
+			rootID      int64
+		)
+		if err := rows.Scan(&cmt.CommentID, &cmt.TaskID, &cmt.ParentCommentID, &cmt.Author, &cmt.Body,
+			&cmt.CreatedAt, &cmt.EditedAt, &cmt.DeletedAt, &cmt.Depth, &rootCreated, &rootID); err != nil {
+			return nil, "", err
+		}
+		if cmt.Depth == 0 {
+			roots++
+			// the extra root only tells that there is a next page
+			if roots > limit {
+				next = utils.EncodeCursor(lastRoot)
+				break
+			}
+			lastRoot = utils.Cursor{Key: rootCreated.Format(time.RFC3339Nano), ID: rootID}
+		}
+		out = append(out, cmt)
+	}
+	return out, next, rows.Err()
+}
+
+// headline markers, swapped for <mark> once the snippet is escaped
+const (
+	markStart = "\x02"
+	markStop  = "\x03"
+)
+
+// SearchTasks ranks tasks and comments matching q (web search syntax) within
+// the teams of username; admins search every team.
+func SearchTasks(ctx context.Context, username string, admin bool, q string, limit int) ([]SearchHit, error) {
+	limit = normalizeLimit(limit)
+
+	rows, err := pool.Query(ctx, `
+		WITH q AS (SELECT websearch_to_tsquery('english', $1) AS query),
+		scope AS (
+			SELECT teamid FROM team_members WHERE username = $2
+		)
+		SELECT kind, taskid, teamid, title, status, commentid, rank, snippet, created_at
+		FROM (
+			SELECT 'task' AS kind, t.taskid, t.teamid, COALESCE(t.title,'') AS title,
+			       COALESCE(t.status,'') AS status, NULL::bigint AS commentid,
+			       ts_rank(t.search_vector, q.query) AS rank,
+			       ts_headline('english', COALESCE(t.title,'') || ' — ' || COALESCE(t.description,''), q.query, $3) AS snippet,
+			       t.created_at
+			FROM tasks t, q
+			WHERE t.search_vector @@ q.query
+			  AND ($4 OR t.teamid IN (SELECT teamid FROM scope))
+			  AND `+liveTaskOf("t")+`
+
+			UNION ALL
+
+			SELECT 'comment', t.taskid, t.teamid, COALESCE(t.title,''),
+			       COALESCE(t.status,''), c.commentid,
+			       ts_rank(c.search_vector, q.query),
+			       ts_headline('english', c.body, q.query, $3),
+			       c.created_at
+			FROM task_comments c
+			JOIN tasks t ON t.taskid = c.taskid, q
+			WHERE c.search_vector @@ q.query AND c.deleted_at IS NULL
+			  AND ($4 OR t.teamid IN (SELECT teamid FROM scope))
+			  AND `+liveTaskOf("t")+`
+		) hits
+		ORDER BY rank DESC, created_at DESC
+		LIMIT $5
+	`, q, username, headlineOptions, admin, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]SearchHit, 0, limit)
+	for rows.Next() {
+		var h SearchHit
+		if err := rows.Scan(&h.Kind, &h.TaskID, &h.TeamID, &h.Title, &h.Status, &h.CommentID,
+			&h.Rank, &h.Snippet, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		h.Snippet = highlight(h.Snippet)
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+var headlineOptions = fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=30, MinWords=10, MaxFragments=2", markStart, markStop)
+
+// highlight escapes a headline and turns its markers into <mark> tags.
+func highlight(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, markStart, "<mark>")
+	return strings.ReplaceAll(s, markStop, "</mark>")
+}
+
+// team_members is owned by mteam but lives in the same database, so the
+// membership checks read it directly instead of calling the team service.
+// Teams in the trash have no members.
+func IsTeamMember(ctx context.Context, teamID int64, username string) (bool, error) {
+	var exists bool
+	err := pool.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM team_members m
+			JOIN teams t ON t.teamid = m.teamid AND t.deleted_at IS NULL
+			WHERE m.teamid = $1 AND m.username = $2
+		)
+	`, teamID, username).Scan(&exists)
+	return exists, err
+}
+
+// NonMembers returns the usernames that are not members of the team.
+func NonMembers(ctx context.Context, teamID int64, usernames []string) ([]string, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT DISTINCT btrim(u)
+		FROM unnest($2::text[]) AS u
+		WHERE btrim(u) <> '' AND NOT EXISTS (
+			SELECT 1 FROM team_members m
+			WHERE m.teamid = $1 AND m.username = btrim(u)
+		)
+		ORDER BY 1
+	`, teamID, usernames)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func IsTeamLeader(ctx context.Context, teamID int64, username string) (bool, error) {
+	var exists bool
+	err := pool.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM team_members m
+			JOIN teams t ON t.teamid = m.teamid AND t.deleted_at IS NULL
+			WHERE m.teamid = $1 AND m.username = $2 AND m.role = 'leader'
+		)
+	`, teamID, username).Scan(&exists)
+	return exists, err
+}
+
+// team_statuses is owned by mteam as well; teams without rows use the default workflow.
+func TeamWorkflow(ctx context.Context, teamID int64) (Workflow, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT name, terminal
+		FROM team_statuses
+		WHERE teamid = $1
+		ORDER BY position
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wf := make(Workflow, 0, 8)
+	for rows.Next() {
+		var s WorkflowStatus
+		if err := rows.Scan(&s.Name, &s.Terminal); err != nil {
+			return nil, err
+		}
+		wf = append(wf, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(wf) == 0 {
+		return defaultWorkflow, nil
+	}
+	return wf, nil
+}
+
+const recurrenceColumns = `r.template_taskid, t.teamid, r.freq, r.interval_n, r.weekdays, r.state,
+		       r.starts_at, r.last_due, r.next_due, COALESCE(r.created_by,''), r.updated_at`
+
+func GetRecurrence(ctx context.Context, templateID int64) (*Recurrence, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+recurrenceColumns+`
+		FROM task_recurrences r
+		JOIN tasks t ON t.taskid = r.template_taskid
+		WHERE r.template_taskid = $1
+	`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByPos[Recurrence])
+}
+
+// ListActiveRecurrences returns the active recurrences whose template is not in the trash.
+func ListActiveRecurrences(ctx context.Context) ([]Recurrence, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+recurrenceColumns+`
+		FROM task_recurrences r
+		JOIN tasks t ON t.taskid = r.template_taskid
+		WHERE r.state = 'active' AND `+liveTaskOf("t")+`
+		ORDER BY r.next_due ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Recurrence])
+}
+
+// SetRecurrence makes the task a recurring template under rec (Freq, Interval
+// and Weekdays), replacing any previous rule. The rule counts from the latest
+// deadline among the template and its instances, today when none has one.
+func SetRecurrence(ctx context.Context, templateID int64, actor string, rec Recurrence) (*Recurrence, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	task, err := getTask(ctx, tx, templateID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var anchor *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT MAX(deadline) FROM tasks
+		WHERE (taskid = $1 OR recurrence_of = $1) AND deleted_at IS NULL
+	`, templateID).Scan(&anchor)
+	if err != nil {
+		return nil, err
+	}
+	if anchor == nil {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		anchor = &today
+	}
+
+	var before string
+	if prev, err := GetRecurrence(ctx, templateID); err == nil {
+		before = prev.String()
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	rec.StartsAt = *anchor
+	rec.LastDue = *anchor
+	rec.NextDue = rec.next(*anchor)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO task_recurrences (template_taskid, freq, interval_n, weekdays, state, starts_at, last_due, next_due, created_by)
+		VALUES ($1, $2, $3, $4, 'active', $5, $6, $7, $8)
+		ON CONFLICT (template_taskid) DO UPDATE SET
+		  freq = EXCLUDED.freq, interval_n = EXCLUDED.interval_n, weekdays = EXCLUDED.weekdays,
+		  state = 'active', starts_at = EXCLUDED.starts_at, last_due = EXCLUDED.last_due,
+		  next_due = EXCLUDED.next_due, created_by = EXCLUDED.created_by, updated_at = now()
+	`, templateID, rec.Freq, rec.Interval, rec.Weekdays, rec.StartsAt, rec.LastDue, rec.NextDue, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertTaskEvents(ctx, tx, TaskEvent{
+		TaskID:   templateID,
+		TeamID:   task.TeamID,
+		Actor:    actor,
+		Action:   "updated",
+		Field:    "recurrence",
+		OldValue: before,
+		NewValue: rec.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return GetRecurrence(ctx, templateID)
+}
+
+var errRecurrenceStopped = errors.New("recurrence is stopped, set a new one instead")
+
+// SetRecurrenceState pauses, resumes or stops the recurrence of the template.
+// A stopped recurrence stays stopped until SetRecurrence replaces it.
+func SetRecurrenceState(ctx context.Context, templateID int64, actor, state string) (*Recurrence, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		teamID  int64
+		current string
+	)
+	err = tx.QueryRow(ctx, `
+		SELECT t.teamid, r.state
+		FROM task_recurrences r
+		JOIN tasks t ON t.taskid = r.template_taskid
+		WHERE r.template_taskid = $1
+		FOR UPDATE OF r
+	`, templateID).Scan(&teamID, &current)
+	if err != nil {
+		return nil, err
+	}
+	if current == state {
+		return GetRecurrence(ctx, templateID)
+	}
+	if current == "stopped" {
+		return nil, errRecurrenceStopped
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE task_recurrences SET state = $2, updated_at = now()
+		WHERE template_taskid = $1
+	`, templateID, state)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertTaskEvents(ctx, tx, TaskEvent{
+		TaskID:   templateID,
+		TeamID:   teamID,
+		Actor:    actor,
+		Action:   "updated",
+		Field:    "recurrence_state",
+		OldValue: current,
+		NewValue: state,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return GetRecurrence(ctx, templateID)
+}
+
+// LatestRecurrenceInstance returns the newest live task of the recurrence, the
+// template itself when it has no instance yet.
+func LatestRecurrenceInstance(ctx context.Context, templateID int64) (*Task, error) {
+	row := pool.QueryRow(ctx, `
+		SELECT `+taskColumns+`
+		FROM tasks
+		WHERE (taskid = $1 OR recurrence_of = $1) AND `+liveTask+`
+		ORDER BY deadline DESC NULLS LAST, taskid DESC
+		LIMIT 1
+	`, templateID)
+
+	t, err := scanTask(row)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SpawnRecurrenceInstance copies the template of rec (with its assignees, labels
+// and an unchecked checklist) into a new task due at due in status, and moves the
+// recurrence on. It returns 0 when rec changed since it was read.
+func SpawnRecurrenceInstance(ctx context.Context, rec Recurrence, due time.Time, status string) (int64, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	ct, err := tx.Exec(ctx, `
+		UPDATE task_recurrences SET last_due = $2, next_due = $3, updated_at = now()
+		WHERE template_taskid = $1 AND state = 'active' AND last_due = $4
+	`, rec.TemplateTaskID, due, rec.next(due), rec.LastDue)
+	if err != nil {
+		return 0, err
+	}
+	if ct.RowsAffected() == 0 {
+		return 0, nil
+	}
+
+	var (
+		id     int64
+		teamID int64
+		title  string
+	)
+	err = tx.QueryRow(ctx, `
+		INSERT INTO tasks (teamid, title, description, author, status, deadline, priority, recurrence_of, estimate_minutes)
+		SELECT teamid, title, description, author, $2, $3, priority, taskid, estimate_minutes
+		FROM tasks
+		WHERE taskid = $1
+		RETURNING taskid, teamid, COALESCE(title,'')
+	`, rec.TemplateTaskID, status, due).Scan(&id, &teamID, &title)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO task_assignees (taskid, username)
+		SELECT $2, username FROM task_assignees WHERE taskid = $1
+	`, rec.TemplateTaskID, id)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO task_labels (taskid, labelid)
+		SELECT $2, labelid FROM task_labels WHERE taskid = $1
+	`, rec.TemplateTaskID, id)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO task_checklist_items (taskid, body, position)
+		SELECT $2, body, position FROM task_checklist_items WHERE taskid = $1
+	`, rec.TemplateTaskID, id)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertTaskEvents(ctx, tx, TaskEvent{
+		TaskID:   id,
+		TeamID:   teamID,
+		Actor:    recurrenceActor,
+		Action:   "created",
+		NewValue: title,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+const templateColumns = `tt.templateid, tt.teamid, tt.name, tt.title_pattern, tt.description, tt.priority,
+		       tt.checklist, tt.deadline_days, COALESCE(tt.created_by,''), tt.created_at, tt.updated_at,
+		       COALESCE((
+		         SELECT json_agg(json_build_object('labelid', l.labelid, 'name', l.name, 'color', l.color)
+		                         ORDER BY lower(l.name))
+		         FROM task_template_labels tl
+		         JOIN team_labels l ON l.labelid = tl.labelid
+		         WHERE tl.templateid = tt.templateid
+		       ), '[]'::json)`
+
+var errTemplateExists = errors.New("a template with this name already exists")
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// ListTaskTemplates returns the templates of the given teams by name.
+func ListTaskTemplates(ctx context.Context, teamIDs []int64) ([]TaskTemplate, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+templateColumns+`
+		FROM task_templates tt
+		WHERE tt.teamid = ANY($1)
+		ORDER BY tt.teamid, lower(tt.name)
+	`, teamIDs)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[TaskTemplate])
+}
+
+// UserTeamIDs returns the teams the user is a member of, see IsTeamMember.
+func UserTeamIDs(ctx context.Context, username string) ([]int64, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT m.teamid FROM team_members m
+		JOIN teams t ON t.teamid = m.teamid AND t.deleted_at IS NULL
+		WHERE m.username = $1
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+func GetTaskTemplate(ctx context.Context, templateID int64) (*TaskTemplate, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+templateColumns+`
+		FROM task_templates tt
+		WHERE tt.templateid = $1
+	`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByPos[TaskTemplate])
+}
+
+// SaveTaskTemplate creates the template when templateID is 0 and replaces it
+// otherwise, labels included. It returns the template id.
+func SaveTaskTemplate(ctx context.Context, templateID int64, actor string, req TaskTemplateRequest) (int64, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if templateID == 0 {
+		err = tx.QueryRow(ctx, `
+			INSERT INTO task_templates (teamid, name, title_pattern, description, priority, checklist, deadline_days, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING templateid
+		`, req.TeamID, req.Name, req.TitlePattern, req.Description, req.Priority, req.Checklist, req.DeadlineDays, actor).Scan(&templateID)
+	} else {
+		err = tx.QueryRow(ctx, `
+			UPDATE task_templates
+			SET name = $2, title_pattern = $3, description = $4, priority = $5, checklist = $6,
+			    deadline_days = $7, updated_at = now()
+			WHERE templateid = $1
+			RETURNING teamid
+		`, templateID, req.Name, req.TitlePattern, req.Description, req.Priority, req.Checklist, req.DeadlineDays).Scan(&req.TeamID)
+	}
+	if isUniqueViolation(err) {
+		return 0, errTemplateExists
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM task_template_labels WHERE templateid = $1`, templateID); err != nil {
+		return 0, err
+	}
+	ct, err := tx.Exec(ctx, `
+		INSERT INTO task_template_labels (templateid, labelid)
+		SELECT $1, l.labelid
+		FROM team_labels l
+		WHERE l.teamid = $2 AND l.labelid = ANY($3)
+	`, templateID, req.TeamID, req.LabelIDs)
+	if err != nil {
+		return 0, err
+	}
+	if int(ct.RowsAffected()) != len(req.LabelIDs) {
+		return 0, errUnknownLabel
+	}
+
+	return templateID, tx.Commit(ctx)
+}
+
+func DeleteTaskTemplate(ctx context.Context, templateID int64) error {
+	ct, err := pool.Exec(ctx, `DELETE FROM task_templates WHERE templateid = $1`, templateID)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+const timeEntryColumns = `entryid, taskid, username, started_at, ended_at, minutes, note, created_at`
+
+// entryMinutes counts a running timer of alias e up to now.
+const entryMinutes = `COALESCE(e.minutes, GREATEST(round(extract(epoch FROM now() - e.started_at) / 60)::int, 0))`
+
+var (
+	errTimerRunning = errors.New("a timer is already running")
+	errNoTimer      = errors.New("no timer running")
+)
+
+// RunningTimer returns the user's running time entry, pgx.ErrNoRows when none.
+func RunningTimer(ctx context.Context, username string) (*TimeEntry, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+timeEntryColumns+`
+		FROM time_entries
+		WHERE username = $1 AND ended_at IS NULL
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByPos[TimeEntry])
+}
+
+// StartTimer starts the user's timer on the task. A user runs one timer at a
+// time: with switchTask a running one is stopped first, otherwise it is errTimerRunning.
+func StartTimer(ctx context.Context, taskID int64, username, note string, switchTask bool) (*TimeEntry, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if switchTask {
+		if _, err := stopTimer(ctx, tx, username, nil); err != nil && !errors.Is(err, errNoTimer) {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(ctx, `
+		INSERT INTO time_entries (taskid, username, started_at, note)
+		VALUES ($1, $2, now(), $3)
+		RETURNING `+timeEntryColumns, taskID, username, note)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByPos[TimeEntry])
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, errTimerRunning
+		}
+		return nil, err
+	}
+
+	return entry, tx.Commit(ctx)
+}
+
+// StopTimer ends the user's running timer, note replaces its note when set.
+func StopTimer(ctx context.Context, username string, note *string) (*TimeEntry, error) {
+	return stopTimer(ctx, pool, username, note)
+}
+
+func stopTimer(ctx context.Context, q querier, username string, note *string) (*TimeEntry, error) {
+	rows, err := q.Query(ctx, `
+		UPDATE time_entries e
+		SET ended_at = now(), minutes = `+entryMinutes+`, note = COALESCE($2, e.note)
+		WHERE e.username = $1 AND e.ended_at IS NULL
+		RETURNING `+timeEntryColumns, username, note)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByPos[TimeEntry])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNoTimer
+	}
+	return entry, err
+}
+
+// LogTime records finished work on the task, ending now when no start is given.
+func LogTime(ctx context.Context, taskID int64, username string, req TimeLogRequest) (*TimeEntry, error) {
+	d := time.Duration(req.Minutes) * time.Minute
+	start := time.Now().Add(-d)
+	if req.StartedAt != nil {
+		start = *req.StartedAt
+	}
+
+	rows, err := pool.Query(ctx, `
+		INSERT INTO time_entries (taskid, username, started_at, ended_at, minutes, note)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+timeEntryColumns, taskID, username, start, start.Add(d), req.Minutes, req.Note)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByPos[TimeEntry])
+}
+
+// ListTimeEntries returns the time logged on a task, latest first.
+func ListTimeEntries(ctx context.Context, taskID int64, limit int) ([]TimeEntry, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+timeEntryColumns+`
+		FROM time_entries
+		WHERE taskid = $1
+		ORDER BY started_at DESC, entryid DESC
+		LIMIT $2
+	`, taskID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[TimeEntry])
+}
+
+// TeamTimeReport sums the time logged on the team's tasks over [from, to).
+func TeamTimeReport(ctx context.Context, teamID int64, from, to time.Time) (*TimeReport, error) {
+	r := &TimeReport{From: from, To: to, TeamID: teamID}
+	return r, timeReport(ctx, r, "t.teamid = $1", teamID)
+}
+
+// UserTimeReport sums the time the user logged over [from, to).
+func UserTimeReport(ctx context.Context, username string, from, to time.Time) (*TimeReport, error) {
+	r := &TimeReport{From: from, To: to, Username: username}
+	return r, timeReport(ctx, r, "e.username = $1", username)
+}
+
+// timeReport fills r with the entries started in its range that match cond on
+// $1 = arg. Entries of tasks in the trash are left out.
+func timeReport(ctx context.Context, r *TimeReport, cond string, arg any) error {
+	where := fmt.Sprintf(`%s AND e.started_at >= $2 AND e.started_at < $3 AND %s`, cond, liveTaskOf("t"))
+
+	rows, err := pool.Query(ctx, `
+		SELECT e.username, sum(`+entryMinutes+`)::int
+		FROM time_entries e
+		JOIN tasks t ON t.taskid = e.taskid
+		WHERE `+where+`
+		GROUP BY e.username
+		ORDER BY 2 DESC, e.username
+	`, arg, r.From, r.To)
+	if err != nil {
+		return err
+	}
+	if r.ByUser, err = pgx.CollectRows(rows, pgx.RowToStructByPos[TimeReportUser]); err != nil {
+		return err
+	}
+	for _, u := range r.ByUser {
+		r.TotalMinutes += u.Minutes
+	}
+
+	rows, err = pool.Query(ctx, `
+		SELECT t.taskid, t.teamid, COALESCE(t.title,''), COALESCE(t.status,''), t.estimate_minutes,
+		       sum(`+entryMinutes+`)::int,
+		       (SELECT COALESCE(sum(a.minutes), 0)::int FROM time_entries a WHERE a.taskid = t.taskid)
+		FROM time_entries e
+		JOIN tasks t ON t.taskid = e.taskid
+		WHERE `+where+`
+		GROUP BY t.taskid
+		ORDER BY 6 DESC, t.taskid
+		LIMIT 100
+	`, arg, r.From, r.To)
+	if err != nil {
+		return err
+	}
+	r.ByTask, err = pgx.CollectRows(rows, pgx.RowToStructByPos[TimeReportTask])
+	return err
+}
+
+// milestoneColumns are read back into Milestone; $1 is the team's terminal status.
+const milestoneColumns = `m.milestoneid, m.teamid, m.name, m.starts_on, m.ends_on, m.state,
+		       COALESCE(m.created_by,''), m.created_at, m.closed_at,
+		       (SELECT count(*) FROM tasks t WHERE t.milestoneid = m.milestoneid AND t.deleted_at IS NULL)::int,
+		       (SELECT count(*) FROM tasks t WHERE t.milestoneid = m.milestoneid AND t.deleted_at IS NULL
+		                                     AND t.status = $1)::int`
+
+var (
+	errMilestoneExists = errors.New("a milestone with this name already exists")
+	errMilestoneActive = errors.New("the team already has an active milestone")
+	errMilestoneState  = errors.New("milestone is not in a state that allows this")
+	errMilestoneTeam   = errors.New("milestone belongs to another team")
+)
+
+// ListMilestones returns the team's milestones, latest first.
+func ListMilestones(ctx context.Context, teamID int64, terminal string) ([]Milestone, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+milestoneColumns+`
+		FROM milestones m
+		WHERE m.teamid = $2
+		ORDER BY m.starts_on DESC, m.milestoneid DESC
+	`, terminal, teamID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Milestone])
+}
+
+func GetMilestone(ctx context.Context, milestoneID int64, terminal string) (*Milestone, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+milestoneColumns+`
+		FROM milestones m
+		WHERE m.milestoneid = $2
+	`, terminal, milestoneID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByPos[Milestone])
+}
+
+// milestoneTeam returns the team and state of a milestone, pgx.ErrNoRows when unknown.
+func milestoneTeam(ctx context.Context, q querier, milestoneID int64) (teamID int64, state string, err error) {
+	err = q.QueryRow(ctx, `SELECT teamid, state FROM milestones WHERE milestoneid = $1`, milestoneID).Scan(&teamID, &state)
+	return teamID, state, err
+}
+
+// SaveMilestone creates the milestone when milestoneID is 0 and renames or
+// reschedules it otherwise. It returns the milestone id.
+func SaveMilestone(ctx context.Context, milestoneID int64, actor string, req MilestoneRequest) (int64, error) {
+	var err error
+	if milestoneID == 0 {
+		err = pool.QueryRow(ctx, `
+			INSERT INTO milestones (teamid, name, starts_on, ends_on, created_by)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING milestoneid
+		`, req.TeamID, req.Name, req.StartsOn, req.EndsOn, actor).Scan(&milestoneID)
+	} else {
+		err = pool.QueryRow(ctx, `
+			UPDATE milestones SET name = $2, starts_on = $3, ends_on = $4
+			WHERE milestoneid = $1
+			RETURNING milestoneid
+		`, milestoneID, req.Name, req.StartsOn, req.EndsOn).Scan(&milestoneID)
+	}
+	if isUniqueViolation(err) {
+		return 0, errMilestoneExists
+	}
+	return milestoneID, err
+}
+
+// StartMilestone makes a planned milestone the team's active one.
+func StartMilestone(ctx context.Context, milestoneID int64) error {
+	ct, err := pool.Exec(ctx, `
+		UPDATE milestones SET state = 'active' WHERE milestoneid = $1 AND state = 'planned'
+	`, milestoneID)
+	if isUniqueViolation(err) {
+		return errMilestoneActive
+	}
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return errMilestoneState
+	}
+	return nil
+}
+
+// CloseMilestone closes a planned or active milestone and rolls its tasks not
+// in the terminal status into next, see MilestoneCloseRequest. It returns the
+// moved tasks and the milestone they went to, nil for none.
+func CloseMilestone(ctx context.Context, milestoneID int64, terminal, actor string, next *int64) ([]int64, *int64, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		teamID int64
+		state  string
+	)
+	err = tx.QueryRow(ctx, `
+		SELECT teamid, state FROM milestones WHERE milestoneid = $1 FOR UPDATE
+	`, milestoneID).Scan(&teamID, &state)
+	if err != nil {
+		return nil, nil, err
+	}
+	if state == "closed" {
+		return nil, nil, errMilestoneState
+	}
+
+	if next != nil {
+		nextTeam, nextState, err := milestoneTeam(ctx, tx, *next)
+		if err != nil {
+			return nil, nil, err
+		}
+		if nextTeam != teamID {
+			return nil, nil, errMilestoneTeam
+		}
+		if nextState == "closed" || *next == milestoneID {
+			return nil, nil, errMilestoneState
+		}
+	} else {
+		var id int64
+		err := tx.QueryRow(ctx, `
+			SELECT milestoneid FROM milestones
+			WHERE teamid = $1 AND state = 'planned' AND milestoneid <> $2
+			ORDER BY starts_on, milestoneid
+			LIMIT 1
+		`, teamID, milestoneID).Scan(&id)
+		switch {
+		case err == nil:
+			next = &id
+		case !errors.Is(err, pgx.ErrNoRows):
+			return nil, nil, err
+		}
+	}
+
+	rows, err := tx.Query(ctx, `
+		UPDATE tasks SET milestoneid = $2, version = version + 1
+		WHERE milestoneid = $1 AND status IS DISTINCT FROM $3 AND `+liveTask+`
+		RETURNING taskid
+	`, milestoneID, next, terminal)
+	if err != nil {
+		return nil, nil, err
+	}
+	moved, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	from := formatMilestone(&milestoneID)
+	for _, taskID := range moved {
+		err := insertTaskEvents(ctx, tx, TaskEvent{
+			TaskID:   taskID,
+			TeamID:   teamID,
+			Actor:    actor,
+			Action:   "updated",
+			Field:    "milestone",
+			OldValue: from,
+			NewValue: formatMilestone(next),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE milestones SET state = 'closed', closed_at = now() WHERE milestoneid = $1
+	`, milestoneID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return moved, next, tx.Commit(ctx)
+}
+
+// DeleteMilestone removes the milestone, its tasks stay without one.
+func DeleteMilestone(ctx context.Context, milestoneID int64) error {
+	ct, err := pool.Exec(ctx, `DELETE FROM milestones WHERE milestoneid = $1`, milestoneID)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ListMilestoneTasks returns the live tasks of the milestone, oldest first.
+func ListMilestoneTasks(ctx context.Context, milestoneID int64) ([]Task, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+taskColumns+`
+		FROM tasks
+		WHERE milestoneid = $1 AND `+liveTask+`
+		ORDER BY created_at ASC, taskid ASC
+	`, milestoneID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTasks(rows, 32)
+}
+
+// milestoneTaskHistory is what the burndown needs of a task that is or was in a milestone.
+type milestoneTaskHistory struct {
+	TaskID    int64
+	CreatedAt time.Time
+	InNow     bool   // still in the milestone
+	Status    string // current status
+	Events    []TaskEvent
+}
+
+// MilestoneTaskHistories returns the live tasks in the milestone and those
+// that left it, with their status and milestone events oldest first.
+func MilestoneTaskHistories(ctx context.Context, milestoneID int64) ([]milestoneTaskHistory, error) {
+	tag := formatMilestone(&milestoneID)
+	rows, err := pool.Query(ctx, `
+		SELECT t.taskid, t.created_at, t.milestoneid IS NOT DISTINCT FROM $1, COALESCE(t.status,''),
+		       COALESCE((
+		         SELECT json_agg(json_build_object(
+		                  'eventid', e.eventid, 'taskid', e.taskid, 'field', e.field,
+		                  'old_value', COALESCE(e.old_value,''), 'new_value', COALESCE(e.new_value,''),
+		                  'created_at', e.created_at) ORDER BY e.created_at, e.eventid)
+		         FROM task_events e
+		         WHERE e.taskid = t.taskid AND e.field IN ('status', 'milestone')
+		       ), '[]'::json)
+		FROM tasks t
+		WHERE `+liveTaskOf("t")+` AND (t.milestoneid = $1 OR EXISTS (
+		  SELECT 1 FROM task_events e
+		  WHERE e.taskid = t.taskid AND e.field = 'milestone' AND e.old_value = $2
+		))
+	`, milestoneID, tag)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[milestoneTaskHistory])
+}
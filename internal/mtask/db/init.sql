@@ -1,27 +1,272 @@
+-- team iterations (sprints), see milestones.go; created first as tasks reference them
+create table if not exists milestones (
+    milestoneid bigint generated always as identity primary key,
+    teamid bigint not null references teams(teamid) on delete cascade,
+    name text not null,
+    starts_on date not null,
+    ends_on date not null,
+    state text not null default 'planned',   -- planned | active | closed
+    created_by text,
+    created_at timestamptz not null default now(),
+    closed_at timestamptz,
+    check (ends_on >= starts_on)
+);
+
 create table if not exists tasks (
     taskid bigint generated always as identity primary key,
     teamid bigint references teams(teamid) on delete cascade,
     title text,
     description text,
     author text,
-    assignee text,
     status text,
     deadline timestamptz,
     priority text,
-    created_at timestamptz not null default now()
+    created_at timestamptz not null default now(),
+    parent_taskid bigint references tasks(taskid) on delete set null,
+    recurrence_of bigint references tasks(taskid) on delete set null,
+    estimate_minutes int check (estimate_minutes >= 0),
+    milestoneid bigint references milestones(milestoneid) on delete set null,
+    -- bumped by every update, sent as ETag and checked against If-Match
+    version integer not null default 1,
+    -- set while the task is in the trash, purged after TRASH_RETENTION_DAYS
+    deleted_at timestamptz,
+    deleted_by text,
+    search_vector tsvector generated always as (
+        setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(description, '')), 'B')
+    ) stored
 );
 
 create table if not exists task_comments (
     commentid bigint generated always as identity primary key,
     taskid bigint  references tasks(taskid) on delete cascade,
+    parent_commentid bigint references task_comments(commentid) on delete cascade,
     author text,
     body       text not null,
+    created_at timestamptz not null default now(),
+    edited_at  timestamptz,
+    -- a deleted comment with replies stays as a blank tombstone to keep its thread
+    deleted_at timestamptz,
+    search_vector tsvector generated always as (to_tsvector('english', coalesce(body, ''))) stored
+);
+
+create table if not exists task_checklist_items (
+    itemid bigint generated always as identity primary key,
+    taskid bigint not null references tasks(taskid) on delete cascade,
+    body text not null,
+    done boolean not null default false,
+    position int not null default 0,
+    created_at timestamptz not null default now()
+);
+
+-- the users working on a task (any team member, see mteam)
+create table if not exists task_assignees (
+    taskid bigint not null references tasks(taskid) on delete cascade,
+    username text not null,
+    created_at timestamptz not null default now(),
+    primary key (taskid, username)
+);
+
+-- the users following a task without working on it
+create table if not exists task_watchers (
+    taskid bigint not null references tasks(taskid) on delete cascade,
+    username text not null,
+    created_at timestamptz not null default now(),
+    primary key (taskid, username)
+);
+
+-- the team members named with @username in a task's description (commentid
+-- null) or in one of its comments
+create table if not exists task_mentions (
+    mentionid bigint generated always as identity primary key,
+    taskid bigint not null references tasks(taskid) on delete cascade,
+    commentid bigint references task_comments(commentid) on delete cascade,
+    username text not null,
+    author text not null,
+    created_at timestamptz not null default now()
+);
+
+-- what happened to the tasks a user works on or follows, see notify in database.go
+create table if not exists notifications (
+    notificationid bigint generated always as identity primary key,
+    username text not null,
+    kind text not null,
+    taskid bigint not null references tasks(taskid) on delete cascade,
+    actor text not null,
+    detail text not null default '',
+    created_at timestamptz not null default now(),
+    read_at timestamptz,
+    -- the email queue, see email.go
+    email_pending boolean not null default false,
+    email_attempts int not null default 0,
+    email_next_at timestamptz not null default now(),
+    emailed_at timestamptz
+);
+
+-- the notification emails a user opted out of, none without a row
+create table if not exists email_preferences (
+    username text primary key,
+    assigned boolean not null default true,
+    deadline boolean not null default true,
+    mention boolean not null default true,
+    updated_at timestamptz not null default now()
+);
+
+-- team_labels is owned by mteam
+create table if not exists task_labels (
+    taskid bigint not null references tasks(taskid) on delete cascade,
+    labelid bigint not null references team_labels(labelid) on delete cascade,
+    primary key (taskid, labelid)
+);
+
+-- blocker_taskid blocks blocked_taskid
+create table if not exists task_dependencies (
+    blocker_taskid bigint not null references tasks(taskid) on delete cascade,
+    blocked_taskid bigint not null references tasks(taskid) on delete cascade,
+    created_by text,
+    created_at timestamptz not null default now(),
+    primary key (blocker_taskid, blocked_taskid),
+    check (blocker_taskid <> blocked_taskid)
+);
+
+-- repeats its template task: instances are copies of it linked by tasks.recurrence_of
+create table if not exists task_recurrences (
+    template_taskid bigint primary key references tasks(taskid) on delete cascade,
+    freq text not null,                      -- 'daily' | 'weekly' | 'monthly'
+    interval_n int not null default 1,
+    weekdays text[] not null default '{}',   -- 'MO'..'SU', weekly only
+    state text not null default 'active',    -- 'active' | 'paused' | 'stopped'
+    starts_at timestamptz not null,
+    last_due timestamptz not null,
+    next_due timestamptz not null,
+    created_by text,
+    updated_at timestamptz not null default now()
+);
+
+-- team scoped presets for new tasks, see templates.go
+create table if not exists task_templates (
+    templateid bigint generated always as identity primary key,
+    teamid bigint not null references teams(teamid) on delete cascade,
+    name text not null,
+    title_pattern text not null,             -- may use {date}, {week}, {month}
+    description text not null default '',
+    priority text not null default 'MEDIUM',
+    checklist text[] not null default '{}',
+    deadline_days int,                       -- deadline relative to creation, none when null
+    created_by text,
+    created_at timestamptz not null default now(),
+    updated_at timestamptz not null default now()
+);
+
+create table if not exists task_template_labels (
+    templateid bigint not null references task_templates(templateid) on delete cascade,
+    labelid bigint not null references team_labels(labelid) on delete cascade,
+    primary key (templateid, labelid)
+);
+
+-- time logged on tasks, see timetracking.go; a running timer has no ended_at
+create table if not exists time_entries (
+    entryid bigint generated always as identity primary key,
+    taskid bigint not null references tasks(taskid) on delete cascade,
+    username text not null,
+    started_at timestamptz not null,
+    ended_at timestamptz,
+    minutes int check (minutes >= 0),        -- set once the entry ends
+    note text not null default '',
+    created_at timestamptz not null default now()
+);
+
+-- task history; no foreign key on taskid so the trail outlives the task
+create table if not exists task_events (
+    eventid bigint generated always as identity primary key,
+    taskid bigint not null,
+    teamid bigint,
+    actor text not null,
+    action text not null, -- 'created' | 'updated' | 'deleted' | 'restored'
+    field text,
+    old_value text,
+    new_value text,
     created_at timestamptz not null default now()
 );
 
 
 create index if not exists idx_tasks_teamid_created on tasks(teamid, created_at desc);
-create index if not exists idx_tasks_assignee on tasks(assignee);
 create index if not exists idx_tasks_status on tasks(status);
 
-create index if not exists idx_task_comments_taskid_created on task_comments(taskid, created_at asc);
\ No newline at end of file
+create index if not exists idx_task_comments_taskid_created on task_comments(taskid, created_at asc);
+create index if not exists idx_task_events_taskid_created on task_events(taskid, created_at asc);
+
+-- upgrades for databases created before the columns existed
+alter table task_comments add column if not exists edited_at timestamptz;
+alter table task_comments add column if not exists deleted_at timestamptz;
+alter table task_comments add column if not exists parent_commentid bigint references task_comments(commentid) on delete cascade;
+
+create index if not exists idx_task_comments_parent on task_comments(parent_commentid);
+
+alter table tasks add column if not exists parent_taskid bigint references tasks(taskid) on delete set null;
+create index if not exists idx_tasks_parent on tasks(parent_taskid);
+create index if not exists idx_task_checklist_taskid on task_checklist_items(taskid, position);
+
+create index if not exists idx_task_dependencies_blocked on task_dependencies(blocked_taskid);
+create index if not exists idx_task_labels_labelid on task_labels(labelid);
+
+alter table tasks add column if not exists search_vector tsvector generated always as (
+    setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(description, '')), 'B')
+) stored;
+alter table task_comments add column if not exists search_vector tsvector
+    generated always as (to_tsvector('english', coalesce(body, ''))) stored;
+create index if not exists idx_tasks_search on tasks using gin(search_vector);
+create index if not exists idx_task_comments_search on task_comments using gin(search_vector);
+
+alter table tasks add column if not exists version integer not null default 1;
+
+alter table tasks add column if not exists deleted_at timestamptz;
+alter table tasks add column if not exists deleted_by text;
+create index if not exists idx_tasks_deleted_at on tasks(deleted_at) where deleted_at is not null;
+
+alter table tasks add column if not exists recurrence_of bigint references tasks(taskid) on delete set null;
+create index if not exists idx_tasks_recurrence_of on tasks(recurrence_of);
+create index if not exists idx_task_recurrences_state on task_recurrences(state);
+
+create unique index if not exists idx_task_templates_team_name on task_templates(teamid, lower(name));
+
+alter table tasks add column if not exists estimate_minutes int check (estimate_minutes >= 0);
+create index if not exists idx_time_entries_taskid on time_entries(taskid, started_at);
+create index if not exists idx_time_entries_user_started on time_entries(username, started_at);
+-- at most one running timer per user
+create unique index if not exists idx_time_entries_running on time_entries(username) where ended_at is null;
+
+alter table tasks add column if not exists milestoneid bigint references milestones(milestoneid) on delete set null;
+create index if not exists idx_tasks_milestoneid on tasks(milestoneid);
+create unique index if not exists idx_milestones_team_name on milestones(teamid, lower(name));
+-- at most one running sprint per team
+create unique index if not exists idx_milestones_active on milestones(teamid) where state = 'active';
+
+create index if not exists idx_task_assignees_username on task_assignees(username);
+create index if not exists idx_task_watchers_username on task_watchers(username);
+-- tasks had a single assignee column before task_assignees
+do $$
+begin
+    if exists (select 1 from information_schema.columns
+               where table_schema = current_schema() and table_name = 'tasks' and column_name = 'assignee') then
+        insert into task_assignees (taskid, username)
+        select taskid, assignee from tasks where coalesce(assignee, '') <> ''
+        on conflict do nothing;
+        alter table tasks drop column assignee;
+    end if;
+end $$;
+
+create unique index if not exists idx_task_mentions_source on task_mentions(taskid, coalesce(commentid, 0), username);
+create index if not exists idx_task_mentions_username on task_mentions(username, created_at desc);
+
+create index if not exists idx_notifications_username on notifications(username, created_at desc);
+create index if not exists idx_notifications_unread on notifications(username) where read_at is null;
+
+alter table notifications add column if not exists email_pending boolean not null default false;
+alter table notifications add column if not exists email_attempts int not null default 0;
+alter table notifications add column if not exists email_next_at timestamptz not null default now();
+alter table notifications add column if not exists emailed_at timestamptz;
+create index if not exists idx_notifications_email_pending on notifications(email_next_at) where email_pending;
+-- set once the assignees were reminded of the deadline, cleared when it changes
+alter table tasks add column if not exists deadline_reminded_at timestamptz;
@@ -0,0 +1,206 @@
+package mtask
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// task dependencies
+//
+// A dependency reads "blocker blocks blocked". Links are made by the leader of
+// the tasks' team; links across teams only by an admin. A task cannot enter one
+// of the gated statuses (BLOCKER_GATED_STATUSES, "@done" for the team's terminal
+// status) while one of its blockers is not in its own team's terminal status,
+// unless a leader or admin forces the move with ?force=true.
+
+var defaultGatedStatuses = []string{"IN_PROGRESS"}
+
+var gatedStatuses []string
+
+func mustInitGatedStatuses(statuses []string) {
+	out := make([]string, 0, len(statuses))
+	for _, s := range statuses {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if s != terminalStatus && !validStatusName(s) {
+			log.Fatalf("invalid blocker gated status: %q", s)
+		}
+		out = append(out, s)
+	}
+	if len(out) == 0 {
+		// would turn the gate off without a word
+		log.Fatalf("invalid blocker gated statuses: none in %q", statuses)
+	}
+	gatedStatuses = out
+}
+
+func isGatedStatus(status string, wf Workflow) bool {
+	for _, p := range gatedStatuses {
+		if matchStatus(p, status, wf) {
+			return true
+		}
+	}
+	return false
+}
+
+// openBlockers returns the blockers of the task not yet finished in their own team's workflow.
+func openBlockers(c *gin.Context, taskID int64) ([]Task, error) {
+	ctx := c.Request.Context()
+	blockers, err := ListBlockers(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	workflows := make(map[int64]Workflow, 2)
+	open := make([]Task, 0, len(blockers))
+	for _, b := range blockers {
+		wf, ok := workflows[b.TeamID]
+		if !ok {
+			if wf, err = TeamWorkflow(ctx, b.TeamID); err != nil {
+				return nil, err
+			}
+			workflows[b.TeamID] = wf
+		}
+		if b.Status != wf.Terminal() {
+			open = append(open, b)
+		}
+	}
+	return open, nil
+}
+
+// checkBlockers refuses moving a task with open blockers to a gated status unless
+// the move is forced by a leader or admin. On refusal the 409 is already written.
+func checkBlockers(c *gin.Context, task *Task, to string) bool {
+	if code, body := blockersResponse(c, task, to); code != 0 {
+		c.JSON(code, body)
+		return false
+	}
+	return true
+}
+
+// blockersResponse is the refusal of checkBlockers, code is 0 when the move may go on.
+func blockersResponse(c *gin.Context, task *Task, to string) (int, gin.H) {
+	if task.Status == to {
+		return 0, nil
+	}
+
+	wf, err := TeamWorkflow(c.Request.Context(), task.TeamID)
+	if err != nil {
+		log.Printf("failed to load workflow: %v", err)
+		return http.StatusInternalServerError, gin.H{"error": "db error"}
+	}
+	if !isGatedStatus(to, wf) {
+		return 0, nil
+	}
+
+	canForce := ensureCanManageTeam(c, task.TeamID) == nil
+	if force, _ := strconv.ParseBool(c.Query("force")); force && canForce {
+		return 0, nil
+	}
+
+	open, err := openBlockers(c, task.TaskID)
+	if err != nil {
+		log.Printf("failed to list blockers: %v", err)
+		return http.StatusInternalServerError, gin.H{"error": "db error"}
+	}
+	if len(open) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, 0, len(open))
+	for _, b := range open {
+		ids = append(ids, b.TaskID)
+	}
+	return http.StatusConflict, gin.H{
+		"error":       fmt.Sprintf("task is blocked by %d unfinished tasks", len(open)),
+		"blockers":    ids,
+		"needs_force": true,
+		"can_force":   canForce,
+	}
+}
+
+// loadDependencyFor loads both tasks of a dependency and checks that the caller
+// leads their team, or is an admin when they belong to different teams.
+// On failure the response is already written and ok is false.
+func loadDependencyFor(c *gin.Context, req DependencyRequest) (blocker, blocked *Task, ok bool) {
+	if req.BlockerID == req.BlockedID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a task cannot block itself"})
+		return nil, nil, false
+	}
+
+	if blocked, ok = loadTaskFor(c, req.BlockedID, ensureCanManageTeam); !ok {
+		return nil, nil, false
+	}
+	if blocker, ok = loadTaskFor(c, req.BlockerID, ensureCanManageTeam); !ok {
+		return nil, nil, false
+	}
+
+	if blocker.TeamID != blocked.TeamID && !isAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only an admin may link tasks of different teams"})
+		return nil, nil, false
+	}
+	return blocker, blocked, true
+}
+
+func handleDependencyCreate(c *gin.Context) {
+	var req DependencyRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	blocker, blocked, ok := loadDependencyFor(c, req)
+	if !ok {
+		return
+	}
+
+	actor, _ := mustUsername(c)
+	err := AddDependency(c.Request.Context(), blocker, blocked, actor)
+	if err != nil {
+		switch {
+		case errors.Is(err, errDependencyCycle), errors.Is(err, errDependencyExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("failed to add dependency: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"status": "ok"})
+}
+
+func handleDependencyDelete(c *gin.Context) {
+	var req DependencyRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "blocker_taskid and blocked_taskid required"})
+		return
+	}
+
+	blocker, blocked, ok := loadDependencyFor(c, req)
+	if !ok {
+		return
+	}
+
+	actor, _ := mustUsername(c)
+	err := RemoveDependency(c.Request.Context(), blocker, blocked, actor)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "dependency not found"})
+			return
+		}
+		log.Printf("failed to remove dependency: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
@@ -0,0 +1,289 @@
+package mtask
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// email notifications
+//
+// Users get an email when they are assigned a task, when the deadline of one
+// of their tasks is near and when they are mentioned, unless they opted out of
+// that kind. Emails are off without SMTP_ADDRESS.
+//
+// The notifications to email are queued in their table (email_pending), so a
+// request only records them and wakes the mailer, however slow the mail
+// server. The mailer leases a batch at a time, which keeps two mtasks from
+// sending the same email, and retries a failed email with a growing backoff up
+// to MAIL_MAX_ATTEMPTS times. A lease that runs out without an answer (e.g. a
+// crash mid send) makes the email due again.
+
+const (
+	mailBatch       = 20
+	mailLease       = 10 * time.Minute
+	mailSendTimeout = 30 * time.Second
+	mailMaxBackoff  = time.Hour
+
+	// how often the deadlines are checked
+	deadlineCheckInterval = 15 * time.Minute
+)
+
+// emailKinds are the notification kinds that are emailed too.
+var emailKinds = []string{notifyAssigned, notifyDeadline, notifyMention}
+
+// mailSubjects take the actor and the task's title.
+var mailSubjects = map[string]string{
+	notifyAssigned: "%s assigned you to %s",
+	notifyDeadline: "%[2]s is due soon",
+	notifyMention:  "%s mentioned you in %s",
+}
+
+var (
+	// mailer is nil when emails are off
+	mailer        Mailer
+	mailTemplates map[string]*template.Template
+
+	mailWake = make(chan struct{}, 1)
+)
+
+func mustInitMailer() {
+	if config.SMTPAddress == "" {
+		log.Printf("email notifications disabled, SMTP_ADDRESS is not set")
+		return
+	}
+
+	// a bad sender would fail every email
+	if _, err := mail.ParseAddress(config.MailFrom); err != nil {
+		log.Fatalf("invalid MAIL_FROM %q: %v", config.MailFrom, err)
+	}
+
+	mailTemplates = make(map[string]*template.Template, len(emailKinds))
+	for _, kind := range emailKinds {
+		// layout.html wraps the kind's "content"
+		t, err := template.ParseFiles(
+			filepath.Join(config.MailTemplatesPath, "layout.html"),
+			filepath.Join(config.MailTemplatesPath, kind+".html"),
+		)
+		if err != nil {
+			log.Fatalf("failed to load the email templates: %v", err)
+		}
+		mailTemplates[kind] = t
+	}
+
+	mustInitKcService()
+	mailer = NewSMTPMailer(config.SMTPAddress, config.MailFrom, config.SMTPUsername, config.SMTPPassword, mailSendTimeout)
+}
+
+// emailKind tells whether notifications of kind are to be emailed.
+func emailKind(kind string) bool {
+	return mailer != nil && slices.Contains(emailKinds, kind)
+}
+
+// wakeMailer makes the mailer look at the queue before its next tick, after a
+// request that may have queued emails.
+func wakeMailer() {
+	select {
+	case mailWake <- struct{}{}:
+	default:
+	}
+}
+
+func runMailer(ctx context.Context, interval time.Duration) {
+	if mailer == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sendPendingEmails(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-mailWake:
+		}
+	}
+}
+
+func sendPendingEmails(ctx context.Context) {
+	for ctx.Err() == nil {
+		batch, err := ClaimPendingEmails(ctx, mailBatch, mailLease)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("failed to claim pending emails: %v", err)
+			}
+			return
+		}
+		for _, p := range batch {
+			deliverEmail(ctx, p)
+		}
+		if len(batch) < mailBatch {
+			return
+		}
+	}
+}
+
+// deliverEmail sends the notification's email, unless its user opted out or
+// has no address, and finishes or retries it.
+func deliverEmail(ctx context.Context, p PendingEmail) {
+	prefs, err := GetEmailPreferences(ctx, p.Username)
+	if err != nil {
+		retryEmail(ctx, p, err)
+		return
+	}
+	if !prefs.Wants(p.Kind) {
+		finishEmail(ctx, p, false)
+		return
+	}
+
+	to, err := emailAddress(ctx, p.Username)
+	if errors.Is(err, errNoAddress) {
+		log.Printf("no email address for %s, notification %d not emailed", p.Username, p.NotificationID)
+		finishEmail(ctx, p, false)
+		return
+	}
+	if err != nil {
+		retryEmail(ctx, p, err)
+		return
+	}
+
+	msg, err := renderEmail(p.Notification, to)
+	if err != nil {
+		// retrying renders the same
+		log.Printf("failed to render email of notification %d: %v", p.NotificationID, err)
+		finishEmail(ctx, p, false)
+		return
+	}
+	if err := mailer.Send(ctx, msg); err != nil {
+		retryEmail(ctx, p, err)
+		return
+	}
+	finishEmail(ctx, p, true)
+}
+
+func finishEmail(ctx context.Context, p PendingEmail, sent bool) {
+	if err := FinishEmail(ctx, p.NotificationID, sent); err != nil {
+		// the lease runs out and it is sent again
+		log.Printf("failed to finish email of notification %d: %v", p.NotificationID, err)
+	}
+}
+
+// retryEmail makes the email due again after 1, 2, 4... minutes, up to an
+// hour, or gives up after the last attempt.
+func retryEmail(ctx context.Context, p PendingEmail, cause error) {
+	if p.Attempts >= config.MailMaxAttempts {
+		log.Printf("giving up on the email of notification %d after %d attempts: %v", p.NotificationID, p.Attempts, cause)
+		finishEmail(ctx, p, false)
+		return
+	}
+
+	// 1m<<6 is past the cap already, a larger shift would overflow
+	backoff := min(time.Minute<<min(max(p.Attempts-1, 0), 6), mailMaxBackoff)
+	log.Printf("email of notification %d failed (attempt %d), retrying in %s: %v", p.NotificationID, p.Attempts, backoff, cause)
+	if err := RetryEmail(ctx, p.NotificationID, time.Now().Add(backoff)); err != nil {
+		log.Printf("failed to reschedule email of notification %d: %v", p.NotificationID, err)
+	}
+}
+
+// mailData is what the email templates get.
+type mailData struct {
+	Notification
+	// the front's address, without a trailing slash
+	AppURL string
+}
+
+func renderEmail(n Notification, to string) (Message, error) {
+	t, ok := mailTemplates[n.Kind]
+	if !ok {
+		return Message{}, fmt.Errorf("no email template for %q", n.Kind)
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, mailData{Notification: n, AppURL: config.AppURL}); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		To:      to,
+		Subject: fmt.Sprintf(mailSubjects[n.Kind], n.Actor, n.TaskTitle),
+		HTML:    b.String(),
+	}, nil
+}
+
+// runDeadlineReminders notifies the assignees of the tasks due within the
+// next window, once per deadline.
+func runDeadlineReminders(ctx context.Context, window time.Duration) {
+	if window <= 0 {
+		log.Printf("deadline reminders disabled")
+		return
+	}
+
+	ticker := time.NewTicker(deadlineCheckInterval)
+	defer ticker.Stop()
+	for {
+		n, err := RemindDeadlines(ctx, window)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("failed to remind deadlines: %v", err)
+		} else if n > 0 {
+			wakeMailer()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func handleEmailPreferencesGet(c *gin.Context) {
+	username, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	prefs, err := GetEmailPreferences(c.Request.Context(), username)
+	if err != nil {
+		log.Printf("failed to get email preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	prefs.Enabled = mailer != nil
+	c.JSON(http.StatusOK, prefs)
+}
+
+func handleEmailPreferencesSet(c *gin.Context) {
+	username, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req EmailPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	prefs, err := SetEmailPreferences(c.Request.Context(), username, req)
+	if err != nil {
+		log.Printf("failed to set email preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	prefs.Enabled = mailer != nil
+	c.JSON(http.StatusOK, prefs)
+}
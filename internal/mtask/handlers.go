@@ -1,12 +1,15 @@
 package mtask
 
 import (
-	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+
+	"kyri56xcaesar/pms-proj/internal/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
@@ -19,6 +22,11 @@ func handleListTasks(c *gin.Context) {
 
 		return
 	}
+	if err := ensureCanViewTeam(c, teamID); err != nil {
+		respondAuthzError(c, err)
+
+		return
+	}
 
 	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	if err != nil {
@@ -28,16 +36,37 @@ func handleListTasks(c *gin.Context) {
 	}
 	order := c.DefaultQuery("order", "created_desc")
 	status := c.Query("status")
-	assignee := c.Query("assignee")
-
-	items, err := ListTasks(c.Request.Context(), ListTasksFilter{
-		TeamID:   teamID,
-		Assignee: assignee,
-		Status:   status,
-		Limit:    limit,
-		Order:    order,
+	assignees := listQuery(c, "assignee")
+	labels, match, ok := labelQuery(c)
+	if !ok {
+		return
+	}
+	var milestoneID int64
+	if v := c.Query("milestoneid"); v != "" {
+		if milestoneID, err = strconv.ParseInt(v, 10, 64); err != nil || milestoneID <= 0 {
+			c.JSON(400, gin.H{"error": "invalid milestoneid"})
+
+			return
+		}
+	}
+
+	items, next, err := ListTasks(c.Request.Context(), ListTasksFilter{
+		TeamID:      teamID,
+		Assignees:   assignees,
+		Status:      status,
+		Labels:      labels,
+		LabelMatch:  match,
+		MilestoneID: milestoneID,
+		Cursor:      c.Query("cursor"),
+		Limit:       limit,
+		Order:       order,
 	})
 	if err != nil {
+		if errors.Is(err, errBadCursor) {
+			c.JSON(400, gin.H{"error": "bad cursor"})
+
+			return
+		}
 		log.Printf("failed to list: %v", err)
 		c.JSON(500, gin.H{"error": "db error"})
 
@@ -45,15 +74,45 @@ func handleListTasks(c *gin.Context) {
 	}
 
 	payload := gin.H{
-		"items":  items,
-		"limit":  normalizeLimit(limit),
-		"order":  order,
-		"status": status,
+		"items":       items,
+		"limit":       normalizeLimit(limit),
+		"order":       order,
+		"status":      status,
+		"assignees":   assignees,
+		"labels":      labels,
+		"label_match": match,
+		"next_cursor": next,
 	}
 
 	c.JSON(http.StatusOK, payload)
 }
 
+// listQuery reads a repeated or comma separated query parameter.
+func listQuery(c *gin.Context, key string) []string {
+	var out []string
+	for _, v := range c.QueryArray(key) {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+// labelQuery reads the label filter: repeated or comma separated ?label= names
+// and ?label_match=any|all. On failure the 400 is already written and ok is false.
+func labelQuery(c *gin.Context) (labels []string, match string, ok bool) {
+	labels = listQuery(c, "label")
+
+	match = strings.ToLower(c.DefaultQuery("label_match", "any"))
+	if match != "any" && match != "all" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label_match must be any or all"})
+		return nil, "", false
+	}
+	return labels, match, true
+}
+
 func handleTaskCreate(c *gin.Context) {
 	var req CreateTaskRequest
 	if err := c.ShouldBind(&req); err != nil {
@@ -67,14 +126,81 @@ func handleTaskCreate(c *gin.Context) {
 		c.JSON(401, gin.H{"error": "unauthorized"})
 		return
 	}
+	if err := ensureCanManageTeam(c, req.TeamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	if req.TemplateID != nil {
+		tpl, err := GetTaskTemplate(c.Request.Context(), *req.TemplateID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				c.JSON(400, gin.H{"error": "template not found"})
+				return
+			}
+			log.Printf("failed to get template: %v", err)
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if tpl.TeamID != req.TeamID {
+			c.JSON(400, gin.H{"error": "template belongs to another team"})
+			return
+		}
+		applyTemplate(&req, tpl, time.Now())
+	}
+	if len(strings.TrimSpace(req.Title)) < 2 {
+		c.JSON(400, gin.H{"error": "title required"})
+		return
+	}
+
+	wf, err := TeamWorkflow(c.Request.Context(), req.TeamID)
+	if err != nil {
+		log.Printf("failed to load workflow: %v", err)
+		c.JSON(500, gin.H{"error": "db error"})
+		return
+	}
+	if req.Status == "" {
+		req.Status = wf.Initial()
+	} else if !wf.Has(req.Status) {
+		c.JSON(400, gin.H{"error": errUnknownStatus.Error(), "status": req.Status})
+		return
+	}
+
+	if req.ParentTaskID != nil {
+		parent, err := getTask(c.Request.Context(), pool, *req.ParentTaskID, false)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				c.JSON(400, gin.H{"error": "parent task not found"})
+				return
+			}
+			log.Printf("failed to get parent task: %v", err)
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if parent.TeamID != req.TeamID {
+			c.JSON(400, gin.H{"error": "parent task belongs to another team"})
+			return
+		}
+	}
+	if !checkTaskMilestone(c, req.TeamID, req.MilestoneID) {
+		return
+	}
+	if !checkAssignees(c, req.TeamID, req.Assignees) {
+		return
+	}
 
 	id, err := CreateTask(c.Request.Context(), author, req)
 	if err != nil {
+		if errors.Is(err, errUnknownLabel) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 		log.Printf("failed to create task: %v", err)
 		c.JSON(500, gin.H{"error": "db error"})
 
 		return
 	}
+	wakeMailer()
 
 	c.JSON(201, gin.H{"status": "ok", "taskid": id})
 }
@@ -85,8 +211,12 @@ func handleTaskDelete(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "taskid required"})
 		return
 	}
+	if _, ok := loadTaskFor(c, taskID, ensureCanManageTeam); !ok {
+		return
+	}
 
-	err = DeleteTask(c.Request.Context(), taskID)
+	actor, _ := mustUsername(c)
+	err = DeleteTask(c.Request.Context(), taskID, actor)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			c.JSON(404, gin.H{"error": "task not found"})
@@ -112,22 +242,67 @@ func handleTaskUpdate(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "invalid input"})
 		return
 	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+	task, ok := loadTaskFor(c, taskID, ensureCanManageTeam)
+	if !ok {
+		return
+	}
+	if task.Version != version {
+		respondStaleTask(c, taskID)
+		return
+	}
+	if req.Status != nil {
+		if err := checkTransition(c, task, *req.Status); err != nil {
+			respondTransitionError(c, task, *req.Status, err)
+			return
+		}
+		if !confirmCloseWithOpenSubtasks(c, task, *req.Status) {
+			return
+		}
+		if !checkBlockers(c, task, *req.Status) {
+			return
+		}
+	}
+	if !checkTaskMilestone(c, task.TeamID, req.MilestoneID) {
+		return
+	}
+	if req.Assignees != nil && !checkAssignees(c, task.TeamID, *req.Assignees) {
+		return
+	}
 
-	err = UpdateTask(c.Request.Context(), taskID, req)
+	actor, _ := mustUsername(c)
+	next, err := UpdateTask(c.Request.Context(), taskID, actor, version, req)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			c.JSON(404, gin.H{"error": "task not found"})
 			return
 		}
+		if errors.Is(err, errVersionMismatch) {
+			respondStaleTask(c, taskID)
+			return
+		}
 		if strings.Contains(err.Error(), "no fields") {
 			c.JSON(400, gin.H{"error": "provide fields to update"})
 			return
 		}
+		if errors.Is(err, errUnknownLabel) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(500, gin.H{"error": "db error"})
 		return
 	}
+	if req.Status != nil {
+		// closing an instance of a recurring task brings up the next one
+		wakeRecurrences()
+	}
+	wakeMailer()
 
-	c.JSON(200, gin.H{"status": "ok"})
+	c.Header("ETag", utils.ETag(next))
+	c.JSON(200, gin.H{"status": "ok", "version": next})
 }
 
 func handleTaskPatch(c *gin.Context) {
@@ -139,32 +314,160 @@ func handleTaskPatch(c *gin.Context) {
 	}
 	status := c.Query("status")
 	log.Printf("status: %s", status)
-	if !validStatus(status) {
+	if !validStatusName(status) {
 		c.JSON(400, gin.H{"error": "invalid status"})
 
 		return
 	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+	task, ok := loadTaskFor(c, taskID, ensureCanViewTeam)
+	if !ok {
+		return
+	}
+	if task.Version != version {
+		respondStaleTask(c, taskID)
+		return
+	}
+	if err := checkTransition(c, task, status); err != nil {
+		respondTransitionError(c, task, status, err)
+		return
+	}
+	if !confirmCloseWithOpenSubtasks(c, task, status) {
+		return
+	}
+	if !checkBlockers(c, task, status) {
+		return
+	}
 
 	ur := UpdateTaskRequest{
 		Status: &status,
 	}
 
-	err = UpdateTask(c.Request.Context(), taskID, ur)
+	actor, _ := mustUsername(c)
+	next, err := UpdateTask(c.Request.Context(), taskID, actor, version, ur)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			c.JSON(404, gin.H{"error": "task not found"})
 			return
 		}
+		if errors.Is(err, errVersionMismatch) {
+			respondStaleTask(c, taskID)
+			return
+		}
 
 		c.JSON(500, gin.H{"error": "db error"})
 		return
 	}
-	c.JSON(200, gin.H{"status": "ok"})
+	wakeRecurrences()
+
+	c.Header("ETag", utils.ETag(next))
+	c.JSON(200, gin.H{"status": "ok", "version": next})
 
 }
 
 func handlePersonalTask(c *gin.Context) {
+	username, ok := mustUsername(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "unauthorized"})
+
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "bad limit"})
+
+		return
+	}
+	order := c.DefaultQuery("order", "created_desc")
+
+	status := strings.TrimSpace(c.Query("status"))
+	if status != "" && !validStatusName(status) {
+		c.JSON(400, gin.H{"error": "invalid status"})
+
+		return
+	}
+	priority := strings.ToUpper(strings.TrimSpace(c.Query("priority")))
+	if priority != "" && !validPriority(priority) {
+		c.JSON(400, gin.H{"error": "invalid priority"})
+
+		return
+	}
+
+	dueFrom, err := parseDateQuery(c.Query("due_from"), false)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid due_from"})
 
+		return
+	}
+	dueBefore, err := parseDateQuery(c.Query("due_to"), true)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid due_to"})
+
+		return
+	}
+	labels, match, ok := labelQuery(c)
+	if !ok {
+		return
+	}
+
+	items, next, err := ListTasksForUser(c.Request.Context(), MyTasksFilter{
+		Username:   username,
+		Status:     status,
+		Priority:   priority,
+		DueFrom:    dueFrom,
+		DueBefore:  dueBefore,
+		Labels:     labels,
+		LabelMatch: match,
+		Cursor:     c.Query("cursor"),
+		Limit:      limit,
+		Order:      order,
+	})
+	if err != nil {
+		if errors.Is(err, errBadCursor) {
+			c.JSON(400, gin.H{"error": "bad cursor"})
+
+			return
+		}
+		log.Printf("failed to list personal tasks: %v", err)
+		c.JSON(500, gin.H{"error": "db error"})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":       items,
+		"limit":       normalizeLimit(limit),
+		"order":       order,
+		"status":      status,
+		"priority":    priority,
+		"labels":      labels,
+		"label_match": match,
+		"next_cursor": next,
+	})
+}
+
+// parseDateQuery accepts yyyy-mm-dd or RFC3339. A bare date used as an upper
+// bound is moved to the start of the next day so the whole day is included.
+func parseDateQuery(v string, upper bool) (*time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return nil, err
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
 }
 
 func handleCommentCreate(c *gin.Context) {
@@ -187,19 +490,40 @@ func handleCommentCreate(c *gin.Context) {
 		return
 	}
 
-	authorAny, _ := c.Get("kc.username")
-	author := authorAny.(string)
+	author, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
 
-	// OPTIONAL AUTHZ (recommended): ensure caller can view task/team
-	// task, err := GetTaskByID(c.Request.Context(), req.TaskID)
-	// ... check membership/admin ...
+	if _, ok := loadTaskFor(c, req.TaskID, ensureCanViewTeam); !ok {
+		return
+	}
 
-	id, err := CreateComment(c.Request.Context(), req.TaskID, author, body)
+	if req.ParentCommentID != nil {
+		parent, err := GetCommentByID(c.Request.Context(), *req.ParentCommentID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "parent comment not found"})
+				return
+			}
+			log.Printf("failed to get parent comment: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if parent.TaskID != req.TaskID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parent comment belongs to another task"})
+			return
+		}
+	}
+
+	id, err := CreateComment(c.Request.Context(), req.TaskID, req.ParentCommentID, author, body)
 	if err != nil {
 		log.Printf("failed to create comment: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
+	wakeMailer()
 
 	c.JSON(http.StatusCreated, gin.H{
 		"status":    "ok",
@@ -208,7 +532,68 @@ func handleCommentCreate(c *gin.Context) {
 }
 
 func handleCommentDelete(c *gin.Context) {
+	commentID, err := strconv.ParseInt(c.Query("commentid"), 10, 64)
+	if err != nil || commentID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "commentid required"})
+		return
+	}
+
+	if _, ok := loadCommentFor(c, commentID); !ok {
+		return
+	}
+
+	if err := DeleteComment(c.Request.Context(), commentID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+			return
+		}
+		log.Printf("failed to delete comment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func handleCommentUpdate(c *gin.Context) {
+	commentID, err := strconv.ParseInt(c.Query("commentid"), 10, 64)
+	if err != nil || commentID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "commentid required"})
+		return
+	}
+
+	var req UpdateCommentRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body required"})
+		return
+	}
+
+	cmt, ok := loadCommentFor(c, commentID)
+	if !ok {
+		return
+	}
+	if cmt.DeletedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "comment was deleted"})
+		return
+	}
+
+	if err := UpdateComment(c.Request.Context(), commentID, body); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+			return
+		}
+		log.Printf("failed to update comment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	wakeMailer()
 
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "commentid": commentID})
 }
 
 func handleGetTaskByID(c *gin.Context) {
@@ -219,9 +604,43 @@ func handleGetTaskByID(c *gin.Context) {
 		return
 	}
 
+	// the details are only loaded for those allowed to see them
+	task, ok := loadTaskFor(c, taskID, ensureCanViewTeam)
+	if !ok {
+		return
+	}
+	if err := LoadTaskDetails(c.Request.Context(), task); err != nil {
+		log.Printf("failed to get task: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.Header("ETag", utils.ETag(task.Version))
+	c.JSON(http.StatusOK, task)
+}
+
+// ifMatchVersion reads the task version a PUT or PATCH was made against from If-Match.
+// On failure the response is already written and ok is false.
+func ifMatchVersion(c *gin.Context) (int, bool) {
+	tag := c.GetHeader("If-Match")
+	if tag == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header required"})
+		return 0, false
+	}
+	version, ok := utils.ParseETag(tag)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid If-Match header"})
+		return 0, false
+	}
+	return version, true
+}
+
+// respondStaleTask answers a write made against an old version with 412 and
+// the task as it is now, so the client can show what changed.
+func respondStaleTask(c *gin.Context, taskID int64) {
 	task, err := GetTaskByID(c.Request.Context(), taskID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
 			return
 		}
@@ -230,7 +649,161 @@ func handleGetTaskByID(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, task)
+	c.Header("ETag", utils.ETag(task.Version))
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":   errVersionMismatch.Error(),
+		"current": task,
+	})
+}
+
+// confirmCloseWithOpenSubtasks asks for ?confirm=true before a task with open
+// subtasks is moved to the terminal status. On refusal the 409 is already written.
+func confirmCloseWithOpenSubtasks(c *gin.Context, task *Task, to string) bool {
+	if code, body := openSubtasksResponse(c, task, to); code != 0 {
+		c.JSON(code, body)
+		return false
+	}
+	return true
+}
+
+// openSubtasksResponse is the refusal of confirmCloseWithOpenSubtasks, code is 0 when the move may go on.
+func openSubtasksResponse(c *gin.Context, task *Task, to string) (int, gin.H) {
+	if task.Status == to {
+		return 0, nil
+	}
+	if ok, _ := strconv.ParseBool(c.Query("confirm")); ok {
+		return 0, nil
+	}
+
+	wf, err := TeamWorkflow(c.Request.Context(), task.TeamID)
+	if err != nil {
+		log.Printf("failed to load workflow: %v", err)
+		return http.StatusInternalServerError, gin.H{"error": "db error"}
+	}
+	if to != wf.Terminal() {
+		return 0, nil
+	}
+
+	open, err := CountOpenSubtasks(c.Request.Context(), task.TaskID, wf.Terminal())
+	if err != nil {
+		log.Printf("failed to count subtasks: %v", err)
+		return http.StatusInternalServerError, gin.H{"error": "db error"}
+	}
+	if open == 0 {
+		return 0, nil
+	}
+
+	return http.StatusConflict, gin.H{
+		"error":              fmt.Sprintf("task has %d open subtasks", open),
+		"open_subtasks":      open,
+		"needs_confirmation": true,
+	}
+}
+
+// checkAssignees refuses assignees who are not members of the team.
+// On failure the response is already written.
+func checkAssignees(c *gin.Context, teamID int64, assignees []string) bool {
+	if code, body := assigneesResponse(c, teamID, assignees); code != 0 {
+		c.JSON(code, body)
+		return false
+	}
+	return true
+}
+
+// assigneesResponse is the refusal of checkAssignees, code is 0 when they are
+// all members. The 422 names the field and the invalid usernames so the front
+// can show them next to the input.
+func assigneesResponse(c *gin.Context, teamID int64, assignees []string) (int, gin.H) {
+	if len(assignees) == 0 {
+		return 0, nil
+	}
+
+	outsiders, err := NonMembers(c.Request.Context(), teamID, assignees)
+	if err != nil {
+		log.Printf("failed to check team members: %v", err)
+		return http.StatusInternalServerError, gin.H{"error": "db error"}
+	}
+	if len(outsiders) == 0 {
+		return 0, nil
+	}
+
+	return http.StatusUnprocessableEntity, gin.H{
+		"error":   "assignees must be members of the team: " + strings.Join(outsiders, ", "),
+		"field":   "assignees",
+		"invalid": outsiders,
+	}
+}
+
+func handleSearch(c *gin.Context) {
+	username, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q required"})
+		return
+	}
+	if len(q) > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q too long"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad limit"})
+		return
+	}
+
+	items, err := SearchTasks(c.Request.Context(), username, isAdmin(c), q, limit)
+	if err != nil {
+		log.Printf("failed to search: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": items,
+		"q":     q,
+		"limit": normalizeLimit(limit),
+	})
+}
+
+func handleTaskHistory(c *gin.Context) {
+	idStr := strings.TrimSpace(c.Param("id"))
+	taskID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "200"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad limit"})
+		return
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 200
+	}
+
+	if _, ok := loadTaskFor(c, taskID, ensureCanViewTeam); !ok {
+		return
+	}
+
+	items, err := ListTaskEvents(c.Request.Context(), taskID, limit)
+	if err != nil {
+		log.Printf("failed to list task events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":  items,
+		"taskid": taskID,
+		"limit":  limit,
+	})
 }
 
 func handleCommentList(c *gin.Context) {
@@ -263,17 +836,157 @@ func handleCommentList(c *gin.Context) {
 		return
 	}
 
-	items, err := ListCommentsByTaskID(c.Request.Context(), taskID, limit, order)
+	task, ok := loadTaskFor(c, taskID, ensureCanViewTeam)
+	if !ok {
+		return
+	}
+
+	items, next, err := ListCommentsByTaskID(c.Request.Context(), taskID, limit, order, c.Query("cursor"))
 	if err != nil {
+		if errors.Is(err, errBadCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad cursor"})
+			return
+		}
 		log.Printf("failed to list comments: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"items":  items,
-		"taskid": taskID,
-		"limit":  limit,
-		"order":  order,
+		"items":       items,
+		"taskid":      taskID,
+		"limit":       limit,
+		"order":       order,
+		"next_cursor": next,
+		// leaders and admins may edit or delete any comment of the task
+		"can_moderate": ensureCanManageTeam(c, task.TeamID) == nil,
 	})
 }
+
+func handleChecklistCreate(c *gin.Context) {
+	var req CreateChecklistItemRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	task, ok := loadTaskFor(c, req.TaskID, ensureCanViewTeam)
+	if !ok {
+		return
+	}
+	if err := ensureCanWorkOnTask(c, task); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	id, err := CreateChecklistItem(c.Request.Context(), req.TaskID, strings.TrimSpace(req.Body))
+	if err != nil {
+		log.Printf("failed to create checklist item: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"status": "ok", "itemid": id})
+}
+
+// loadChecklistItemFor fetches the item and checks that the caller may work on its task.
+// On failure the response is already written and ok is false.
+func loadChecklistItemFor(c *gin.Context) (*ChecklistItem, bool) {
+	itemID, err := strconv.ParseInt(c.Query("itemid"), 10, 64)
+	if err != nil || itemID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "itemid required"})
+		return nil, false
+	}
+
+	item, err := GetChecklistItem(c.Request.Context(), itemID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "checklist item not found"})
+			return nil, false
+		}
+		log.Printf("failed to get checklist item: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return nil, false
+	}
+
+	task, ok := loadTaskFor(c, item.TaskID, ensureCanViewTeam)
+	if !ok {
+		return nil, false
+	}
+	if err := ensureCanWorkOnTask(c, task); err != nil {
+		respondAuthzError(c, err)
+		return nil, false
+	}
+	return item, true
+}
+
+func handleChecklistUpdate(c *gin.Context) {
+	var req UpdateChecklistItemRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	item, ok := loadChecklistItemFor(c)
+	if !ok {
+		return
+	}
+
+	err := UpdateChecklistItem(c.Request.Context(), item.ItemID, req)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "checklist item not found"})
+			return
+		}
+		if strings.Contains(err.Error(), "no fields") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "provide body and/or done"})
+			return
+		}
+		log.Printf("failed to update checklist item: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func handleChecklistDelete(c *gin.Context) {
+	item, ok := loadChecklistItemFor(c)
+	if !ok {
+		return
+	}
+
+	if err := DeleteChecklistItem(c.Request.Context(), item.ItemID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "checklist item not found"})
+			return
+		}
+		log.Printf("failed to delete checklist item: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleTaskWatch adds (POST) or removes (DELETE) the caller from the task's
+// watchers. Anyone who sees the task may watch it.
+func handleTaskWatch(c *gin.Context) {
+	taskID, ok := taskIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := loadTaskFor(c, taskID, ensureCanViewTeam); !ok {
+		return
+	}
+
+	username, _ := mustUsername(c)
+	watchers, err := SetTaskWatch(c.Request.Context(), taskID, username, c.Request.Method != http.MethodDelete)
+	if err != nil {
+		log.Printf("failed to update watchers: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "watchers": watchers})
+}
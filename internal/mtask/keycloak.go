@@ -0,0 +1,90 @@
+package mtask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	auth "kyri56xcaesar/pms-proj/internal/authmw"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Emails go to the address of the kc.email claim the user last called with,
+// or else the one Keycloak has for them. Both are kept for addressTTL.
+
+const addressTTL = time.Hour
+
+var kcService *auth.Service
+
+func mustInitKcService() {
+	var err error
+	kcService, err = auth.NewService(
+		config.AuthAddress,
+		config.Realm,
+		config.ClientID,
+		config.Issuer,
+		config.Audience,
+		config.ClientSecret,
+	)
+	if err != nil {
+		log.Fatalf("failed to connect to KC: %v", err)
+	}
+}
+
+var errNoAddress = errors.New("no email address")
+
+type knownAddress struct {
+	email string
+	at    time.Time
+}
+
+var addresses = struct {
+	sync.Mutex
+	m map[string]knownAddress
+}{m: make(map[string]knownAddress)}
+
+func rememberAddress(username, email string) {
+	addresses.Lock()
+	defer addresses.Unlock()
+	addresses.m[username] = knownAddress{email: email, at: time.Now()}
+}
+
+// rememberEmail keeps the caller's kc.email claim as their address.
+func rememberEmail(c *gin.Context) {
+	if username, email := c.GetString("kc.username"), c.GetString("kc.email"); username != "" && email != "" {
+		rememberAddress(username, email)
+	}
+	c.Next()
+}
+
+// emailAddress returns the user's address, errNoAddress when they have none.
+func emailAddress(ctx context.Context, username string) (string, error) {
+	addresses.Lock()
+	known, ok := addresses.m[username]
+	addresses.Unlock()
+	if ok && time.Since(known.at) < addressTTL {
+		return known.email, nil
+	}
+
+	jwt, err := kcService.LoginAdmin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("login admin: %w", err)
+	}
+	u, err := kcService.GetUserByUsername(ctx, jwt.AccessToken, username)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return "", errNoAddress
+		}
+		return "", err
+	}
+	if u.Email == nil || *u.Email == "" {
+		return "", errNoAddress
+	}
+
+	rememberAddress(username, *u.Email)
+	return *u.Email, nil
+}
@@ -0,0 +1,137 @@
+package mtask
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is one HTML email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	HTML    string
+}
+
+// Mailer sends emails, see SMTPMailer. Send must give up once ctx is done.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer sends through an SMTP server. It upgrades the connection with
+// STARTTLS when the server offers it and authenticates when Username is set,
+// so a plain local server (e.g. a fake one in tests) works as well.
+type SMTPMailer struct {
+	Addr     string // host:port
+	From     string // "Name <address>" or an address
+	Username string
+	Password string
+	// bounds a whole Send when ctx has no earlier deadline
+	Timeout time.Duration
+}
+
+func NewSMTPMailer(addr, from, username, password string, timeout time.Duration) *SMTPMailer {
+	return &SMTPMailer{Addr: addr, From: from, Username: username, Password: password, Timeout: timeout}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return fmt.Errorf("smtp address: %w", err)
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("sender address: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("recipient address: %w", err)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	// net/smtp knows no contexts, the deadline bounds every exchange instead
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if m.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server does not support AUTH")
+		}
+		if err := c.Auth(smtp.PlainAuth("", m.Username, m.Password, host)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(compose(from, to, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// compose renders msg as a MIME message with a quoted-printable HTML body.
+func compose(from, to *mail.Address, msg Message) []byte {
+	// no header injection through the subject
+	oneLine := strings.NewReplacer("\r", "", "\n", "").Replace
+
+	var b bytes.Buffer
+	header := func(k, v string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, oneLine(v))
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", oneLine(msg.Subject)))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/html; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(msg.HTML))
+	qp.Close()
+	return b.Bytes()
+}
@@ -0,0 +1,168 @@
+package mtask
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a plain SMTP server that accepts one message and records the
+// envelope and the data it was sent.
+type fakeSMTP struct {
+	addr string
+	done chan struct{}
+
+	from string
+	rcpt []string
+	data string
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTP{addr: ln.Addr().String(), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(textproto.NewConn(conn))
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(c *textproto.Conn) {
+	c.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			c.PrintfLine("250 fake")
+		case "MAIL":
+			s.from = arg
+			c.PrintfLine("250 ok")
+		case "RCPT":
+			s.rcpt = append(s.rcpt, arg)
+			c.PrintfLine("250 ok")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			b, err := io.ReadAll(c.DotReader())
+			if err != nil {
+				return
+			}
+			s.data = string(b)
+			c.PrintfLine("250 queued")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	srv := startFakeSMTP(t)
+
+	body := `<p style="color:#000">` + strings.Repeat("Ünïcode and = signs ", 10) + `</p>`
+	msg := Message{
+		To:      "Al <al@example.org>",
+		Subject: "Fix «login»\r\nBcc: eve@example.org",
+		HTML:    body,
+	}
+	m := NewSMTPMailer(srv.addr, "TaskBoard <noreply@example.org>", "", "", 5*time.Second)
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-srv.done
+
+	if srv.from != "FROM:<noreply@example.org>" {
+		t.Errorf("MAIL %q, want the bare sender address", srv.from)
+	}
+	if len(srv.rcpt) != 1 || srv.rcpt[0] != "TO:<al@example.org>" {
+		t.Errorf("RCPT %q, want only the bare recipient address", srv.rcpt)
+	}
+
+	header, rawBody, ok := strings.Cut(srv.data, "\n\n")
+	if !ok {
+		t.Fatalf("no header/body separator in %q", srv.data)
+	}
+	headers := map[string]string{}
+	for _, line := range strings.Split(header, "\n") {
+		k, v, _ := strings.Cut(line, ": ")
+		headers[k] = v
+	}
+
+	if _, ok := headers["Bcc"]; ok {
+		t.Errorf("the subject injected a Bcc header")
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(headers["Subject"])
+	if err != nil {
+		t.Fatalf("decode subject %q: %v", headers["Subject"], err)
+	}
+	if want := "Fix «login»Bcc: eve@example.org"; subject != want {
+		t.Errorf("subject %q, want %q", subject, want)
+	}
+	if !strings.HasPrefix(headers["Subject"], "=?utf-8?q?") {
+		t.Errorf("subject %q is not Q-encoded", headers["Subject"])
+	}
+	if headers["Content-Transfer-Encoding"] != "quoted-printable" {
+		t.Errorf("Content-Transfer-Encoding %q", headers["Content-Transfer-Encoding"])
+	}
+
+	for _, line := range strings.Split(rawBody, "\n") {
+		if len(line) > 76 {
+			t.Errorf("body line longer than 76 characters: %q", line)
+		}
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(rawBody)))
+	if err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if strings.TrimRight(string(decoded), "\r\n") != body {
+		t.Errorf("body %q, want %q", decoded, body)
+	}
+}
+
+func TestSMTPMailerSendTimeout(t *testing.T) {
+	// a server that never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			bufio.NewReader(conn).ReadString('\n')
+			conn.Close()
+		}
+	}()
+
+	m := NewSMTPMailer(ln.Addr().String(), "noreply@example.org", "", "", 200*time.Millisecond)
+	start := time.Now()
+	if err := m.Send(context.Background(), Message{To: "al@example.org", Subject: "s", HTML: "b"}); err == nil {
+		t.Fatal("Send to a silent server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send gave up after %s, want about the timeout", elapsed)
+	}
+}
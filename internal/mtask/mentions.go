@@ -0,0 +1,63 @@
+package mtask
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mentions
+//
+// Writing @username in a task's description or in a comment mentions that
+// user. The text is parsed whenever it is saved, the usernames that are members
+// of the task's team are stored in task_mentions (others are left as text) and
+// editing the text drops the mentions it no longer has. Users mentioning
+// themselves are not recorded. Every user reads the mentions of them, latest
+// first, in their inbox.
+
+// mentionPattern matches @username at the start of the text or after a
+// character that cannot be part of an address, so a@b.c is no mention.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.-])@([A-Za-z0-9][\w.-]*)`)
+
+// parseMentions returns the usernames mentioned in text, lowercased (as
+// Keycloak keeps them), sorted and without duplicates. It is never nil.
+func parseMentions(text string) []string {
+	out := []string{}
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		// a sentence may end right after the username
+		name := strings.TrimRight(m[1], ".-")
+		if name != "" {
+			out = append(out, strings.ToLower(name))
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// handleMentionList returns the caller's mentions, latest first.
+func handleMentionList(c *gin.Context) {
+	username, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad limit"})
+		return
+	}
+
+	items, err := ListMentions(c.Request.Context(), username, normalizeLimit(limit))
+	if err != nil {
+		log.Printf("failed to list mentions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
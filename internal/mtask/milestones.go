@@ -0,0 +1,332 @@
+package mtask
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// milestones
+//
+// A milestone (sprint) groups tasks of a team over a date range. It starts
+// planned, its leader starts it, making it the team's single active one, and
+// closes it. Closing rolls the tasks not in the team's terminal status into the
+// next milestone. Members see the milestones with their progress and burndown.
+//
+// Moving a task between milestones is recorded in its history as "#id", the
+// burndown replays those events to know what was in the milestone on each day.
+
+func handleMilestoneList(c *gin.Context) {
+	teamID, err := strconv.ParseInt(c.Query("teamid"), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "teamid required"})
+		return
+	}
+	if err := ensureCanViewTeam(c, teamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	wf, err := TeamWorkflow(c.Request.Context(), teamID)
+	if err != nil {
+		log.Printf("failed to load workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	items, err := ListMilestones(c.Request.Context(), teamID, wf.Terminal())
+	if err != nil {
+		log.Printf("failed to list milestones: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// handleMilestoneGet returns the milestone with its tasks and burndown.
+func handleMilestoneGet(c *gin.Context) {
+	m, wf, ok := loadMilestoneFor(c, ensureCanViewTeam)
+	if !ok {
+		return
+	}
+
+	tasks, err := ListMilestoneTasks(c.Request.Context(), m.MilestoneID)
+	if err != nil {
+		log.Printf("failed to list milestone tasks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	histories, err := MilestoneTaskHistories(c.Request.Context(), m.MilestoneID)
+	if err != nil {
+		log.Printf("failed to load milestone history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"milestone": m,
+		"tasks":     tasks,
+		"burndown":  burndown(m, histories, wf.Terminal(), time.Now()),
+	})
+}
+
+func handleMilestoneCreate(c *gin.Context) {
+	var req MilestoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TeamID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	if err := ensureCanManageTeam(c, req.TeamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+	saveMilestone(c, 0, req)
+}
+
+func handleMilestoneUpdate(c *gin.Context) {
+	var req MilestoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	m, _, ok := loadMilestoneFor(c, ensureCanManageTeam)
+	if !ok {
+		return
+	}
+	if m.State == "closed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "milestone is closed"})
+		return
+	}
+	req.TeamID = m.TeamID
+	saveMilestone(c, m.MilestoneID, req)
+}
+
+func saveMilestone(c *gin.Context, milestoneID int64, req MilestoneRequest) {
+	req.StartsOn = req.StartsOn.UTC().Truncate(24 * time.Hour)
+	req.EndsOn = req.EndsOn.UTC().Truncate(24 * time.Hour)
+	if req.EndsOn.Before(req.StartsOn) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "milestone ends before it starts"})
+		return
+	}
+
+	actor, _ := mustUsername(c)
+	id, err := SaveMilestone(c.Request.Context(), milestoneID, actor, req)
+	if err != nil {
+		if errors.Is(err, errMilestoneExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("failed to save milestone: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	status := http.StatusOK
+	if milestoneID == 0 {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{"status": "ok", "milestoneid": id})
+}
+
+func handleMilestoneStart(c *gin.Context) {
+	m, _, ok := loadMilestoneFor(c, ensureCanManageTeam)
+	if !ok {
+		return
+	}
+
+	if err := StartMilestone(c.Request.Context(), m.MilestoneID); err != nil {
+		respondMilestoneError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func handleMilestoneClose(c *gin.Context) {
+	var req MilestoneCloseRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+			return
+		}
+	}
+	m, wf, ok := loadMilestoneFor(c, ensureCanManageTeam)
+	if !ok {
+		return
+	}
+
+	actor, _ := mustUsername(c)
+	moved, next, err := CloseMilestone(c.Request.Context(), m.MilestoneID, wf.Terminal(), actor, req.NextMilestoneID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "next milestone not found"})
+			return
+		}
+		respondMilestoneError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "moved": moved, "next_milestoneid": next})
+}
+
+func handleMilestoneDelete(c *gin.Context) {
+	m, _, ok := loadMilestoneFor(c, ensureCanManageTeam)
+	if !ok {
+		return
+	}
+
+	if err := DeleteMilestone(c.Request.Context(), m.MilestoneID); err != nil {
+		respondMilestoneError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// loadMilestoneFor fetches the :id milestone and its team's workflow and checks
+// the caller against its team. On failure the response is already written.
+func loadMilestoneFor(c *gin.Context, check func(*gin.Context, int64) error) (*Milestone, Workflow, bool) {
+	milestoneID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || milestoneID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid milestone id"})
+		return nil, nil, false
+	}
+
+	teamID, _, err := milestoneTeam(c.Request.Context(), pool, milestoneID)
+	if err != nil {
+		respondMilestoneError(c, err)
+		return nil, nil, false
+	}
+	if err := check(c, teamID); err != nil {
+		respondAuthzError(c, err)
+		return nil, nil, false
+	}
+
+	wf, err := TeamWorkflow(c.Request.Context(), teamID)
+	if err != nil {
+		log.Printf("failed to load workflow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return nil, nil, false
+	}
+	m, err := GetMilestone(c.Request.Context(), milestoneID, wf.Terminal())
+	if err != nil {
+		respondMilestoneError(c, err)
+		return nil, nil, false
+	}
+	return m, wf, true
+}
+
+// checkTaskMilestone tells whether a task of the team may be put in the
+// milestone: one of the team that is not closed. 0 (no milestone) always may.
+// On failure the 400 is already written.
+func checkTaskMilestone(c *gin.Context, teamID int64, milestoneID *int64) bool {
+	if milestoneID == nil || *milestoneID == 0 {
+		return true
+	}
+
+	mTeam, state, err := milestoneTeam(c.Request.Context(), pool, *milestoneID)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "milestone not found"})
+		return false
+	case err != nil:
+		log.Printf("failed to get milestone: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return false
+	case mTeam != teamID:
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMilestoneTeam.Error()})
+		return false
+	case state == "closed":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "milestone is closed"})
+		return false
+	}
+	return true
+}
+
+func respondMilestoneError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "milestone not found"})
+	case errors.Is(err, errMilestoneActive), errors.Is(err, errMilestoneState):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errMilestoneTeam):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Printf("milestone: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+	}
+}
+
+// burndown counts for each day of the milestone the tasks in it and not in the
+// terminal status at the end of that day. Days after now (or after closing)
+// have no Remaining; Ideal falls from the first day's count to 0 on the last.
+func burndown(m *Milestone, histories []milestoneTaskHistory, terminal string, now time.Time) []BurndownPoint {
+	tag := formatMilestone(&m.MilestoneID)
+	last := now
+	if m.ClosedAt != nil {
+		last = *m.ClosedAt
+	}
+
+	days := int(m.EndsOn.Sub(m.StartsOn).Hours()/24) + 1
+	out := make([]BurndownPoint, 0, days)
+	for i := 0; i < days; i++ {
+		day := m.StartsOn.AddDate(0, 0, i)
+		p := BurndownPoint{Day: day}
+
+		if !day.After(last) {
+			end := day.AddDate(0, 0, 1)
+			n := 0
+			for _, h := range histories {
+				if h.openInAt(tag, terminal, end) {
+					n++
+				}
+			}
+			p.Remaining = &n
+		}
+		out = append(out, p)
+	}
+
+	if len(out) > 0 && out[0].Remaining != nil {
+		scope := float64(*out[0].Remaining)
+		for i := range out {
+			if days > 1 {
+				out[i].Ideal = scope * float64(days-1-i) / float64(days-1)
+			}
+		}
+	}
+	return out
+}
+
+// openInAt replays the task's history up to t: it was in the milestone tagged
+// tag and not in the terminal status.
+func (h milestoneTaskHistory) openInAt(tag, terminal string, t time.Time) bool {
+	if h.CreatedAt.After(t) {
+		return false
+	}
+
+	// the values before the first recorded change, the current ones without changes
+	in, status := h.InNow, h.Status
+	seenIn, seenStatus := false, false
+	for _, ev := range h.Events {
+		switch {
+		case ev.Field == "milestone" && !seenIn:
+			in, seenIn = ev.OldValue == tag, true
+		case ev.Field == "status" && !seenStatus:
+			status, seenStatus = ev.OldValue, true
+		}
+	}
+
+	for _, ev := range h.Events {
+		if ev.CreatedAt.After(t) {
+			break
+		}
+		switch ev.Field {
+		case "milestone":
+			in = ev.NewValue == tag
+		case "status":
+			status = ev.NewValue
+		}
+	}
+	return in && status != terminal
+}
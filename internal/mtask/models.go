@@ -1,6 +1,13 @@
 package mtask
 
-import "time"
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"kyri56xcaesar/pms-proj/internal/utils"
+)
 
 type Task struct {
 	TaskID      int64     `json:"taskid"`
@@ -8,30 +15,101 @@ type Task struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	Author      string    `json:"author"`
-	Assignee    string    `json:"assignee"`
 	Status      string    `json:"status"`
 	Deadline    time.Time `json:"deadline"`
 	Priority    string    `json:"priority"`
 	CreatedAt   time.Time `json:"created_at"`
+	Version     int       `json:"version"`
+
+	// sorted usernames, see task_assignees and task_watchers
+	Assignees []string `json:"assignees"`
+	Watchers  []string `json:"watchers"`
+
+	ParentTaskID *int64  `json:"parent_taskid,omitempty"`
+	Labels       []Label `json:"labels"`
+	// the recurring task this one was created from, see recurrence.go
+	RecurrenceOf *int64 `json:"recurrence_of,omitempty"`
+	// planned effort, compared with the logged time entries
+	EstimateMinutes *int `json:"estimate_minutes,omitempty"`
+	// the sprint the task is planned in, see milestones.go
+	MilestoneID *int64 `json:"milestoneid,omitempty"`
+
+	// filled by GetTaskByID only
+	Subtasks   []Task          `json:"subtasks,omitempty"`
+	Checklist  []ChecklistItem `json:"checklist,omitempty"`
+	Progress   *TaskProgress   `json:"progress,omitempty"`
+	BlockedBy  []Task          `json:"blocked_by,omitempty"`
+	Blocks     []Task          `json:"blocks,omitempty"`
+	Recurrence *Recurrence     `json:"recurrence,omitempty"`
+	// minutes of the finished time entries
+	LoggedMinutes int `json:"logged_minutes,omitempty"`
+}
+
+// IsAssignee tells whether username is one of the task's assignees.
+func (t *Task) IsAssignee(username string) bool {
+	return username != "" && slices.Contains(t.Assignees, username)
+}
+
+// Label is one of the team's labels, see mteam.
+type Label struct {
+	LabelID int64  `json:"labelid"`
+	Name    string `json:"name"`
+	Color   string `json:"color"`
+}
+
+type ChecklistItem struct {
+	ItemID    int64     `json:"itemid"`
+	TaskID    int64     `json:"taskid"`
+	Body      string    `json:"body"`
+	Done      bool      `json:"done"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TaskProgress rolls subtasks (done = in the team's terminal status) and
+// checklist items up into one percentage.
+type TaskProgress struct {
+	SubtasksDone   int `json:"subtasks_done"`
+	SubtasksTotal  int `json:"subtasks_total"`
+	ChecklistDone  int `json:"checklist_done"`
+	ChecklistTotal int `json:"checklist_total"`
+	Percent        int `json:"percent"`
 }
 
 type CreateTaskRequest struct {
-	TeamID      int64      `json:"teamid" form:"teamid" binding:"required,gt=0"`
-	Title       string     `json:"title" form:"title" binding:"required,min=2,max=120"`
+	TeamID int64 `json:"teamid" form:"teamid" binding:"required,gt=0"`
+	// required unless the template gives a title pattern
+	Title       string     `json:"title" form:"title" binding:"omitempty,min=2,max=120"`
 	Description string     `json:"description" form:"description" binding:"max=2000"`
-	Assignee    string     `json:"assignee" form:"assignee" binding:"max=128"`
-	Status      string     `json:"status" form:"status" binding:"omitempty,oneof=TODO IN_PROGRESS DONE"`
+	Assignees   []string   `json:"assignees" form:"assignees" binding:"max=20,dive,min=1,max=128"`
+	Status      string     `json:"status" form:"status" binding:"omitempty,max=32"`
 	Deadline    *time.Time `json:"deadline" form:"deadline"`
 	Priority    string     `json:"priority" form:"priority" binding:"omitempty,oneof=LOW MEDIUM HIGH"`
+
+	ParentTaskID *int64  `json:"parent_taskid" form:"parent_taskid" binding:"omitempty,gt=0"`
+	LabelIDs     []int64 `json:"labelids" form:"labelids" binding:"max=20,dive,gt=0"`
+	// initial checklist items
+	Checklist []string `json:"checklist" form:"checklist" binding:"max=50,dive,min=1,max=300"`
+	// fills the fields left empty from a template of the team, see templates.go
+	TemplateID *int64 `json:"templateid" form:"templateid" binding:"omitempty,gt=0"`
+
+	EstimateMinutes *int   `json:"estimate_minutes" form:"estimate_minutes" binding:"omitempty,min=0,max=100000"`
+	MilestoneID     *int64 `json:"milestoneid" form:"milestoneid" binding:"omitempty,gt=0"`
 }
 
 type UpdateTaskRequest struct {
 	Title       *string    `json:"title" form:"title" binding:"omitempty,min=2,max=120"`
 	Description *string    `json:"description" form:"description" binding:"omitempty,max=2000"`
-	Assignee    *string    `json:"assignee" form:"assignee" binding:"omitempty,max=128"`
-	Status      *string    `json:"status" form:"status" binding:"omitempty,oneof=TODO IN_PROGRESS DONE"`
+	Status      *string    `json:"status" form:"status" binding:"omitempty,max=32"`
 	Deadline    *time.Time `json:"deadline" form:"deadline"`
 	Priority    *string    `json:"priority" form:"priority" binding:"omitempty,oneof=LOW MEDIUM HIGH"`
+	// replace all assignees or labels of the task when set, empty clears them
+	Assignees *[]string `json:"assignees" form:"assignees" binding:"omitempty,max=20,dive,min=1,max=128"`
+	LabelIDs  *[]int64  `json:"labelids" form:"labelids" binding:"omitempty,max=20,dive,gt=0"`
+	// 0 clears the estimate
+	EstimateMinutes *int `json:"estimate_minutes" form:"estimate_minutes" binding:"omitempty,min=0,max=100000"`
+	// 0 takes the task out of its milestone
+	MilestoneID *int64 `json:"milestoneid" form:"milestoneid" binding:"omitempty,min=0"`
 }
 
 func normalizeLimit(n int) int {
@@ -44,36 +122,455 @@ func normalizeLimit(n int) int {
 	return n
 }
 
-func taskOrderClause(order string) string {
+// taskKeyset describes how a task list order (created_desc, created_asc, deadline_asc,
+// deadline_desc, priority_desc) sorts and is paged with a cursor.
+// expr never yields NULL so it can be compared together with taskid as a row value.
+type taskKeyset struct {
+	expr string
+	cast string
+	desc bool
+	key  func(Task) string
+}
+
+func taskKeysetFor(order string) taskKeyset {
+	createdKey := func(t Task) string { return t.CreatedAt.Format(time.RFC3339Nano) }
+	deadlineKey := func(missing string) func(Task) string {
+		return func(t Task) string {
+			if t.Deadline.IsZero() {
+				return missing
+			}
+			return t.Deadline.Format(time.RFC3339Nano)
+		}
+	}
+
 	switch order {
 	case "created_asc":
-		return "created_at ASC"
+		return taskKeyset{expr: "created_at", cast: "timestamptz", key: createdKey}
 	case "deadline_asc":
-		return "deadline ASC NULLS LAST"
+		// same as deadline ASC NULLS LAST
+		return taskKeyset{
+			expr: "COALESCE(deadline, 'infinity'::timestamptz)",
+			cast: "timestamptz",
+			key:  deadlineKey("infinity"),
+		}
 	case "deadline_desc":
-		return "deadline DESC NULLS LAST"
+		// same as deadline DESC NULLS LAST
+		return taskKeyset{
+			expr: "COALESCE(deadline, '-infinity'::timestamptz)",
+			cast: "timestamptz",
+			desc: true,
+			key:  deadlineKey("-infinity"),
+		}
 	case "priority_desc":
-		return "priority DESC" // simplistic, better with enum later
+		return taskKeyset{
+			expr: "COALESCE(priority, '')",
+			cast: "text",
+			desc: true,
+			key:  func(t Task) string { return t.Priority },
+		}
 	case "created_desc":
 		fallthrough
 	default:
-		return "created_at DESC"
+		return taskKeyset{expr: "created_at", cast: "timestamptz", desc: true, key: createdKey}
 	}
 }
 
-type Comment struct {
-	CommentID int64     `json:"commentid"`
+func (k taskKeyset) orderBy() string {
+	if k.desc {
+		return fmt.Sprintf("%s DESC, taskid DESC", k.expr)
+	}
+	return fmt.Sprintf("%s ASC, taskid ASC", k.expr)
+}
+
+// after returns the condition selecting rows past the cursor bound to $keyArg and $idArg.
+func (k taskKeyset) after(keyArg, idArg int) string {
+	op := ">"
+	if k.desc {
+		op = "<"
+	}
+	return fmt.Sprintf("(%s, taskid) %s ($%d::text::%s, $%d)", k.expr, op, keyArg, k.cast, idArg)
+}
+
+// validKey tells whether a cursor key can be cast for expr.
+func (k taskKeyset) validKey(key string) bool {
+	return k.cast != "timestamptz" || utils.ValidCursorTime(key)
+}
+
+func (k taskKeyset) cursorFor(t Task) string {
+	return utils.EncodeCursor(utils.Cursor{Key: k.key(t), ID: t.TaskID})
+}
+
+// SearchHit is a task matching a search, either by its own text or by one of
+// its comments (Kind "comment", CommentID set).
+type SearchHit struct {
+	Kind      string    `json:"kind"` // task/comment
 	TaskID    int64     `json:"taskid"`
-	Author    string    `json:"author"`
-	Body      string    `json:"body"`
+	TeamID    int64     `json:"teamid"`
+	Title     string    `json:"title"`
+	Status    string    `json:"status"`
+	CommentID *int64    `json:"commentid,omitempty"`
+	Rank      float32   `json:"rank"`
+	Snippet   string    `json:"snippet"` // HTML escaped, matches wrapped in <mark>
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TaskEvent is one entry of a task's history. Updates record one event per changed field.
+type TaskEvent struct {
+	EventID   int64     `json:"eventid"`
+	TaskID    int64     `json:"taskid"`
+	TeamID    int64     `json:"teamid"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"` // created/updated/deleted
+	Field     string    `json:"field,omitempty"`
+	OldValue  string    `json:"old_value,omitempty"`
+	NewValue  string    `json:"new_value,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// WorkflowStatus is one status of a team's workflow, see mteam.
+type WorkflowStatus struct {
+	Name     string `json:"name"`
+	Terminal bool   `json:"terminal"`
+}
+
+type Workflow []WorkflowStatus
+
+// defaultWorkflow applies to teams that never defined their own.
+var defaultWorkflow = Workflow{
+	{Name: "TODO"},
+	{Name: "IN_PROGRESS"},
+	{Name: "DONE", Terminal: true},
+}
+
+func (w Workflow) Has(status string) bool {
+	for _, s := range w {
+		if s.Name == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Initial is the status new tasks start in.
+func (w Workflow) Initial() string {
+	return w[0].Name
+}
+
+func (w Workflow) Terminal() string {
+	for _, s := range w {
+		if s.Terminal {
+			return s.Name
+		}
+	}
+	return w[len(w)-1].Name
+}
+
+type Comment struct {
+	CommentID       int64      `json:"commentid"`
+	TaskID          int64      `json:"taskid"`
+	ParentCommentID *int64     `json:"parent_commentid,omitempty"`
+	Author          string     `json:"author"`
+	Body            string     `json:"body"`
+	CreatedAt       time.Time  `json:"created_at"`
+	EditedAt        *time.Time `json:"edited_at,omitempty"`
+	// DeletedAt is set on the tombstone of a deleted comment that has replies,
+	// its Body is blank
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Depth is the nesting level in a thread, 0 for top level comments
+	Depth int `json:"depth"`
+}
+
 type CreateCommentRequest struct {
+	TaskID          int64  `json:"taskid" form:"taskid" binding:"required,gt=0"`
+	ParentCommentID *int64 `json:"parent_commentid" form:"parent_commentid" binding:"omitempty,gt=0"`
+	Body            string `json:"body" form:"body" binding:"required,min=1,max=2000"`
+}
+
+type UpdateCommentRequest struct {
+	Body string `json:"body" form:"body" binding:"required,min=1,max=2000"`
+}
+
+// DependencyRequest links two tasks: BlockerID blocks BlockedID.
+type DependencyRequest struct {
+	BlockerID int64 `json:"blocker_taskid" form:"blocker_taskid" binding:"required,gt=0"`
+	BlockedID int64 `json:"blocked_taskid" form:"blocked_taskid" binding:"required,gt=0"`
+}
+
+// TaskTemplate presets new tasks of a team, see templates.go.
+type TaskTemplate struct {
+	TemplateID   int64    `json:"templateid"`
+	TeamID       int64    `json:"teamid"`
+	Name         string   `json:"name"`
+	TitlePattern string   `json:"title_pattern"`
+	Description  string   `json:"description"`
+	Priority     string   `json:"priority"`
+	Checklist    []string `json:"checklist"`
+	// the deadline of a new task is this many days after its creation
+	DeadlineDays *int      `json:"deadline_days,omitempty"`
+	CreatedBy    string    `json:"created_by"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Labels       []Label   `json:"labels"`
+}
+
+type TaskTemplateRequest struct {
+	TeamID       int64    `json:"teamid" binding:"omitempty,gt=0"` // on create only
+	Name         string   `json:"name" binding:"required,min=1,max=60"`
+	TitlePattern string   `json:"title_pattern" binding:"required,min=2,max=120"`
+	Description  string   `json:"description" binding:"max=2000"`
+	Priority     string   `json:"priority" binding:"omitempty,oneof=LOW MEDIUM HIGH"`
+	LabelIDs     []int64  `json:"labelids" binding:"max=20,dive,gt=0"`
+	Checklist    []string `json:"checklist" binding:"max=50,dive,min=1,max=300"`
+	DeadlineDays *int     `json:"deadline_days" binding:"omitempty,min=0,max=365"`
+}
+
+// Recurrence repeats its template task, see recurrence.go.
+type Recurrence struct {
+	TemplateTaskID int64  `json:"template_taskid"`
+	TeamID         int64  `json:"teamid"`
+	Freq           string `json:"freq"` // daily | weekly | monthly
+	Interval       int    `json:"interval"`
+	// MO..SU, weekly only; empty repeats on the weekday of StartsAt
+	Weekdays []string `json:"weekdays"`
+	State    string   `json:"state"` // active | paused | stopped
+	// the deadline the rule counts from, monthly rules keep its day of month
+	StartsAt time.Time `json:"starts_at"`
+	// deadline of the latest instance and of the next one
+	LastDue   time.Time `json:"last_due"`
+	NextDue   time.Time `json:"next_due"`
+	CreatedBy string    `json:"created_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type RecurrenceRequest struct {
+	Freq     string   `json:"freq" binding:"required,oneof=daily weekly monthly"`
+	Interval int      `json:"interval" binding:"omitempty,min=1,max=365"`
+	Weekdays []string `json:"weekdays" binding:"max=7"`
+}
+
+type RecurrenceStateRequest struct {
+	State string `json:"state" binding:"required,oneof=active paused stopped"`
+}
+
+// TrashedTask is a task in the trash, see trash.go.
+type TrashedTask struct {
+	TaskID    int64     `json:"taskid"`
+	TeamID    int64     `json:"teamid"`
+	TeamName  string    `json:"team_name"`
+	Title     string    `json:"title"`
+	Status    string    `json:"status"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by"`
+	// the team is in the trash as well, it has to be restored first
+	TeamTrashed bool `json:"team_trashed"`
+}
+
+// BulkTaskRequest applies one operation to a batch of tasks:
+// "assign" (Assignees, replacing the current ones, empty unassigns), "priority", "status" or "delete".
+type BulkTaskRequest struct {
+	TaskIDs   []int64   `json:"taskids" binding:"required,min=1,max=200,dive,gt=0"`
+	Op        string    `json:"op" binding:"required,oneof=assign priority status delete"`
+	Assignees *[]string `json:"assignees" binding:"omitempty,max=20,dive,min=1,max=128"`
+	Priority  *string   `json:"priority" binding:"omitempty,oneof=LOW MEDIUM HIGH"`
+	Status    *string   `json:"status" binding:"omitempty,max=32"`
+}
+
+// BulkResult is the outcome of a bulk operation for one task. Code and the
+// extra fields of Details follow the response the single-task endpoint gives.
+type BulkResult struct {
+	TaskID  int64          `json:"taskid"`
+	OK      bool           `json:"ok"`
+	Code    int            `json:"code"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type CreateChecklistItemRequest struct {
 	TaskID int64  `json:"taskid" form:"taskid" binding:"required,gt=0"`
-	Body   string `json:"body" form:"body" binding:"required,min=1,max=2000"`
+	Body   string `json:"body" form:"body" binding:"required,min=1,max=300"`
+}
+
+type UpdateChecklistItemRequest struct {
+	Body *string `json:"body" form:"body" binding:"omitempty,min=1,max=300"`
+	Done *bool   `json:"done" form:"done"`
+}
+
+var statusNameRe = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,31}$`)
+
+// validStatusName only checks the shape of a status; which statuses exist is
+// decided by the team's workflow.
+func validStatusName(status string) bool {
+	return statusNameRe.MatchString(status)
+}
+
+func validPriority(priority string) bool {
+	return priority == "LOW" || priority == "MEDIUM" || priority == "HIGH"
+}
+
+// TimeEntry is time a user spent on a task, see timetracking.go.
+// A running timer has no EndedAt and no Minutes yet.
+type TimeEntry struct {
+	EntryID   int64      `json:"entryid"`
+	TaskID    int64      `json:"taskid"`
+	Username  string     `json:"username"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Minutes   *int       `json:"minutes,omitempty"`
+	Note      string     `json:"note"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TimerStartRequest starts the caller's timer on a task; Switch stops a timer
+// running on another task first.
+type TimerStartRequest struct {
+	Note   string `json:"note" binding:"max=500"`
+	Switch bool   `json:"switch"`
+}
+
+type TimerStopRequest struct {
+	// replaces the note given on start when set
+	Note *string `json:"note" binding:"omitempty,max=500"`
+}
+
+// TimeLogRequest records time spent without a timer, it ends at StartedAt + Minutes.
+type TimeLogRequest struct {
+	Minutes   int        `json:"minutes" binding:"required,min=1,max=1440"`
+	StartedAt *time.Time `json:"started_at"`
+	Note      string     `json:"note" binding:"max=500"`
+}
+
+// TimeReport sums logged time over [From, To) for a team or a user. Running
+// timers count up to now.
+type TimeReport struct {
+	From         time.Time        `json:"from"`
+	To           time.Time        `json:"to"`
+	TeamID       int64            `json:"teamid,omitempty"`
+	Username     string           `json:"username,omitempty"`
+	TotalMinutes int              `json:"total_minutes"`
+	ByUser       []TimeReportUser `json:"by_user"`
+	ByTask       []TimeReportTask `json:"by_task"`
+}
+
+type TimeReportUser struct {
+	Username string `json:"username"`
+	Minutes  int    `json:"minutes"`
+}
+
+type TimeReportTask struct {
+	TaskID          int64  `json:"taskid"`
+	TeamID          int64  `json:"teamid"`
+	Title           string `json:"title"`
+	Status          string `json:"status"`
+	EstimateMinutes *int   `json:"estimate_minutes,omitempty"`
+	// logged within the range
+	Minutes int `json:"minutes"`
+	// logged over the task's life, to compare with the estimate
+	TotalMinutes int `json:"total_minutes"`
+}
+
+// Milestone is a sprint of a team: planned, then active (one per team at a
+// time), then closed. See milestones.go.
+type Milestone struct {
+	MilestoneID int64      `json:"milestoneid"`
+	TeamID      int64      `json:"teamid"`
+	Name        string     `json:"name"`
+	StartsOn    time.Time  `json:"starts_on"`
+	EndsOn      time.Time  `json:"ends_on"`
+	State       string     `json:"state"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ClosedAt    *time.Time `json:"closed_at,omitempty"`
+
+	// live tasks in the milestone, and those of them in the terminal status
+	TotalTasks int `json:"total_tasks"`
+	DoneTasks  int `json:"done_tasks"`
+}
+
+type MilestoneRequest struct {
+	TeamID   int64     `json:"teamid" binding:"omitempty,gt=0"` // on create only
+	Name     string    `json:"name" binding:"required,min=1,max=60"`
+	StartsOn time.Time `json:"starts_on" binding:"required"`
+	EndsOn   time.Time `json:"ends_on" binding:"required"`
+}
+
+// MilestoneCloseRequest closes a milestone; its unfinished tasks move to
+// NextMilestoneID, else to the team's next planned milestone, else out of any.
+type MilestoneCloseRequest struct {
+	NextMilestoneID *int64 `json:"next_milestoneid" binding:"omitempty,gt=0"`
+}
+
+// BurndownPoint is the number of tasks of a milestone still open at the end
+// of Day, null for days to come, next to the ideal straight line to zero on
+// its last day.
+type BurndownPoint struct {
+	Day       time.Time `json:"day"`
+	Remaining *int      `json:"remaining"`
+	Ideal     float64   `json:"ideal"`
+}
+
+// Mention is a team member named with @username in a task's description or,
+// with CommentID, in one of its comments. Excerpt is that text.
+type Mention struct {
+	MentionID int64     `json:"mentionid"`
+	TaskID    int64     `json:"taskid"`
+	TeamID    int64     `json:"teamid"`
+	TaskTitle string    `json:"task_title"`
+	CommentID *int64    `json:"commentid,omitempty"`
+	Username  string    `json:"username"`
+	Author    string    `json:"author"`
+	Excerpt   string    `json:"excerpt"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Notification tells Username that Actor did something to a task they work on
+// or follow, see the notify* kinds. Detail depends on the kind: the status
+// change ("TODO → DONE") or the start of the comment or mentioning text.
+type Notification struct {
+	NotificationID int64      `json:"notificationid"`
+	Username       string     `json:"username"`
+	Kind           string     `json:"kind"`
+	TaskID         int64      `json:"taskid"`
+	TeamID         int64      `json:"teamid"`
+	TaskTitle      string     `json:"task_title"`
+	Actor          string     `json:"actor"`
+	Detail         string     `json:"detail"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ReadAt         *time.Time `json:"read_at,omitempty"`
+}
+
+// EmailPreferences are the notification emails a user wants, all by default.
+type EmailPreferences struct {
+	Assigned bool `json:"assigned"`
+	Deadline bool `json:"deadline"`
+	Mention  bool `json:"mention"`
+
+	// whether this mtask sends emails at all, not stored
+	Enabled bool `json:"enabled"`
+}
+
+// Wants tells whether the user gets emails of the notification kind.
+func (p EmailPreferences) Wants(kind string) bool {
+	switch kind {
+	case notifyAssigned:
+		return p.Assigned
+	case notifyDeadline:
+		return p.Deadline
+	case notifyMention:
+		return p.Mention
+	}
+	return false
+}
+
+// EmailPreferencesRequest changes the fields it sets.
+type EmailPreferencesRequest struct {
+	Assigned *bool `json:"assigned" form:"assigned"`
+	Deadline *bool `json:"deadline" form:"deadline"`
+	Mention  *bool `json:"mention" form:"mention"`
 }
 
-func validStatus(status string) bool {
-	return status == "IN_PROGRESS" || status == "TODO" || status == "DONE"
+// PendingEmail is a notification claimed for emailing, Attempts counts this one.
+type PendingEmail struct {
+	Notification
+	Attempts int
 }
@@ -0,0 +1,101 @@
+package mtask
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notifications
+//
+// Users are told in-app what others did to the tasks they work on or follow.
+// The notifications are recorded in the transaction of the change itself:
+// CreateTask and updateTask tell new assignees they were assigned and the
+// watchers of a status change, CreateComment tells the watchers and assignees
+// of a comment, and any text naming a member tells them of the mention. Nobody
+// is told of their own doing. The deadline reminders of email.go come from
+// nobody. A notification stays unread until its user marks it read.
+
+const (
+	notifyAssigned = "assigned"
+	notifyStatus   = "status"
+	notifyComment  = "comment"
+	notifyMention  = "mention"
+	notifyDeadline = "deadline"
+)
+
+// handleNotificationList returns the caller's notifications, latest first,
+// only the unread ones with ?unread=true, and their unread count.
+func handleNotificationList(c *gin.Context) {
+	username, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad limit"})
+		return
+	}
+	unread := c.Query("unread") == "true"
+
+	items, n, err := ListNotifications(c.Request.Context(), username, unread, normalizeLimit(limit))
+	if err != nil {
+		log.Printf("failed to list notifications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "unread": n})
+}
+
+// handleNotificationCount returns how many notifications the caller has not
+// read, polled by the front's nav.
+func handleNotificationCount(c *gin.Context) {
+	username, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	n, err := CountUnreadNotifications(c.Request.Context(), username)
+	if err != nil {
+		log.Printf("failed to count notifications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": n})
+}
+
+func handleNotificationRead(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid notification id"})
+		return
+	}
+	markNotificationsRead(c, []int64{id})
+}
+
+func handleNotificationReadAll(c *gin.Context) {
+	markNotificationsRead(c, []int64{})
+}
+
+// markNotificationsRead marks the caller's notifications of ids read, all of
+// them when ids is empty. Marking one again is no error.
+func markNotificationsRead(c *gin.Context, ids []int64) {
+	username, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	marked, err := MarkNotificationsRead(c.Request.Context(), username, ids)
+	if err != nil {
+		log.Printf("failed to mark notifications read: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "marked": marked})
+}
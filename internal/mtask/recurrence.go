@@ -0,0 +1,289 @@
+package mtask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// recurring tasks
+//
+// A leader turns a task into the template of a recurrence: daily, weekly on
+// given weekdays or monthly, every Interval days, weeks or months. Instances
+// are copies of the template (labels and an unchecked checklist included)
+// linked back by recurrence_of, each due on the next occurrence of the rule.
+// The scheduler creates the next instance once the latest one is finished in
+// the team's workflow or its deadline has come, so there is always one open
+// instance ahead. Occurrences missed while paused or down are skipped, not
+// backfilled. Paused recurrences can be resumed, stopped ones only replaced.
+
+// recurrenceActor is the actor recorded in the history of the instances.
+const recurrenceActor = "recurrence"
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// recurrenceWake makes the scheduler look at the recurrences before its next
+// tick, e.g. right after an instance was closed.
+var recurrenceWake = make(chan struct{}, 1)
+
+func wakeRecurrences() {
+	select {
+	case recurrenceWake <- struct{}{}:
+	default:
+	}
+}
+
+// normalizeRecurrence upper-cases, de-duplicates and orders the weekdays
+// (Monday first) and defaults the interval to 1.
+func normalizeRecurrence(req RecurrenceRequest) (Recurrence, error) {
+	rec := Recurrence{Freq: req.Freq, Interval: req.Interval, Weekdays: []string{}}
+	if rec.Interval == 0 {
+		rec.Interval = 1
+	}
+	if len(req.Weekdays) > 0 && req.Freq != "weekly" {
+		return rec, fmt.Errorf("weekdays apply to weekly recurrences only")
+	}
+
+	seen := make(map[string]bool, len(req.Weekdays))
+	for _, d := range req.Weekdays {
+		d = strings.ToUpper(strings.TrimSpace(d))
+		if !slices.Contains(weekdayCodes, d) {
+			return rec, fmt.Errorf("invalid weekday %q, expected one of %s", d, strings.Join(weekdayCodes, ","))
+		}
+		seen[d] = true
+	}
+	for i := range weekdayCodes {
+		d := weekdayCodes[(i+1)%7]
+		if seen[d] {
+			rec.Weekdays = append(rec.Weekdays, d)
+		}
+	}
+	return rec, nil
+}
+
+// next returns the first occurrence of the rule after t.
+func (r Recurrence) next(t time.Time) time.Time {
+	interval := max(r.Interval, 1)
+
+	switch r.Freq {
+	case "daily":
+		return t.AddDate(0, 0, interval)
+	case "monthly":
+		// keep the day of month of StartsAt, clamped to short months
+		first := time.Date(t.Year(), t.Month()+time.Month(interval), 1,
+			t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+		day := min(r.StartsAt.Day(), first.AddDate(0, 1, -1).Day())
+		return first.AddDate(0, 0, day-1)
+	}
+
+	// weekly
+	if len(r.Weekdays) == 0 {
+		return t.AddDate(0, 0, 7*interval)
+	}
+	start := mondayOf(r.StartsAt)
+	for d := t.AddDate(0, 0, 1); ; d = d.AddDate(0, 0, 1) {
+		weeks := int(mondayOf(d).Sub(start).Round(24*time.Hour).Hours()) / (24 * 7)
+		if weeks%interval == 0 && slices.Contains(r.Weekdays, weekdayCodes[d.Weekday()]) {
+			return d
+		}
+	}
+}
+
+func mondayOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+}
+
+// String reads like "every 2 weeks on MO,TH (paused)".
+func (r Recurrence) String() string {
+	units := map[string]string{"daily": "day", "weekly": "week", "monthly": "month"}
+	s := "every " + units[r.Freq]
+	if r.Interval > 1 {
+		s = fmt.Sprintf("every %d %ss", r.Interval, units[r.Freq])
+	}
+	if len(r.Weekdays) > 0 {
+		s += " on " + strings.Join(r.Weekdays, ",")
+	}
+	if r.State != "" && r.State != "active" {
+		s += " (" + r.State + ")"
+	}
+	return s
+}
+
+// runRecurrences creates due instances every interval and on wakeRecurrences
+// until ctx is done.
+func runRecurrences(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		spawnDueInstances(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-recurrenceWake:
+		}
+	}
+}
+
+func spawnDueInstances(ctx context.Context) {
+	recs, err := ListActiveRecurrences(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("failed to list recurrences: %v", err)
+		}
+		return
+	}
+
+	now := time.Now()
+	today := now.UTC().Truncate(24 * time.Hour)
+	for _, rec := range recs {
+		wf, err := TeamWorkflow(ctx, rec.TeamID)
+		if err != nil {
+			log.Printf("failed to load workflow of team %d: %v", rec.TeamID, err)
+			continue
+		}
+
+		latest, err := LatestRecurrenceInstance(ctx, rec.TemplateTaskID)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+		case err != nil:
+			log.Printf("failed to load latest instance of task %d: %v", rec.TemplateTaskID, err)
+			continue
+		case latest.Status != wf.Terminal() && rec.LastDue.After(now):
+			// the open instance is not due yet
+			continue
+		}
+
+		due := rec.NextDue
+		for due.Before(today) {
+			due = rec.next(due)
+		}
+
+		id, err := SpawnRecurrenceInstance(ctx, rec, due, wf.Initial())
+		if err != nil {
+			log.Printf("failed to create instance of task %d: %v", rec.TemplateTaskID, err)
+			continue
+		}
+		if id != 0 {
+			log.Printf("created task %d from recurring task %d, due %s", id, rec.TemplateTaskID, due.Format("2006-01-02"))
+		}
+	}
+}
+
+func handleRecurrenceGet(c *gin.Context) {
+	taskID, ok := taskIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := loadTaskFor(c, taskID, ensureCanViewTeam); !ok {
+		return
+	}
+
+	rec, err := GetRecurrence(c.Request.Context(), taskID)
+	if err != nil {
+		respondRecurrenceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rec)
+}
+
+func handleRecurrenceSet(c *gin.Context) {
+	taskID, ok := taskIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req RecurrenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	rec, err := normalizeRecurrence(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, ok := loadTaskFor(c, taskID, ensureCanManageTeam)
+	if !ok {
+		return
+	}
+	if task.RecurrenceOf != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":         "task is an instance of a recurring task, change the recurrence there",
+			"recurrence_of": *task.RecurrenceOf,
+		})
+		return
+	}
+
+	actor, _ := mustUsername(c)
+	out, err := SetRecurrence(c.Request.Context(), taskID, actor, rec)
+	if err != nil {
+		respondRecurrenceError(c, err)
+		return
+	}
+
+	wakeRecurrences()
+	c.JSON(http.StatusOK, out)
+}
+
+func handleRecurrenceState(c *gin.Context) {
+	taskID, ok := taskIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req RecurrenceStateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state must be active, paused or stopped"})
+		return
+	}
+	if _, ok := loadTaskFor(c, taskID, ensureCanManageTeam); !ok {
+		return
+	}
+
+	actor, _ := mustUsername(c)
+	out, err := SetRecurrenceState(c.Request.Context(), taskID, actor, req.State)
+	if err != nil {
+		respondRecurrenceError(c, err)
+		return
+	}
+
+	if out.State == "active" {
+		wakeRecurrences()
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func taskIDParam(c *gin.Context) (int64, bool) {
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return 0, false
+	}
+	return taskID, true
+}
+
+func respondRecurrenceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "task has no recurrence"})
+	case errors.Is(err, errRecurrenceStopped):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("recurrence failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+	}
+}
@@ -0,0 +1,242 @@
+package mtask
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// task templates
+//
+// Leaders keep presets for the kinds of task their team creates again and
+// again. Creating a task with a templateid fills every field the request
+// leaves empty from the template: the title from its pattern, description,
+// priority, labels, checklist and a deadline deadline_days after today.
+// Members of the team can read the templates, its leader manages them.
+
+// titlePlaceholders are expanded in title patterns with the creation time.
+var titlePlaceholders = map[string]func(time.Time) string{
+	"{date}":  func(t time.Time) string { return t.Format("2006-01-02") },
+	"{month}": func(t time.Time) string { return t.Format("2006-01") },
+	"{week}": func(t time.Time) string {
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	},
+}
+
+func expandTitlePattern(pattern string, now time.Time) string {
+	pairs := make([]string, 0, 2*len(titlePlaceholders))
+	for k, f := range titlePlaceholders {
+		pairs = append(pairs, k, f(now))
+	}
+	return strings.NewReplacer(pairs...).Replace(pattern)
+}
+
+// applyTemplate fills the fields req leaves empty from tpl.
+func applyTemplate(req *CreateTaskRequest, tpl *TaskTemplate, now time.Time) {
+	if strings.TrimSpace(req.Title) == "" {
+		req.Title = expandTitlePattern(tpl.TitlePattern, now)
+	}
+	if strings.TrimSpace(req.Description) == "" {
+		req.Description = tpl.Description
+	}
+	if req.Priority == "" {
+		req.Priority = tpl.Priority
+	}
+	if len(req.LabelIDs) == 0 {
+		for _, l := range tpl.Labels {
+			req.LabelIDs = append(req.LabelIDs, l.LabelID)
+		}
+	}
+	if len(req.Checklist) == 0 {
+		req.Checklist = tpl.Checklist
+	}
+	if req.Deadline == nil && tpl.DeadlineDays != nil {
+		d := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, *tpl.DeadlineDays)
+		req.Deadline = &d
+	}
+}
+
+// normalizeTemplate trims the text fields, drops empty checklist items and
+// duplicate labels and defaults the priority.
+func normalizeTemplate(req TaskTemplateRequest) (TaskTemplateRequest, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.TitlePattern = strings.TrimSpace(req.TitlePattern)
+	req.Description = strings.TrimSpace(req.Description)
+	if req.Name == "" || len(req.TitlePattern) < 2 {
+		return req, fmt.Errorf("name and title pattern required")
+	}
+	if req.Priority == "" {
+		req.Priority = "MEDIUM"
+	}
+
+	checklist := make([]string, 0, len(req.Checklist))
+	for _, item := range req.Checklist {
+		if item = strings.TrimSpace(item); item != "" {
+			checklist = append(checklist, item)
+		}
+	}
+	req.Checklist = checklist
+
+	slices.Sort(req.LabelIDs)
+	req.LabelIDs = slices.Compact(req.LabelIDs)
+	if req.LabelIDs == nil {
+		req.LabelIDs = []int64{}
+	}
+	return req, nil
+}
+
+// handleTemplateList lists the templates of ?teamid=, or of all the caller's teams.
+func handleTemplateList(c *gin.Context) {
+	var teamIDs []int64
+	if v := c.Query("teamid"); v != "" {
+		teamID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || teamID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid teamid"})
+			return
+		}
+		if err := ensureCanViewTeam(c, teamID); err != nil {
+			respondAuthzError(c, err)
+			return
+		}
+		teamIDs = []int64{teamID}
+	} else {
+		username, ok := mustUsername(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		var err error
+		if teamIDs, err = UserTeamIDs(c.Request.Context(), username); err != nil {
+			log.Printf("failed to list teams: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+	}
+
+	items, err := ListTaskTemplates(c.Request.Context(), teamIDs)
+	if err != nil {
+		log.Printf("failed to list templates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func handleTemplateGet(c *gin.Context) {
+	tpl, ok := loadTemplateFor(c, ensureCanViewTeam)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, tpl)
+}
+
+func handleTemplateCreate(c *gin.Context) {
+	var req TaskTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TeamID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	if err := ensureCanManageTeam(c, req.TeamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+	saveTemplate(c, 0, req)
+}
+
+func handleTemplateUpdate(c *gin.Context) {
+	var req TaskTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	tpl, ok := loadTemplateFor(c, ensureCanManageTeam)
+	if !ok {
+		return
+	}
+	// a template stays with its team
+	req.TeamID = tpl.TeamID
+	saveTemplate(c, tpl.TemplateID, req)
+}
+
+func saveTemplate(c *gin.Context, templateID int64, req TaskTemplateRequest) {
+	req, err := normalizeTemplate(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actor, _ := mustUsername(c)
+	id, err := SaveTaskTemplate(c.Request.Context(), templateID, actor, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, errTemplateExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, errUnknownLabel):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Printf("failed to save template: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	code := http.StatusOK
+	if templateID == 0 {
+		code = http.StatusCreated
+	}
+	c.JSON(code, gin.H{"status": "ok", "templateid": id})
+}
+
+func handleTemplateDelete(c *gin.Context) {
+	tpl, ok := loadTemplateFor(c, ensureCanManageTeam)
+	if !ok {
+		return
+	}
+
+	if err := DeleteTaskTemplate(c.Request.Context(), tpl.TemplateID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+			return
+		}
+		log.Printf("failed to delete template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// loadTemplateFor fetches the template of the :id param and runs check on its team.
+// On failure the response is already written and ok is false.
+func loadTemplateFor(c *gin.Context, check func(*gin.Context, int64) error) (*TaskTemplate, bool) {
+	templateID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || templateID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid template id"})
+		return nil, false
+	}
+
+	tpl, err := GetTaskTemplate(c.Request.Context(), templateID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+			return nil, false
+		}
+		log.Printf("failed to get template: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return nil, false
+	}
+
+	if err := check(c, tpl.TeamID); err != nil {
+		respondAuthzError(c, err)
+		return nil, false
+	}
+	return tpl, true
+}
@@ -0,0 +1,230 @@
+package mtask
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// time tracking
+//
+// Members of a team log the time they spend on its tasks, either with a timer
+// started and stopped from the task or afterwards as a number of minutes.
+// Every user runs at most one timer at a time, the database enforces it with a
+// partial unique index. Reports sum the entries started within a date range
+// per team (for its leader) or per user (for themselves) and put them next to
+// the tasks' estimate_minutes.
+
+// maxReportDays bounds the date range of a time report.
+const maxReportDays = 366
+
+func handleTimeEntryList(c *gin.Context) {
+	taskID, ok := taskIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := loadTaskFor(c, taskID, ensureCanViewTeam); !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad limit"})
+		return
+	}
+
+	entries, err := ListTimeEntries(c.Request.Context(), taskID, normalizeLimit(limit))
+	if err != nil {
+		log.Printf("failed to list time entries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": entries})
+}
+
+func handleTimeLog(c *gin.Context) {
+	taskID, ok := taskIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req TimeLogRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	if req.StartedAt != nil && req.StartedAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time cannot be logged in the future"})
+		return
+	}
+	if _, ok := loadTaskFor(c, taskID, ensureCanViewTeam); !ok {
+		return
+	}
+
+	username, _ := mustUsername(c)
+	entry, err := LogTime(c.Request.Context(), taskID, username, req)
+	if err != nil {
+		log.Printf("failed to log time: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, entry)
+}
+
+func handleTimerGet(c *gin.Context) {
+	username, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	entry, err := RunningTimer(c.Request.Context(), username)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("failed to get timer: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	// running is null when no timer runs
+	c.JSON(http.StatusOK, gin.H{"running": entry})
+}
+
+func handleTimerStart(c *gin.Context) {
+	taskID, ok := taskIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req TimerStartRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+			return
+		}
+	}
+	if _, ok := loadTaskFor(c, taskID, ensureCanViewTeam); !ok {
+		return
+	}
+
+	username, _ := mustUsername(c)
+	entry, err := StartTimer(c.Request.Context(), taskID, username, req.Note, req.Switch)
+	if err != nil {
+		if errors.Is(err, errTimerRunning) {
+			// tell which task the timer runs on, the client may retry with switch
+			running, _ := RunningTimer(c.Request.Context(), username)
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "running": running})
+			return
+		}
+		log.Printf("failed to start timer: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusCreated, entry)
+}
+
+func handleTimerStop(c *gin.Context) {
+	username, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req TimerStopRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+			return
+		}
+	}
+
+	entry, err := StopTimer(c.Request.Context(), username, req.Note)
+	if err != nil {
+		if errors.Is(err, errNoTimer) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("failed to stop timer: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+// handleTimeReport sums logged time between from and to (dates, to included,
+// the last 7 days by default) for ?teamid, which needs its leader, or else for
+// ?username, the caller unless an admin asks for someone else.
+func handleTimeReport(c *gin.Context) {
+	caller, ok := mustUsername(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	from, to, ok := reportRange(c)
+	if !ok {
+		return
+	}
+
+	var (
+		report *TimeReport
+		err    error
+	)
+	if v := c.Query("teamid"); v != "" {
+		teamID, perr := strconv.ParseInt(v, 10, 64)
+		if perr != nil || teamID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid teamid"})
+			return
+		}
+		if err := ensureCanManageTeam(c, teamID); err != nil {
+			respondAuthzError(c, err)
+			return
+		}
+		report, err = TeamTimeReport(c.Request.Context(), teamID, from, to)
+	} else {
+		username := c.DefaultQuery("username", caller)
+		if username != caller && !isAdmin(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		report, err = UserTimeReport(c.Request.Context(), username, from, to)
+	}
+	if err != nil {
+		log.Printf("failed to build time report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// reportRange reads ?from and ?to as an end exclusive range.
+func reportRange(c *gin.Context) (time.Time, time.Time, bool) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, -6), today.AddDate(0, 0, 1)
+
+	f, err := parseDateQuery(c.Query("from"), false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+		return from, to, false
+	}
+	t, err := parseDateQuery(c.Query("to"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+		return from, to, false
+	}
+	if f != nil {
+		from = *f
+	}
+	if t != nil {
+		to = *t
+	}
+
+	if !to.After(from) || to.Sub(from) > maxReportDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date range"})
+		return from, to, false
+	}
+	return from, to, true
+}
@@ -0,0 +1,90 @@
+package mtask
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// trash
+//
+// Deleting a task only sets its deleted_at; every task query skips such tasks,
+// and the tasks of a team in the trash (teams.deleted_at, see mteam) as well.
+// Admins list and restore them, and a background loop deletes them for good
+// once they have been in the trash for TRASH_RETENTION_DAYS.
+
+const trashPurgeInterval = time.Hour
+
+// purgeTrash runs PurgeTrashedTasks every trashPurgeInterval until ctx is done.
+// A retention of 0 days or less keeps the trash forever.
+func purgeTrash(ctx context.Context, retentionDays int) {
+	if retentionDays <= 0 {
+		log.Printf("trash purge disabled")
+		return
+	}
+	retention := time.Duration(retentionDays) * 24 * time.Hour
+
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		n, err := PurgeTrashedTasks(ctx, time.Now().Add(-retention))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("failed to purge trashed tasks: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d tasks trashed more than %d days ago", n, retentionDays)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func handleTrashList(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "200"))
+
+	items, err := ListTrashedTasks(c.Request.Context(), limit)
+	if err != nil {
+		log.Printf("failed to list trashed tasks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":          items,
+		"retention_days": config.TrashRetentionDays,
+	})
+}
+
+func handleTrashRestore(c *gin.Context) {
+	taskID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || taskID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid task id"})
+		return
+	}
+
+	actor, _ := mustUsername(c)
+	err = RestoreTask(c.Request.Context(), taskID, actor)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		case errors.Is(err, errNotInTrash), errors.Is(err, errTeamInTrash), errors.Is(err, errParentInTrash):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("failed to restore task: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
@@ -0,0 +1,184 @@
+package mtask
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// status transitions
+//
+// A transition rule reads FROM>TO:role|role, e.g. "DONE>TODO:leader".
+// FROM and TO are a status name, "*" for any status of the team's workflow or
+// "@done" for the team's terminal status. Rules are checked in order and the
+// first one matching a move decides who may make it.
+// Roles are resolved per team: "leader" is the team leader (or an admin),
+// "student" is any other member. Students may additionally only move tasks
+// assigned to them. Moves no rule matches are rejected with 409.
+
+const (
+	anyStatus      = "*"
+	terminalStatus = "@done"
+)
+
+var defaultTransitions = []string{
+	"@done>*:leader",
+	"*>*:leader|student",
+}
+
+var (
+	errIllegalTransition = errors.New("illegal status transition")
+	errUnknownStatus     = errors.New("unknown status for this team")
+	errNotAssignee       = errors.New("only an assignee may change the status of this task")
+)
+
+type transitionRule struct {
+	from, to string
+	roles    []string
+}
+
+type transitionPolicy []transitionRule
+
+var transitions transitionPolicy
+
+func validStatusPattern(s string) bool {
+	return s == anyStatus || s == terminalStatus || validStatusName(s)
+}
+
+func parseTransitions(rules []string) (transitionPolicy, error) {
+	p := make(transitionPolicy, 0, len(rules))
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		move, roles, ok := strings.Cut(rule, ":")
+		if !ok {
+			return nil, fmt.Errorf("transition %q: missing roles", rule)
+		}
+		from, to, ok := strings.Cut(move, ">")
+		if !ok {
+			return nil, fmt.Errorf("transition %q: expected FROM>TO", rule)
+		}
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !validStatusPattern(from) || !validStatusPattern(to) {
+			return nil, fmt.Errorf("transition %q: invalid status", rule)
+		}
+
+		r := transitionRule{from: from, to: to}
+		for _, role := range strings.Split(roles, "|") {
+			if role = strings.TrimSpace(role); role != "" {
+				r.roles = append(r.roles, role)
+			}
+		}
+		p = append(p, r)
+	}
+	return p, nil
+}
+
+func mustInitTransitions(rules []string) {
+	p, err := parseTransitions(rules)
+	if err != nil {
+		log.Fatalf("invalid status transitions: %v", err)
+	}
+	if len(p) == 0 {
+		// no rule allows any move
+		log.Fatalf("invalid status transitions: no rules in %q", rules)
+	}
+	transitions = p
+}
+
+func matchStatus(pattern, status string, wf Workflow) bool {
+	switch pattern {
+	case anyStatus:
+		return true
+	case terminalStatus:
+		return status == wf.Terminal()
+	default:
+		return pattern == status
+	}
+}
+
+// allowed returns the roles of the first rule matching from->to, ok is false
+// when no rule does.
+func (p transitionPolicy) allowed(from, to string, wf Workflow) ([]string, bool) {
+	for _, r := range p {
+		if matchStatus(r.from, from, wf) && matchStatus(r.to, to, wf) {
+			return r.roles, true
+		}
+	}
+	return nil, false
+}
+
+// checkTransition validates moving task to status for the caller against the
+// workflow of the task's team. Setting the current status again is always accepted.
+func checkTransition(c *gin.Context, task *Task, to string) error {
+	if task.Status == to {
+		return nil
+	}
+
+	wf, err := TeamWorkflow(c.Request.Context(), task.TeamID)
+	if err != nil {
+		log.Printf("TeamWorkflow failed: %v", err)
+		return errAuthzDB
+	}
+	if !wf.Has(to) {
+		return errUnknownStatus
+	}
+
+	roles, ok := transitions.allowed(task.Status, to, wf)
+	if !ok {
+		return errIllegalTransition
+	}
+
+	role := "student"
+	err = ensureCanManageTeam(c, task.TeamID)
+	switch {
+	case err == nil:
+		role = "leader"
+	case errors.Is(err, errAuthzDB):
+		return err
+	}
+
+	for _, r := range roles {
+		if r != role {
+			continue
+		}
+		if role == "student" {
+			if username, _ := mustUsername(c); !task.IsAssignee(username) {
+				return errNotAssignee
+			}
+		}
+		return nil
+	}
+	return errNotAllowed
+}
+
+// respondTransitionError writes the response for an error returned by checkTransition.
+func respondTransitionError(c *gin.Context, task *Task, to string, err error) {
+	c.JSON(transitionErrorResponse(task, to, err))
+}
+
+func transitionErrorResponse(task *Task, to string, err error) (int, gin.H) {
+	switch {
+	case errors.Is(err, errUnknownStatus):
+		return http.StatusBadRequest, gin.H{"error": err.Error(), "status": to}
+	case errors.Is(err, errIllegalTransition):
+		return http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("cannot move task from %s to %s", task.Status, to),
+			"from":  task.Status,
+			"to":    to,
+		}
+	case errors.Is(err, errNotAssignee):
+		return http.StatusForbidden, gin.H{"error": err.Error()}
+	case errors.Is(err, errNotAllowed):
+		return http.StatusForbidden, gin.H{"error": fmt.Sprintf("not allowed to move task from %s to %s", task.Status, to)}
+	default:
+		return authzErrorResponse(err)
+	}
+}
@@ -100,12 +100,19 @@ func setRoutes() {
 	auth.Use(kcAuth.RequireRoles("student", "leader", "admin"))
 	{
 		auth.GET("/my-teams", handleMyTeams)
+		auth.GET("/teams/:teamid", getTeamHandler)
+		auth.GET("/teams/:teamid/workflow", getWorkflowHandler)
+		auth.GET("/teams/:teamid/labels", listLabelsHandler)
 	}
 	leader := root.Group("/leader")
 	leader.Use(kcAuth.RequireRoles("leader", "admin"))
 	{
 		leader.POST("/teams/:teamid/members", addTeamMemberHandler)
 		leader.DELETE("/teams/:teamid/members/:username", removeTeamMemberHandler)
+		leader.PUT("/teams/:teamid/workflow", setWorkflowHandler)
+		leader.POST("/teams/:teamid/labels", createLabelHandler)
+		leader.PUT("/teams/:teamid/labels/:labelid", updateLabelHandler)
+		leader.DELETE("/teams/:teamid/labels/:labelid", deleteLabelHandler)
 	}
 	admin := root.Group("/admin")
 	admin.Use(kcAuth.RequireRoles("admin"))
@@ -114,6 +121,8 @@ func setRoutes() {
 		admin.PUT("/teams", updateHandler)
 		admin.DELETE("/teams", deleteHandler)
 		admin.GET("/teams", getHandler)
+		admin.GET("/teams/trash", listTrashHandler)
+		admin.POST("/teams/:teamid/restore", restoreTeamHandler)
 	}
 }
 
@@ -130,11 +139,14 @@ func InitAndServe(confPath string) {
 
 	// init db conn
 	initDBConn()
+	mustInitKcService()
 
 	// serve http
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	go purgeTrash(ctx, config.TrashRetentionDays)
+
 	server := &http.Server{
 		Addr:              fmt.Sprintf(":%s", config.Port),
 		Handler:           engine,
@@ -41,6 +41,9 @@ type Config struct {
 	DBUser     string
 	DBPassword string
 	DBName     string
+
+	// days a deleted team stays in the trash, see trash.go
+	TrashRetentionDays int
 }
 
 func loadConfig(path string) Config {
@@ -75,6 +78,8 @@ func loadConfig(path string) Config {
 		DBUser:     getEnv("DB_USER", "postgres"),
 		DBPassword: getEnv("DB_PASSWORD", "postgres"),
 		DBName:     getEnv("DB_NAME", "pms"),
+
+		TrashRetentionDays: getIntEnv("TRASH_RETENTION_DAYS", 30),
 	}
 
 	log.Print(config.toString())
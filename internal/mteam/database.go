@@ -4,10 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
+
+	"kyri56xcaesar/pms-proj/internal/utils"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 func CreateTeam(ctx context.Context, name, desc, ownerUsername string) (int64, error) {
@@ -40,8 +45,12 @@ func CreateTeam(ctx context.Context, name, desc, ownerUsername string) (int64, e
 	return id, nil
 }
 
-func DeleteTeam(ctx context.Context, id int64) error {
-	res, err := pool.Exec(ctx, `delete from teams where teamid = $1`, id)
+// DeleteTeam moves a team to the trash, its tasks go with it, see trash.go.
+func DeleteTeam(ctx context.Context, id int64, actor string) error {
+	res, err := pool.Exec(ctx, `
+		update teams set deleted_at = now(), deleted_by = $2
+		where teamid = $1 and deleted_at is null
+	`, id, actor)
 	if err != nil {
 		return err
 	}
@@ -54,19 +63,115 @@ func DeleteTeam(ctx context.Context, id int64) error {
 	return nil
 }
 
-func orderClause(order string) string {
+var errNotInTrash = errors.New("team is not in the trash")
+
+// ListTrashedTeams returns the teams in the trash, most recently deleted first.
+func ListTrashedTeams(ctx context.Context, limit int) ([]TrashedTeam, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT t.teamid, t.name, t.deleted_at, COALESCE(t.deleted_by,''),
+		       (SELECT COUNT(*) FROM tasks k WHERE k.teamid = t.teamid AND k.deleted_at IS NULL)
+		FROM teams t
+		WHERE t.deleted_at IS NOT NULL
+		ORDER BY t.deleted_at DESC, t.teamid DESC
+		LIMIT $1
+	`, normalizeLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[TrashedTeam])
+}
+
+// RestoreTeam takes a team out of the trash, with the tasks that were not
+// deleted on their own.
+func RestoreTeam(ctx context.Context, id int64) error {
+	res, err := pool.Exec(ctx, `
+		UPDATE teams SET deleted_at = NULL, deleted_by = NULL, version = version + 1
+		WHERE teamid = $1 AND deleted_at IS NOT NULL
+	`, id)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() > 0 {
+		return nil
+	}
+
+	// tell a missing team from one that is not in the trash
+	var exists bool
+	err = pool.QueryRow(ctx, `SELECT true FROM teams WHERE teamid = $1`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	return errNotInTrash
+}
+
+// PurgeTrashedTeams deletes for good the teams in the trash since before
+// cutoff; members, statuses, labels and tasks cascade.
+func PurgeTrashedTeams(ctx context.Context, cutoff time.Time) (int64, error) {
+	ct, err := pool.Exec(ctx, `DELETE FROM teams WHERE deleted_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
+}
+
+var errBadCursor = errors.New("bad cursor")
+
+// teamKeyset describes how a team list order (created_desc, created_asc,
+// name_asc, name_desc) sorts and is paged with a cursor, teamid breaks ties.
+type teamKeyset struct {
+	expr string
+	cast string
+	desc bool
+	key  func(Team) string
+}
+
+func teamKeysetFor(order string) teamKeyset {
+	createdKey := func(t Team) string { return t.CreatedAt.Format(time.RFC3339Nano) }
+	nameKey := func(t Team) string { return t.Name }
+
 	switch order {
 	case "created_asc":
-		return "created_at ASC"
+		return teamKeyset{expr: "t.created_at", cast: "timestamptz", key: createdKey}
 	case "name_asc":
-		return "name ASC"
+		return teamKeyset{expr: "COALESCE(t.name,'')", cast: "text", key: nameKey}
 	case "name_desc":
-		return "name DESC"
+		return teamKeyset{expr: "COALESCE(t.name,'')", cast: "text", desc: true, key: nameKey}
 	case "created_desc":
 		fallthrough
 	default:
-		return "created_at DESC"
+		return teamKeyset{expr: "t.created_at", cast: "timestamptz", desc: true, key: createdKey}
+	}
+}
+
+func (k teamKeyset) orderBy() string {
+	if k.desc {
+		return fmt.Sprintf("%s DESC, t.teamid DESC", k.expr)
 	}
+	return fmt.Sprintf("%s ASC, t.teamid ASC", k.expr)
+}
+
+// after returns the condition selecting rows past the cursor bound to $keyArg and $idArg.
+func (k teamKeyset) after(keyArg, idArg int) string {
+	op := ">"
+	if k.desc {
+		op = "<"
+	}
+	return fmt.Sprintf("(%s, t.teamid) %s ($%d::text::%s, $%d)", k.expr, op, keyArg, k.cast, idArg)
+}
+
+// validKey tells whether a cursor key can be cast for expr.
+func (k teamKeyset) validKey(key string) bool {
+	return k.cast != "timestamptz" || utils.ValidCursorTime(key)
+}
+
+// page trims the extra row fetched past limit and returns the cursor of the next page.
+func (k teamKeyset) page(teams []Team, limit int) ([]Team, string) {
+	if len(teams) <= limit {
+		return teams, ""
+	}
+	teams = teams[:limit]
+	last := teams[limit-1]
+	return teams, utils.EncodeCursor(utils.Cursor{Key: k.key(last), ID: last.TeamID})
 }
 
 func normalizeLimit(limit int) int {
@@ -79,32 +184,49 @@ func normalizeLimit(limit int) int {
 	return limit
 }
 
+// ListTeams returns one page of teams. The second return value is the cursor
+// of the next page, empty on the last one.
 func ListTeams(
 	ctx context.Context,
 	teamID *int64,
 	name *string,
 	limit int,
 	order string,
-) ([]Team, error) {
+	cursor string,
+) ([]Team, string, error) {
 
 	limit = normalizeLimit(limit)
-	orderSQL := orderClause(order) // keep your existing
+	ks := teamKeysetFor(order)
 
 	var (
-		where  string
+		conds  = []string{"t.deleted_at IS NULL"}
 		args   []any
 		argIdx = 1
 	)
 
 	if teamID != nil {
-		where = fmt.Sprintf("WHERE t.teamid = $%d", argIdx)
+		conds = append(conds, fmt.Sprintf("t.teamid = $%d", argIdx))
 		args = append(args, *teamID)
 		argIdx++
 	} else if name != nil && strings.TrimSpace(*name) != "" {
-		where = fmt.Sprintf("WHERE t.name ILIKE $%d", argIdx)
+		conds = append(conds, fmt.Sprintf("t.name ILIKE $%d", argIdx))
 		args = append(args, "%"+strings.TrimSpace(*name)+"%")
 		argIdx++
 	}
+	if cursor != "" {
+		cur, err := utils.DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errBadCursor, err)
+		}
+		if !ks.validKey(cur.Key) {
+			return nil, "", fmt.Errorf("%w: bad key %q", errBadCursor, cur.Key)
+		}
+		conds = append(conds, ks.after(argIdx, argIdx+1))
+		args = append(args, cur.Key, cur.ID)
+		argIdx += 2
+	}
+
+	where := "WHERE " + strings.Join(conds, " AND ")
 
 	// LIMIT placeholder is argIdx
 	query := fmt.Sprintf(`
@@ -113,6 +235,7 @@ func ListTeams(
           t.name,
           COALESCE(t.description,'') AS description,
           t.created_at,
+          t.version,
 
           COALESCE((
             SELECT tm.username
@@ -129,9 +252,25 @@ func ListTeams(
               ORDER BY (m.role = 'leader') DESC, m.username
             ) FILTER (WHERE m.username IS NOT NULL),
             '[]'::json
-          ) AS members_json
+          ) AS members_json,
 
+          COALESCE((
+            SELECT json_agg(
+              json_build_object('name', s.name, 'terminal', s.terminal)
+              ORDER BY s.position
+            )
+            FROM team_statuses s
+            WHERE s.teamid = t.teamid
+          ), '[]'::json) AS workflow_json,
 
+          COALESCE((
+            SELECT json_agg(
+              json_build_object('labelid', l.labelid, 'teamid', l.teamid, 'name', l.name, 'color', l.color)
+              ORDER BY lower(l.name)
+            )
+            FROM team_labels l
+            WHERE l.teamid = t.teamid
+          ), '[]'::json) AS labels_json
 
         FROM teams t
         LEFT JOIN team_members m ON m.teamid = t.teamid
@@ -139,44 +278,68 @@ func ListTeams(
         GROUP BY t.teamid
         ORDER BY %s
         LIMIT $%d
-    `, where, orderSQL, argIdx)
+    `, where, ks.orderBy(), argIdx)
 
-	args = append(args, limit)
+	// one extra row tells whether there is a next page
+	args = append(args, limit+1)
 
 	rows, err := pool.Query(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
-	out := make([]Team, 0, limit)
+	out := make([]Team, 0, limit+1)
 	for rows.Next() {
 		var (
-			t           Team
-			membersJSON []byte
+			t            Team
+			membersJSON  []byte
+			workflowJSON []byte
+			labelsJSON   []byte
 		)
 		if err := rows.Scan(
 			&t.TeamID,
 			&t.Name,
 			&t.Description,
 			&t.CreatedAt,
+			&t.Version,
 			&t.Leader,
 			&t.MemberCount,
 			&membersJSON,
+			&workflowJSON,
+			&labelsJSON,
 		); err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 		if err := json.Unmarshal(membersJSON, &t.Members); err != nil {
-			return nil, fmt.Errorf("unmarshal members_json: %w", err)
+			return nil, "", fmt.Errorf("unmarshal members_json: %w", err)
+		}
+		if err := json.Unmarshal(workflowJSON, &t.Workflow); err != nil {
+			return nil, "", fmt.Errorf("unmarshal workflow_json: %w", err)
+		}
+		if err := json.Unmarshal(labelsJSON, &t.Labels); err != nil {
+			return nil, "", fmt.Errorf("unmarshal labels_json: %w", err)
+		}
+		if len(t.Workflow) == 0 {
+			t.Workflow = defaultWorkflow
 		}
 
 		out = append(out, t)
 	}
-	return out, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	out, next := ks.page(out, limit)
+	return out, next, nil
 }
 
-func UpdateTeam(ctx context.Context, teamID int64, req UpdateTeamRequest) error {
+var errVersionMismatch = errors.New("team was changed by someone else")
+
+// UpdateTeam applies req when the team is still at version and returns its new
+// version. A different current version fails with errVersionMismatch.
+func UpdateTeam(ctx context.Context, teamID int64, version int, req UpdateTeamRequest) (int, error) {
 	sets := make([]string, 0, 2)
 	args := make([]any, 0, 3)
 	i := 1
@@ -193,21 +356,31 @@ func UpdateTeam(ctx context.Context, teamID int64, req UpdateTeamRequest) error
 	}
 
 	if len(sets) == 0 {
-		return fmt.Errorf("no fields to update")
+		return 0, fmt.Errorf("no fields to update")
 	}
-
-	// WHERE teamid = $i
-	args = append(args, teamID)
-	q := fmt.Sprintf("UPDATE teams SET %s WHERE teamid = $%d", strings.Join(sets, ", "), i)
-
-	ct, err := pool.Exec(ctx, q, args...)
-	if err != nil {
-		return err
+	sets = append(sets, "version = version + 1")
+
+	// WHERE teamid = $i AND version = $i+1
+	args = append(args, teamID, version)
+	q := fmt.Sprintf("UPDATE teams SET %s WHERE teamid = $%d AND version = $%d AND deleted_at IS NULL RETURNING version", strings.Join(sets, ", "), i, i+1)
+
+	var next int
+	err := pool.QueryRow(ctx, q, args...).Scan(&next)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// missing, or updated since the caller read it
+		var exists bool
+		if err := pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM teams WHERE teamid = $1 AND deleted_at IS NULL)`, teamID).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if exists {
+			return 0, errVersionMismatch
+		}
+		return 0, pgx.ErrNoRows
 	}
-	if ct.RowsAffected() == 0 {
-		return pgx.ErrNoRows
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	return next, nil
 }
 
 func AddMember(ctx context.Context, teamID int64, username, role string) error {
@@ -237,15 +410,33 @@ func RemoveMember(ctx context.Context, teamID int64, username string) error {
 	return nil
 }
 
-func ListTeamsForUser(ctx context.Context, username string, limit int) ([]Team, error) {
+// ListTeamsForUser returns one page of the user's teams, newest first, and the
+// cursor of the next page.
+func ListTeamsForUser(ctx context.Context, username string, limit int, cursor string) ([]Team, string, error) {
 	limit = normalizeLimit(limit)
+	ks := teamKeysetFor("created_desc")
+
+	args := []any{username, limit + 1}
+	after := ""
+	if cursor != "" {
+		cur, err := utils.DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errBadCursor, err)
+		}
+		if !ks.validKey(cur.Key) {
+			return nil, "", fmt.Errorf("%w: bad key %q", errBadCursor, cur.Key)
+		}
+		after = "AND " + ks.after(3, 4)
+		args = append(args, cur.Key, cur.ID)
+	}
 
-	rows, err := pool.Query(ctx, `
+	rows, err := pool.Query(ctx, fmt.Sprintf(`
         SELECT
           t.teamid,
           t.name,
           COALESCE(t.description,'') AS description,
           t.created_at,
+          t.version,
 
           COALESCE((
             SELECT tm.username
@@ -262,7 +453,25 @@ func ListTeamsForUser(ctx context.Context, username string, limit int) ([]Team,
               ORDER BY (m.role = 'leader') DESC, m.username
             ) FILTER (WHERE m.username IS NOT NULL),
             '[]'::json
-          ) AS members_json
+          ) AS members_json,
+
+          COALESCE((
+            SELECT json_agg(
+              json_build_object('name', s.name, 'terminal', s.terminal)
+              ORDER BY s.position
+            )
+            FROM team_statuses s
+            WHERE s.teamid = t.teamid
+          ), '[]'::json) AS workflow_json,
+
+          COALESCE((
+            SELECT json_agg(
+              json_build_object('labelid', l.labelid, 'teamid', l.teamid, 'name', l.name, 'color', l.color)
+              ORDER BY lower(l.name)
+            )
+            FROM team_labels l
+            WHERE l.teamid = t.teamid
+          ), '[]'::json) AS labels_json
 
         FROM teams t
         -- restrict to teams that THIS user belongs to
@@ -273,16 +482,18 @@ func ListTeamsForUser(ctx context.Context, username string, limit int) ([]Team,
         LEFT JOIN team_members m
           ON m.teamid = t.teamid
 
+        WHERE t.deleted_at IS NULL
+        %s
         GROUP BY t.teamid
-        ORDER BY t.created_at DESC
+        ORDER BY %s
         LIMIT $2
-    `, username, limit)
+    `, after, ks.orderBy()), args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
-	out := make([]Team, 0, limit)
+	out := make([]Team, 0, limit+1)
 	for rows.Next() {
 		var t Team
 		if err := rows.Scan(
@@ -290,24 +501,193 @@ func ListTeamsForUser(ctx context.Context, username string, limit int) ([]Team,
 			&t.Name,
 			&t.Description,
 			&t.CreatedAt,
+			&t.Version,
 			&t.Leader,
 			&t.MemberCount,
 			&t.Members,
+			&t.Workflow,
+			&t.Labels,
 		); err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		if len(t.Workflow) == 0 {
+			t.Workflow = defaultWorkflow
 		}
 		out = append(out, t)
 	}
-	return out, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	out, next := ks.page(out, limit)
+	return out, next, nil
 }
 
 func IsMember(ctx context.Context, teamID int64, username string) (bool, error) {
 	var exists bool
 	err := pool.QueryRow(ctx, `
         SELECT EXISTS(
-            SELECT 1 FROM team_members
-            WHERE teamid = $1 AND username = $2
+            SELECT 1 FROM team_members tm
+            JOIN teams t ON t.teamid = tm.teamid AND t.deleted_at IS NULL
+            WHERE tm.teamid = $1 AND tm.username = $2
         )
     `, teamID, username).Scan(&exists)
 	return exists, err
 }
+
+// errStatusesInUse is returned by SetWorkflow when tasks still use statuses
+// that the new workflow drops.
+type errStatusesInUse struct {
+	Statuses []string
+}
+
+func (e *errStatusesInUse) Error() string {
+	return "statuses still in use: " + strings.Join(e.Statuses, ", ")
+}
+
+func GetWorkflow(ctx context.Context, teamID int64) ([]WorkflowStatus, error) {
+	rows, err := pool.Query(ctx, `
+        SELECT name, terminal
+        FROM team_statuses
+        WHERE teamid = $1
+        ORDER BY position
+    `, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]WorkflowStatus, 0, 8)
+	for rows.Next() {
+		var s WorkflowStatus
+		if err := rows.Scan(&s.Name, &s.Terminal); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(out) == 0 {
+		return defaultWorkflow, nil
+	}
+	return out, nil
+}
+
+// SetWorkflow replaces the statuses of a team. Statuses that tasks of the team
+// are still in cannot be dropped.
+func SetWorkflow(ctx context.Context, teamID int64, statuses []WorkflowStatus) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// lock the team so concurrent edits of the workflow serialize
+	var id int64
+	err = tx.QueryRow(ctx, `SELECT teamid FROM teams WHERE teamid = $1 FOR UPDATE`, teamID).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(statuses))
+	for _, s := range statuses {
+		names = append(names, s.Name)
+	}
+
+	// tasks lives in the same database (owned by mtask)
+	rows, err := tx.Query(ctx, `
+        SELECT DISTINCT status
+        FROM tasks
+        WHERE teamid = $1 AND status <> ALL($2)
+        ORDER BY status
+    `, teamID, names)
+	if err != nil {
+		return err
+	}
+	inUse, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+	if len(inUse) > 0 {
+		return &errStatusesInUse{Statuses: inUse}
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM team_statuses WHERE teamid = $1`, teamID); err != nil {
+		return err
+	}
+	for i, s := range statuses {
+		_, err := tx.Exec(ctx, `
+            INSERT INTO team_statuses (teamid, name, position, terminal)
+            VALUES ($1, $2, $3, $4)
+        `, teamID, s.Name, i, s.Terminal)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+var errLabelExists = errors.New("a label with this name already exists")
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func ListLabels(ctx context.Context, teamID int64) ([]Label, error) {
+	rows, err := pool.Query(ctx, `
+        SELECT labelid, teamid, name, color
+        FROM team_labels
+        WHERE teamid = $1
+        ORDER BY lower(name)
+    `, teamID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Label])
+}
+
+func CreateLabel(ctx context.Context, teamID int64, name, color string) (int64, error) {
+	var id int64
+	err := pool.QueryRow(ctx, `
+        INSERT INTO team_labels (teamid, name, color)
+        VALUES ($1, $2, $3)
+        RETURNING labelid
+    `, teamID, name, color).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, errLabelExists
+	}
+	return id, err
+}
+
+func UpdateLabel(ctx context.Context, teamID, labelID int64, name, color string) error {
+	ct, err := pool.Exec(ctx, `
+        UPDATE team_labels SET name = $1, color = $2
+        WHERE teamid = $3 AND labelid = $4
+    `, name, color, teamID, labelID)
+	if isUniqueViolation(err) {
+		return errLabelExists
+	}
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteLabel removes the label from the team, task_labels rows go with it.
+func DeleteLabel(ctx context.Context, teamID, labelID int64) error {
+	ct, err := pool.Exec(ctx, `DELETE FROM team_labels WHERE teamid = $1 AND labelid = $2`, teamID, labelID)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -2,7 +2,12 @@ create table if not exists teams (
     teamid bigint generated always as identity primary key,
     name text,
     description text,
-    created_at timestamptz not null default now()
+    created_at timestamptz not null default now(),
+    -- bumped by every update of name or description, sent as ETag
+    version integer not null default 1,
+    -- set while the team is in the trash, purged after TRASH_RETENTION_DAYS
+    deleted_at timestamptz,
+    deleted_by text
 );
 
 create table if not exists team_members (
@@ -13,6 +18,24 @@ create table if not exists team_members (
   primary key (teamid, username)
 );
 
+-- ordered task statuses of a team; teams without rows use TODO, IN_PROGRESS, DONE
+create table if not exists team_statuses (
+  teamid   bigint not null references teams(teamid) on delete cascade,
+  name     text not null,
+  position int not null,
+  terminal boolean not null default false,
+  primary key (teamid, name)
+);
+
+-- labels tasks of a team can be tagged with (task_labels is owned by mtask)
+create table if not exists team_labels (
+  labelid  bigint generated always as identity primary key,
+  teamid   bigint not null references teams(teamid) on delete cascade,
+  name     text not null,
+  color    text not null,
+  created_at timestamptz not null default now()
+);
+
 create index if not exists idx_team_members_username on team_members(username);
 
 CREATE UNIQUE INDEX IF NOT EXISTS team_one_leader_per_team
@@ -21,3 +44,16 @@ WHERE role = 'leader';
 
 CREATE UNIQUE INDEX IF NOT EXISTS team_members_unique
 ON team_members(teamid, username);
+
+CREATE UNIQUE INDEX IF NOT EXISTS team_statuses_one_terminal
+ON team_statuses(teamid)
+WHERE terminal;
+
+CREATE UNIQUE INDEX IF NOT EXISTS team_labels_unique_name
+ON team_labels(teamid, lower(name));
+
+-- upgrades for databases created before the columns existed
+alter table teams add column if not exists version integer not null default 1;
+alter table teams add column if not exists deleted_at timestamptz;
+alter table teams add column if not exists deleted_by text;
+create index if not exists idx_teams_deleted_at on teams(deleted_at) where deleted_at is not null;
@@ -7,9 +7,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 
+	"kyri56xcaesar/pms-proj/internal/utils"
+
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
 )
@@ -25,6 +28,12 @@ func createHandler(c *gin.Context) {
 	authorAny, _ := c.Get("kc.username")
 	createdBy := authorAny.(string)
 
+	leader, err := lookupUser(c.Request.Context(), strings.TrimSpace(req.Leader))
+	if err != nil {
+		respondLookupError(c, "leader", req.Leader, err)
+		return
+	}
+
 	teamID, err := CreateTeam(c.Request.Context(), req.Name, req.Description, createdBy)
 	if err != nil {
 		log.Printf("failed to create the entity: %v", err)
@@ -33,7 +42,7 @@ func createHandler(c *gin.Context) {
 	}
 
 	// Assign selected leader as member(role=leader)
-	if err := AddMember(c.Request.Context(), teamID, req.Leader, "leader"); err != nil {
+	if err := AddMember(c.Request.Context(), teamID, leader, "leader"); err != nil {
 		log.Printf("failed to add leader member: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error (add leader)"})
 		return
@@ -56,7 +65,18 @@ func updateHandler(c *gin.Context) {
 		return
 	}
 
-	err = UpdateTeam(c.Request.Context(), teamID, req)
+	tag := c.GetHeader("If-Match")
+	if tag == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header required"})
+		return
+	}
+	version, ok := utils.ParseETag(tag)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid If-Match header"})
+		return
+	}
+
+	next, err := UpdateTeam(c.Request.Context(), teamID, version, req)
 	if err != nil {
 		if strings.Contains(err.Error(), "no fields to update") {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "provide name and/or description"})
@@ -66,11 +86,37 @@ func updateHandler(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "team not found"})
 			return
 		}
+		if errors.Is(err, errVersionMismatch) {
+			respondStaleTeam(c, teamID)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	c.Header("ETag", utils.ETag(next))
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "version": next})
+}
+
+// respondStaleTeam answers an update made against an old version with 412 and
+// the team as it is now.
+func respondStaleTeam(c *gin.Context, teamID int64) {
+	teams, _, err := ListTeams(c.Request.Context(), &teamID, nil, 1, "", "")
+	if err != nil {
+		log.Printf("failed to retrieve team: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if len(teams) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "team not found"})
+		return
+	}
+
+	c.Header("ETag", utils.ETag(teams[0].Version))
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":   errVersionMismatch.Error(),
+		"current": teams[0],
+	})
 }
 
 func deleteHandler(c *gin.Context) {
@@ -82,7 +128,8 @@ func deleteHandler(c *gin.Context) {
 		return
 	}
 
-	err = DeleteTeam(c.Request.Context(), id)
+	actor, _ := mustUsername(c)
+	err = DeleteTeam(c.Request.Context(), id, actor)
 	if errors.Is(err, sql.ErrNoRows) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "team not found"})
 
@@ -122,21 +169,31 @@ func getHandler(c *gin.Context) {
 		name = &nameStr
 	}
 
-	teams, err := ListTeams(ctx, teamID, name, limit, order)
+	teams, next, err := ListTeams(ctx, teamID, name, limit, order, c.Query("cursor"))
 	if err != nil {
+		if errors.Is(err, errBadCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad cursor"})
+
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 
 		return
 	}
 
 	payload := gin.H{
-		"items": teams,
-		"limit": normalizeLimit(limit),
-		"order": order,
+		"items":       teams,
+		"limit":       normalizeLimit(limit),
+		"order":       order,
+		"next_cursor": next,
 	}
 
 	if teamID != nil {
 		payload["teamid"] = *teamID
+		// a single team can be updated against its version
+		if len(teams) == 1 {
+			c.Header("ETag", utils.ETag(teams[0].Version))
+		}
 	}
 	if name != nil {
 		payload["name"] = *name
@@ -163,15 +220,20 @@ func handleMyTeams(c *gin.Context) {
 	}
 
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	teams, err := ListTeamsForUser(c.Request.Context(), username, limit)
+	teams, next, err := ListTeamsForUser(c.Request.Context(), username, limit, c.Query("cursor"))
 	if err != nil {
+		if errors.Is(err, errBadCursor) {
+			c.JSON(400, gin.H{"error": "bad cursor"})
+
+			return
+		}
 		log.Printf("failed to retrieve data: %v", err)
 		c.JSON(500, gin.H{"error": "db error"})
 
 		return
 	}
 
-	payload := gin.H{"items": teams, "limit": limit}
+	payload := gin.H{"items": teams, "limit": limit, "next_cursor": next}
 	c.JSON(http.StatusOK, payload)
 }
 
@@ -204,11 +266,17 @@ func addTeamMemberHandler(c *gin.Context) {
 
 	// Optional but recommended: leader-only can manage only their teams
 	if err := ensureCanManageTeam(c, teamID); err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		respondAuthzError(c, err)
+		return
+	}
+
+	username, err := lookupUser(c.Request.Context(), strings.TrimSpace(req.Username))
+	if err != nil {
+		respondLookupError(c, "username", req.Username, err)
 		return
 	}
 
-	if err := AddMember(c.Request.Context(), teamID, req.Username, role); err != nil {
+	if err := AddMember(c.Request.Context(), teamID, username, role); err != nil {
 		log.Printf("add member failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 		return
@@ -232,7 +300,7 @@ func removeTeamMemberHandler(c *gin.Context) {
 	}
 
 	if err := ensureCanManageTeam(c, teamID); err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		respondAuthzError(c, err)
 		return
 	}
 
@@ -262,7 +330,7 @@ func ensureCanManageTeam(c *gin.Context, teamID int64) error {
 	ok, err := IsLeaderOfTeam(c.Request.Context(), teamID, username)
 	if err != nil {
 		log.Printf("IsLeaderOfTeam failed: %v", err)
-		return fmt.Errorf("db error")
+		return errAuthzDB
 	}
 	if !ok {
 		return fmt.Errorf("not allowed")
@@ -274,9 +342,319 @@ func IsLeaderOfTeam(ctx context.Context, teamID int64, username string) (bool, e
 	var exists bool
 	err := pool.QueryRow(ctx, `
         SELECT EXISTS (
-            SELECT 1 FROM team_members
-            WHERE teamid = $1 AND username = $2 AND role = 'leader'
+            SELECT 1 FROM team_members tm
+            JOIN teams t ON t.teamid = tm.teamid AND t.deleted_at IS NULL
+            WHERE tm.teamid = $1 AND tm.username = $2 AND tm.role = 'leader'
         )
     `, teamID, username).Scan(&exists)
 	return exists, err
 }
+
+var statusNameRe = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,31}$`)
+
+// normalizeWorkflow upper-cases the status names and checks that they are
+// well formed, unique and that exactly one of them is terminal.
+func normalizeWorkflow(statuses []WorkflowStatus) ([]WorkflowStatus, error) {
+	out := make([]WorkflowStatus, 0, len(statuses))
+	seen := make(map[string]bool, len(statuses))
+	terminals := 0
+
+	for _, s := range statuses {
+		name := strings.ToUpper(strings.TrimSpace(s.Name))
+		if !statusNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid status name %q", s.Name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate status %q", name)
+		}
+		seen[name] = true
+		if s.Terminal {
+			terminals++
+		}
+		out = append(out, WorkflowStatus{Name: name, Terminal: s.Terminal})
+	}
+
+	if terminals != 1 {
+		return nil, fmt.Errorf("exactly one status must be terminal")
+	}
+	return out, nil
+}
+
+func getWorkflowHandler(c *gin.Context) {
+	teamID, err := strconv.ParseInt(c.Param("teamid"), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid teamid"})
+		return
+	}
+
+	if err := ensureCanViewTeam(c, teamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	statuses, err := GetWorkflow(c.Request.Context(), teamID)
+	if err != nil {
+		log.Printf("get workflow failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"teamid": teamID, "statuses": statuses})
+}
+
+func setWorkflowHandler(c *gin.Context) {
+	teamID, err := strconv.ParseInt(c.Param("teamid"), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid teamid"})
+		return
+	}
+
+	var req UpdateWorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	statuses, err := normalizeWorkflow(req.Statuses)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ensureCanManageTeam(c, teamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	err = SetWorkflow(c.Request.Context(), teamID, statuses)
+	if err != nil {
+		var inUse *errStatusesInUse
+		if errors.As(err, &inUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": inUse.Error(), "statuses": inUse.Statuses})
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "team not found"})
+			return
+		}
+		log.Printf("set workflow failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "statuses": statuses})
+}
+
+// errAuthzDB is returned by the ensure* checks when the membership lookup
+// failed, it is answered with 500 instead of 403.
+var errAuthzDB = errors.New("db error")
+
+// respondAuthzError writes the response for an error returned by one of the ensure* checks.
+func respondAuthzError(c *gin.Context, err error) {
+	if errors.Is(err, errAuthzDB) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+}
+
+// ensureCanViewTeam allows admins and members of the team.
+func ensureCanViewTeam(c *gin.Context, teamID int64) error {
+	rolesAny, _ := c.Get("kc.roles")
+	roles, _ := rolesAny.([]string)
+	for _, r := range roles {
+		if r == "admin" {
+			return nil
+		}
+	}
+
+	username, ok := mustUsername(c)
+	if !ok {
+		return fmt.Errorf("not allowed")
+	}
+
+	ok, err := IsMember(c.Request.Context(), teamID, username)
+	if err != nil {
+		log.Printf("IsMember failed: %v", err)
+		return errAuthzDB
+	}
+	if !ok {
+		return fmt.Errorf("not allowed")
+	}
+	return nil
+}
+
+// normalizeLabel trims the name and lower-cases the colour.
+func normalizeLabel(req LabelRequest) (LabelRequest, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Color = strings.ToLower(strings.TrimSpace(req.Color))
+	if req.Name == "" {
+		return req, fmt.Errorf("label name required")
+	}
+	if len(req.Color) != 7 {
+		return req, fmt.Errorf("colour must be #rrggbb")
+	}
+	return req, nil
+}
+
+// getTeamHandler returns one team to its members and admins, with its version as ETag.
+func getTeamHandler(c *gin.Context) {
+	teamID, err := strconv.ParseInt(c.Param("teamid"), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid teamid"})
+		return
+	}
+
+	if err := ensureCanViewTeam(c, teamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	teams, _, err := ListTeams(c.Request.Context(), &teamID, nil, 1, "", "")
+	if err != nil {
+		log.Printf("failed to retrieve team: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if len(teams) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "team not found"})
+		return
+	}
+
+	c.Header("ETag", utils.ETag(teams[0].Version))
+	c.JSON(http.StatusOK, teams[0])
+}
+
+func listLabelsHandler(c *gin.Context) {
+	teamID, err := strconv.ParseInt(c.Param("teamid"), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid teamid"})
+		return
+	}
+
+	if err := ensureCanViewTeam(c, teamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	labels, err := ListLabels(c.Request.Context(), teamID)
+	if err != nil {
+		log.Printf("list labels failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"teamid": teamID, "items": labels})
+}
+
+func createLabelHandler(c *gin.Context) {
+	teamID, err := strconv.ParseInt(c.Param("teamid"), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid teamid"})
+		return
+	}
+
+	var req LabelRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	req, err = normalizeLabel(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ensureCanManageTeam(c, teamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	id, err := CreateLabel(c.Request.Context(), teamID, req.Name, req.Color)
+	if err != nil {
+		if errors.Is(err, errLabelExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("create label failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"status": "ok", "labelid": id})
+}
+
+func updateLabelHandler(c *gin.Context) {
+	teamID, err := strconv.ParseInt(c.Param("teamid"), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid teamid"})
+		return
+	}
+	labelID, err := strconv.ParseInt(c.Param("labelid"), 10, 64)
+	if err != nil || labelID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid labelid"})
+		return
+	}
+
+	var req LabelRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+	req, err = normalizeLabel(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := ensureCanManageTeam(c, teamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	err = UpdateLabel(c.Request.Context(), teamID, labelID, req.Name, req.Color)
+	if err != nil {
+		switch {
+		case errors.Is(err, errLabelExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, pgx.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "label not found"})
+		default:
+			log.Printf("update label failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func deleteLabelHandler(c *gin.Context) {
+	teamID, err := strconv.ParseInt(c.Param("teamid"), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid teamid"})
+		return
+	}
+	labelID, err := strconv.ParseInt(c.Param("labelid"), 10, 64)
+	if err != nil || labelID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid labelid"})
+		return
+	}
+
+	if err := ensureCanManageTeam(c, teamID); err != nil {
+		respondAuthzError(c, err)
+		return
+	}
+
+	if err := DeleteLabel(c.Request.Context(), teamID, labelID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "label not found"})
+			return
+		}
+		log.Printf("delete label failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
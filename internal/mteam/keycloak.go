@@ -1 +1,70 @@
 package mteam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	auth "kyri56xcaesar/pms-proj/internal/authmw"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Usernames are checked against Keycloak before they become team members, so
+// a typo is refused instead of leaving a member nobody can log in as.
+
+var kcService *auth.Service
+
+func mustInitKcService() {
+	var err error
+	kcService, err = auth.NewService(
+		config.AuthAddress,
+		config.Realm,
+		config.ClientID,
+		config.Issuer,
+		config.Audience,
+		config.ClientSecret,
+	)
+	if err != nil {
+		log.Fatalf("failed to connect to KC: %v", err)
+	}
+}
+
+var errUnknownUser = errors.New("no such user")
+
+// lookupUser returns the username as Keycloak knows it, or errUnknownUser.
+func lookupUser(ctx context.Context, username string) (string, error) {
+	jwt, err := kcService.LoginAdmin(ctx)
+	if err != nil {
+		return "", fmt.Errorf("login admin: %w", err)
+	}
+
+	u, err := kcService.GetUserByUsername(ctx, jwt.AccessToken, username)
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return "", errUnknownUser
+		}
+		return "", err
+	}
+	if u.Username == nil {
+		return "", errUnknownUser
+	}
+	return *u.Username, nil
+}
+
+// respondLookupError writes a failed lookupUser of the field's username: a 422
+// naming the field and the invalid value, the front shows it next to the input.
+func respondLookupError(c *gin.Context, field, username string, err error) {
+	if errors.Is(err, errUnknownUser) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   fmt.Sprintf("no user named %q", username),
+			"field":   field,
+			"invalid": []string{username},
+		})
+		return
+	}
+	log.Printf("user lookup failed: %v", err)
+	c.JSON(http.StatusBadGateway, gin.H{"error": "keycloak unavailable"})
+}
@@ -7,10 +7,44 @@ type Team struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	CreatedAt   time.Time `json:"created_at"`
+	Version     int       `json:"version"`
 
-	Leader      string       `json:"leader,omitempty"`
-	MemberCount int          `json:"memberCount"`
-	Members     []TeamMember `json:"members,omitempty"`
+	Leader      string           `json:"leader,omitempty"`
+	MemberCount int              `json:"memberCount"`
+	Members     []TeamMember     `json:"members,omitempty"`
+	Workflow    []WorkflowStatus `json:"workflow"`
+	Labels      []Label          `json:"labels"`
+}
+
+// WorkflowStatus is one status of a team's workflow, in board order.
+// Exactly one status of a workflow is terminal (the "done" column).
+type WorkflowStatus struct {
+	Name     string `json:"name" binding:"required,max=32"`
+	Terminal bool   `json:"terminal"`
+}
+
+// defaultWorkflow applies to teams that never defined their own.
+var defaultWorkflow = []WorkflowStatus{
+	{Name: "TODO"},
+	{Name: "IN_PROGRESS"},
+	{Name: "DONE", Terminal: true},
+}
+
+type UpdateWorkflowRequest struct {
+	Statuses []WorkflowStatus `json:"statuses" binding:"required,min=1,max=20,dive"`
+}
+
+// Label is a team scoped tag for tasks, names are unique per team ignoring case.
+type Label struct {
+	LabelID int64  `json:"labelid"`
+	TeamID  int64  `json:"teamid"`
+	Name    string `json:"name"`
+	Color   string `json:"color"` // #rrggbb
+}
+
+type LabelRequest struct {
+	Name  string `json:"name" form:"name" binding:"required,min=1,max=32"`
+	Color string `json:"color" form:"color" binding:"required,hexcolor"`
 }
 
 type TeamMember struct {
@@ -36,7 +70,7 @@ type Task struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	Author      string    `json:"author"`
-	Assignee    string    `json:"assignee"`
+	Assignees   []string  `json:"assignees"`
 	Status      string    `json:"status"`
 	Deadline    time.Time `json:"deadline"`
 	Priority    string    `json:"priority"`
@@ -47,3 +81,13 @@ type AddTeamMemberRequest struct {
 	Username string `json:"username" binding:"required"`
 	Role     string `json:"role"` // optional; default member
 }
+
+// TrashedTeam is a team in the trash, see trash.go.
+type TrashedTeam struct {
+	TeamID    int64     `json:"teamid"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by"`
+	// tasks that come back with the team
+	TaskCount int `json:"task_count"`
+}
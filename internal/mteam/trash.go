@@ -0,0 +1,89 @@
+package mteam
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// trash
+//
+// Deleting a team only sets its deleted_at; team queries skip such teams and
+// mtask hides their tasks. Admins list and restore them, and a background loop
+// deletes them for good, tasks included, after TRASH_RETENTION_DAYS.
+
+const trashPurgeInterval = time.Hour
+
+// purgeTrash runs PurgeTrashedTeams every trashPurgeInterval until ctx is done.
+// A retention of 0 days or less keeps the trash forever.
+func purgeTrash(ctx context.Context, retentionDays int) {
+	if retentionDays <= 0 {
+		log.Printf("trash purge disabled")
+		return
+	}
+	retention := time.Duration(retentionDays) * 24 * time.Hour
+
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		n, err := PurgeTrashedTeams(ctx, time.Now().Add(-retention))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("failed to purge trashed teams: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d teams trashed more than %d days ago", n, retentionDays)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func listTrashHandler(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "200"))
+
+	items, err := ListTrashedTeams(c.Request.Context(), limit)
+	if err != nil {
+		log.Printf("failed to list trashed teams: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":          items,
+		"retention_days": config.TrashRetentionDays,
+	})
+}
+
+func restoreTeamHandler(c *gin.Context) {
+	teamID, err := strconv.ParseInt(c.Param("teamid"), 10, 64)
+	if err != nil || teamID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid teamid"})
+		return
+	}
+
+	err = RestoreTeam(c.Request.Context(), teamID)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows), errors.Is(err, sql.ErrNoRows):
+			c.JSON(http.StatusNotFound, gin.H{"error": "team not found"})
+		case errors.Is(err, errNotInTrash):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("failed to restore team: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}